@@ -94,7 +94,7 @@ func connect(ctx *cli.Context) error {
 		return errors.Wrap(err, "unable to parse ENR")
 	}
 
-	details, chain, err := host.Connect(enr.GetHostInfo())
+	details, chain, timings, err := host.Connect(enr.GetHostInfo())
 	if err != nil {
 		logrus.Info("Couldn't connect to Node: ", enr.ID, ": ", err)
 		return nil
@@ -117,5 +117,10 @@ func connect(ctx *cli.Context) error {
 	logrus.Info("Node's ProtocolVersion: ", chain.ProtocolVersion)
 	logrus.Info("Node's HeadHash: ", chain.HeadHash)
 	logrus.Info("Node's TotalDiff: ", chain.TotalDifficulty)
+	// connection phases
+	logrus.Info("TCP connect: ", timings.TCP)
+	logrus.Info("RLPx handshake: ", timings.RLPx)
+	logrus.Info("Hello handshake: ", timings.Hello)
+	logrus.Info("Status handshake: ", timings.Status)
 	return nil
 }
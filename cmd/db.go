@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	migrate "github.com/golang-migrate/migrate/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/cortze/ragno/crawler"
+	"github.com/cortze/ragno/db"
+)
+
+var dbOptions struct {
+	lvl        string
+	dbEndpoint string
+	all        bool
+}
+
+var migrateFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:        "log-level",
+		Aliases:     []string{"v"},
+		Usage:       "sets the verbosity of the logs",
+		Value:       "info",
+		EnvVars:     []string{"LOG_LEVEL"},
+		Destination: &dbOptions.lvl,
+	},
+	&cli.StringFlag{
+		Name:        "db-endpoint",
+		Usage:       "Endpoint of the database to migrate (postgres://... or sqlite://<file>)",
+		Value:       crawler.DefaultDBEndpoint,
+		EnvVars:     []string{"DB_URL"},
+		Destination: &dbOptions.dbEndpoint,
+	},
+}
+
+var DBCmd = &cli.Command{
+	Name:  "db",
+	Usage: "manage the database where the crawls are stored",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "migrate",
+			Usage: "apply, revert or inspect the schema migrations embedded in ragno",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "up",
+					Usage:     "apply all the pending migrations, or the next N of them",
+					ArgsUsage: "[N]",
+					Flags:     migrateFlags,
+					Action:    migrateUp,
+				},
+				{
+					Name:      "down",
+					Usage:     "revert the last N migrations (1 by default)",
+					ArgsUsage: "[N]",
+					Flags: append([]cli.Flag{
+						&cli.BoolFlag{
+							Name:        "all",
+							Usage:       "revert all the migrations, dropping every table",
+							Destination: &dbOptions.all,
+						},
+					}, migrateFlags...),
+					Action: migrateDown,
+				},
+				{
+					Name:   "version",
+					Usage:  "print the current schema version and whether the last migration failed (dirty)",
+					Flags:  migrateFlags,
+					Action: migrateVersion,
+				},
+				{
+					Name:      "force",
+					Usage:     "set the schema version without running any migration, clearing the dirty flag (after fixing a failed migration by hand)",
+					ArgsUsage: "VERSION",
+					Flags:     migrateFlags,
+					Action:    migrateForce,
+				},
+			},
+		},
+	},
+}
+
+func migrateUp(ctx *cli.Context) error {
+	steps, err := stepsArg(ctx, 0)
+	if err != nil {
+		return err
+	}
+	return withMigrator(func(m *migrate.Migrate) error {
+		if steps > 0 {
+			return m.Steps(steps)
+		}
+		return m.Up()
+	})
+}
+
+func migrateDown(ctx *cli.Context) error {
+	steps, err := stepsArg(ctx, 1)
+	if err != nil {
+		return err
+	}
+	return withMigrator(func(m *migrate.Migrate) error {
+		if dbOptions.all {
+			return m.Down()
+		}
+		return m.Steps(-steps)
+	})
+}
+
+func migrateVersion(ctx *cli.Context) error {
+	// the version is printed once the migrator is closed
+	return withMigrator(func(m *migrate.Migrate) error { return nil })
+}
+
+func migrateForce(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return errors.New("the version to force is required")
+	}
+	version, err := strconv.Atoi(ctx.Args().First())
+	if err != nil {
+		return errors.Wrap(err, "invalid version "+ctx.Args().First())
+	}
+	return withMigrator(func(m *migrate.Migrate) error {
+		return m.Force(version)
+	})
+}
+
+// withMigrator runs the given operation over the schema of the database, printing the
+// resulting version
+func withMigrator(operation func(*migrate.Migrate) error) error {
+	logrus.SetLevel(crawler.ParseLogLevel(dbOptions.lvl))
+	m, err := db.NewMigrator(dbOptions.dbEndpoint)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	err = operation(m)
+	switch err {
+	case nil:
+	case migrate.ErrNoChange:
+		logrus.Info("no migration to apply")
+	default:
+		if _, ok := err.(migrate.ErrDirty); ok {
+			return errors.Wrap(err, "the last migration failed, fix it and set the version with 'ragno db migrate force'")
+		}
+		return errors.Wrap(err, "unable to migrate the database")
+	}
+
+	version, dirty, err := m.Version()
+	switch err {
+	case nil:
+		fmt.Printf("version: %d (dirty: %t)\n", version, dirty)
+	case migrate.ErrNilVersion:
+		fmt.Println("version: none (no migration applied)")
+	default:
+		return errors.Wrap(err, "unable to read the schema version")
+	}
+	return nil
+}
+
+// stepsArg returns the number of migrations given as argument, or the default one
+func stepsArg(ctx *cli.Context, defaultSteps int) (int, error) {
+	if ctx.NArg() == 0 {
+		return defaultSteps, nil
+	}
+	steps, err := strconv.Atoi(ctx.Args().First())
+	if err != nil || steps <= 0 {
+		return 0, fmt.Errorf("invalid number of migrations %s", ctx.Args().First())
+	}
+	return steps, nil
+}
@@ -26,7 +26,7 @@ var RunCommand = &cli.Command{
 		},
 		&cli.StringFlag{
 			Name:        "db-endpoint",
-			Usage:       "Endpoint of the database that where the results of the crawl will be stored (needs to be initialized from before)",
+			Usage:       "Endpoint of the database that where the results of the crawl will be stored (postgres://... or sqlite://<file> for a SQLite file)",
 			EnvVars:     []string{"DB_URL"},
 			DefaultText: crawler.DefaultDBEndpoint,
 		},
@@ -93,6 +93,132 @@ var RunCommand = &cli.Command{
 			Aliases: []string{"dt"},
 			EnvVars: []string{"DEPRECATION_TIME"},
 		},
+		&cli.StringSliceFlag{
+			Name:    "discovery",
+			Usage:   "Discovery sources to feed the crawler with (discv4, discv4-crawl, discv5, dns, enrtree://<tree-url> or a path to a .csv file)",
+			EnvVars: []string{"DISCOVERY"},
+		},
+		&cli.IntFlag{
+			Name:    "discv5-port",
+			Usage:   "UDP port that will be used by the discv5 discoverer",
+			EnvVars: []string{"DISCV5_PORT"},
+		},
+		&cli.StringFlag{
+			Name:        "network",
+			Usage:       "Network that will be crawled (mainnet, sepolia, holesky or custom)",
+			EnvVars:     []string{"NETWORK"},
+			DefaultText: crawler.DefaultNetwork,
+		},
+		&cli.StringFlag{
+			Name:    "genesis-file",
+			Usage:   "Path to the genesis JSON file of the custom network",
+			EnvVars: []string{"GENESIS_FILE"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "bootnodes",
+			Usage:   "Comma separated list of enodes to bootstrap the discovery with (overrides the ones of the network)",
+			EnvVars: []string{"BOOTNODES"},
+		},
+		&cli.StringFlag{
+			Name:    "node-key",
+			Usage:   "Path to the file with the node key of the crawler (created on the first run, a random key is used if empty)",
+			EnvVars: []string{"NODE_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "data-dir",
+			Usage:   "Directory where the discovery node databases (and the node key, if no other is given) are kept",
+			EnvVars: []string{"DATA_DIR"},
+		},
+		&cli.StringFlag{
+			Name:    "dead-letter-file",
+			Usage:   "File where the queries that can't be persisted are appended (<data-dir>/dead-letters.jsonl by default)",
+			EnvVars: []string{"DEAD_LETTER_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "attempts-retention",
+			Usage:   "Time the connection attempts are kept before rolling them up into daily summaries per node (postgres only, kept forever if 0)",
+			EnvVars: []string{"ATTEMPTS_RETENTION"},
+		},
+		&cli.StringFlag{
+			Name:    "neighbours-retention",
+			Usage:   "Time the neighbour relationships are kept since the last time they were seen (postgres only, kept forever if 0)",
+			EnvVars: []string{"NEIGHBOURS_RETENTION"},
+		},
+		&cli.BoolFlag{
+			Name:    "inbound",
+			Usage:   "Accept and identify the RLPx connections that other nodes open to the host port",
+			EnvVars: []string{"INBOUND"},
+		},
+		&cli.IntFlag{
+			Name:    "max-inbound",
+			Usage:   "Maximum number of inbound connections that are identified at the same time",
+			EnvVars: []string{"MAX_INBOUND"},
+		},
+		&cli.BoolFlag{
+			Name:    "probe-head",
+			Usage:   "Request the head header of the nodes to know their head block and whether they are synced",
+			EnvVars: []string{"PROBE_HEAD"},
+		},
+		&cli.IntFlag{
+			Name:    "keep-alive",
+			Usage:   "Number of connections kept open to measure the sessions of the nodes (0 to disable)",
+			EnvVars: []string{"KEEP_ALIVE"},
+		},
+		&cli.BoolFlag{
+			Name:    "tx-sampling",
+			Usage:   "Record the tx announcements that the nodes send over the keep-alive sessions",
+			EnvVars: []string{"TX_SAMPLING"},
+		},
+		&cli.BoolFlag{
+			Name:    "les",
+			Usage:   "Offer the les subprotocols besides eth and snap",
+			EnvVars: []string{"LES"},
+		},
+		&cli.BoolFlag{
+			Name:    "probe-snap",
+			Usage:   "Request a range of accounts to the nodes that negotiate snap to verify that they serve snap data",
+			EnvVars: []string{"PROBE_SNAP"},
+		},
+		&cli.StringFlag{
+			Name:    "ip-dial-limit",
+			Usage:   "Max concurrent dials and dials per minute to the nodes behind the same IP (<concurrent>/<per-minute>, 0 for no limit)",
+			EnvVars: []string{"IP_DIAL_LIMIT"},
+		},
+		&cli.StringFlag{
+			Name:    "subnet-dial-limit",
+			Usage:   "Max concurrent dials and dials per minute to the nodes in the same /24 (/48 for IPv6)",
+			EnvVars: []string{"SUBNET_DIAL_LIMIT"},
+		},
+		&cli.StringFlag{
+			Name:    "asn-dial-limit",
+			Usage:   "Max concurrent dials and dials per minute to the nodes in the same ASN (for the located IPs)",
+			EnvVars: []string{"ASN_DIAL_LIMIT"},
+		},
+		&cli.StringFlag{
+			Name:    "dial-policy",
+			Usage:   "Policy that schedules the next dial of the nodes (fixed, backoff or success-rate), with its params as <policy>:<param>=<value>,...",
+			EnvVars: []string{"DIAL_POLICY"},
+		},
+		&cli.BoolFlag{
+			Name:    "once",
+			Usage:   "Make a single crawl: discover until convergence, dial every known node once and exit storing the summary in the crawls table",
+			EnvVars: []string{"ONCE"},
+		},
+		&cli.StringFlag{
+			Name:    "discovery-timeout",
+			Usage:   "Max time the discovery of a one-shot crawl runs for",
+			EnvVars: []string{"DISCOVERY_TIMEOUT"},
+		},
+		&cli.StringFlag{
+			Name:    "convergence-window",
+			Usage:   "The discovery of a one-shot crawl converges when no new node is found for this long",
+			EnvVars: []string{"CONVERGENCE_WINDOW"},
+		},
+		&cli.IntFlag{
+			Name:    "dial-retries",
+			Usage:   "Number of times a one-shot crawl retries the nodes that don't answer",
+			EnvVars: []string{"DIAL_RETRIES"},
+		},
 	},
 }
 
@@ -119,10 +245,20 @@ func RunRagno(ctx *cli.Context) error {
 	go func() {
 		sig := <-sigChan
 		log.Warnf("received signal %s - stopping ragno", sig.String())
-		ragno.Close()
+		if conf.Once {
+			// the one-shot crawl stores what it got so far before closing
+			cancel()
+		} else {
+			ragno.Close()
+		}
 		close(sigChan)
 	}()
 
+	if conf.Once {
+		_, err = ragno.RunOnce()
+		ragno.Close()
+		return err
+	}
 	// start the crawler
 	return ragno.Run()
 }
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/cortze/ragno/crawler"
+	"github.com/cortze/ragno/db"
+	"github.com/cortze/ragno/models"
+	"github.com/cortze/ragno/topology"
+)
+
+var topologyOptions struct {
+	lvl        string
+	dbEndpoint string
+	network    string
+	format     string
+	output     string
+	since      time.Duration
+}
+
+var TopologyCmd = &cli.Command{
+	Name:   "topology",
+	Usage:  "export the discv4 neighbours graph stored in the database as GraphML or DOT",
+	Action: exportTopology,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "log-level",
+			Aliases:     []string{"v"},
+			Usage:       "sets the verbosity of the logs",
+			Value:       "info",
+			EnvVars:     []string{"LOG_LEVEL"},
+			Destination: &topologyOptions.lvl,
+		},
+		&cli.StringFlag{
+			Name:        "db-endpoint",
+			Usage:       "Endpoint of the database where the neighbours were stored",
+			Value:       crawler.DefaultDBEndpoint,
+			EnvVars:     []string{"DB_URL"},
+			Destination: &topologyOptions.dbEndpoint,
+		},
+		&cli.StringFlag{
+			Name:        "network",
+			Usage:       "Network whose neighbours are exported (mainnet, sepolia, holesky)",
+			Value:       crawler.DefaultNetwork,
+			EnvVars:     []string{"NETWORK"},
+			Destination: &topologyOptions.network,
+		},
+		&cli.StringFlag{
+			Name:        "format",
+			Usage:       "Format of the exported graph (graphml or dot)",
+			Value:       topology.GraphMLFormat,
+			Destination: &topologyOptions.format,
+		},
+		&cli.StringFlag{
+			Name:        "output",
+			Aliases:     []string{"f"},
+			Usage:       "path to the file where the graph will be written (defaults to neighbours.<format>)",
+			Destination: &topologyOptions.output,
+		},
+		&cli.DurationFlag{
+			Name:        "since",
+			Usage:       "Only export the relationships seen within this time window",
+			Value:       24 * time.Hour,
+			Destination: &topologyOptions.since,
+		},
+	},
+}
+
+func exportTopology(ctx *cli.Context) error {
+	logrus.SetLevel(crawler.ParseLogLevel(topologyOptions.lvl))
+
+	network, err := models.NewNetwork(topologyOptions.network, "", nil)
+	if err != nil {
+		return err
+	}
+	mainCtx, cancel := context.WithCancel(ctx.Context)
+	defer cancel()
+	database, err := db.Connect(mainCtx, topologyOptions.dbEndpoint, 1, crawler.DefaultSnapshotInterval, network.NetworkID)
+	if err != nil {
+		return errors.Wrap(err, "unable to connect to the database")
+	}
+	defer database.Finish()
+
+	edges, err := database.GetNeighbourEdges(time.Now().Add(-topologyOptions.since))
+	if err != nil {
+		return err
+	}
+
+	output := topologyOptions.output
+	if output == "" {
+		output = "neighbours." + topologyOptions.format
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return errors.Wrap(err, "unable to create the output file")
+	}
+	defer f.Close()
+	err = topology.WriteGraph(f, topologyOptions.format, edges)
+	if err != nil {
+		return errors.Wrap(err, "unable to export the neighbours graph")
+	}
+	logrus.WithFields(logrus.Fields{
+		"edges":  len(edges),
+		"format": topologyOptions.format,
+		"output": output,
+	}).Info("neighbours graph exported")
+	return nil
+}
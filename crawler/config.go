@@ -22,6 +22,29 @@ var (
 	DefaultSnapshotInterval     = 30 * time.Minute
 	DefaultIPAPIUrl             = "http://ip-api.com/json/{__ip__}?fields=status,continent,continentCode,country,countryCode,region,regionName,city,zip,lat,lon,isp,org,as,asname,mobile,proxy,hosting,query"
 	DefaultDeprecationTime      = 48 * time.Hour
+	DefaultDiscovery            = []string{"discv4"}
+	DefaultDiscv5Port           = 9051
+	DefaultNetwork              = "mainnet"
+	DefaultNodeKey              = ""
+	DefaultDataDir              = ""
+	DefaultDeadLetterFile       = ""
+	DefaultAttemptsRetention    = time.Duration(0)
+	DefaultNeighboursRetention  = time.Duration(0)
+	DefaultInbound              = false
+	DefaultMaxInboundConns      = 50
+	DefaultProbeHead            = false
+	DefaultKeepAlive            = 0
+	DefaultTxSampling           = false
+	DefaultLES                  = false
+	DefaultProbeSnap            = false
+	DefaultIPDialLimit          = DialLimit{Concurrent: 2, PerMinute: 10}
+	DefaultSubnetDialLimit      = DialLimit{Concurrent: 8, PerMinute: 40}
+	DefaultASNDialLimit         = DialLimit{Concurrent: 30, PerMinute: 0}
+	DefaultDialPolicy           = FixedPolicy
+	DefaultOnce                 = false
+	DefaultDiscoveryTimeout     = 10 * time.Minute
+	DefaultConvergenceWindow    = 1 * time.Minute
+	DefaultDialRetries          = 2
 )
 
 type CrawlerRunConf struct {
@@ -38,23 +61,84 @@ type CrawlerRunConf struct {
 	SnapshotInterval time.Duration `yaml:"snapshot-interval"`
 	IPAPIUrl         string        `yaml:"ip-api-url"`
 	DeprecationTime  time.Duration `yaml:"deprecation-time"`
+	Discovery        []string      `yaml:"discovery"`
+	Discv5Port       int           `yaml:"discv5-port"`
+	Network          string        `yaml:"network"`
+	GenesisFile      string        `yaml:"genesis-file"`
+	Bootnodes        []string      `yaml:"bootnodes"`
+	NodeKey          string        `yaml:"node-key"`
+	DataDir          string        `yaml:"data-dir"`
+	DeadLetterFile   string        `yaml:"dead-letter-file"`
+	Inbound          bool          `yaml:"inbound"`
+	MaxInboundConns  int           `yaml:"max-inbound"`
+	ProbeHead        bool          `yaml:"probe-head"`
+	KeepAlive        int           `yaml:"keep-alive"`
+	TxSampling       bool          `yaml:"tx-sampling"`
+	LES              bool          `yaml:"les"`
+	ProbeSnap        bool          `yaml:"probe-snap"`
+	IPDialLimit      DialLimit     `yaml:"ip-dial-limit"`
+	SubnetDialLimit  DialLimit     `yaml:"subnet-dial-limit"`
+	ASNDialLimit     DialLimit     `yaml:"asn-dial-limit"`
+	DialPolicy       string        `yaml:"dial-policy"`
+	// one-shot crawls
+	Once              bool          `yaml:"once"`
+	DiscoveryTimeout  time.Duration `yaml:"discovery-timeout"`
+	ConvergenceWindow time.Duration `yaml:"convergence-window"`
+	DialRetries       int           `yaml:"dial-retries"`
+	// database
+	AttemptsRetention   time.Duration `yaml:"attempts-retention"`
+	NeighboursRetention time.Duration `yaml:"neighbours-retention"`
 }
 
 func NewDefaultRun() *CrawlerRunConf {
 	return &CrawlerRunConf{
-		LogLevel:         DefaultLogLevel,
-		DbEndpoint:       DefaultDBEndpoint,
-		HostIP:           DefaultHostIP,
-		HostPort:         DefaultHostPort,
-		MetricsIP:        DefaultMetricsIP,
-		MetricsPort:      DefaultMetricsPort,
-		MetricsEndpoint:  DefaultMetricsEndpoint,
-		Dialers:          DefaultConcurrentDialers,
-		Persisters:       DefaultConcurrentPersisters,
-		ConnTimeout:      DefaultConnTimeout,
-		SnapshotInterval: DefaultSnapshotInterval,
-		IPAPIUrl:         DefaultIPAPIUrl,
-		DeprecationTime:  DefaultDeprecationTime,
+		LogLevel:          DefaultLogLevel,
+		DbEndpoint:        DefaultDBEndpoint,
+		HostIP:            DefaultHostIP,
+		HostPort:          DefaultHostPort,
+		MetricsIP:         DefaultMetricsIP,
+		MetricsPort:       DefaultMetricsPort,
+		MetricsEndpoint:   DefaultMetricsEndpoint,
+		Dialers:           DefaultConcurrentDialers,
+		Persisters:        DefaultConcurrentPersisters,
+		ConnTimeout:       DefaultConnTimeout,
+		SnapshotInterval:  DefaultSnapshotInterval,
+		IPAPIUrl:          DefaultIPAPIUrl,
+		DeprecationTime:   DefaultDeprecationTime,
+		Discovery:         DefaultDiscovery,
+		Discv5Port:        DefaultDiscv5Port,
+		Network:           DefaultNetwork,
+		Bootnodes:         []string{},
+		NodeKey:           DefaultNodeKey,
+		DataDir:           DefaultDataDir,
+		DeadLetterFile:    DefaultDeadLetterFile,
+		Inbound:           DefaultInbound,
+		MaxInboundConns:   DefaultMaxInboundConns,
+		ProbeHead:         DefaultProbeHead,
+		KeepAlive:         DefaultKeepAlive,
+		TxSampling:        DefaultTxSampling,
+		LES:               DefaultLES,
+		ProbeSnap:         DefaultProbeSnap,
+		IPDialLimit:       DefaultIPDialLimit,
+		SubnetDialLimit:   DefaultSubnetDialLimit,
+		ASNDialLimit:      DefaultASNDialLimit,
+		DialPolicy:        DefaultDialPolicy,
+		Once:              DefaultOnce,
+		DiscoveryTimeout:  DefaultDiscoveryTimeout,
+		ConvergenceWindow: DefaultConvergenceWindow,
+		DialRetries:       DefaultDialRetries,
+
+		AttemptsRetention:   DefaultAttemptsRetention,
+		NeighboursRetention: DefaultNeighboursRetention,
+	}
+}
+
+// DialLimits returns the limits of the dials towards the nodes that are hosted together
+func (c *CrawlerRunConf) DialLimits() DialLimits {
+	return DialLimits{
+		IP:     c.IPDialLimit,
+		Subnet: c.SubnetDialLimit,
+		ASN:    c.ASNDialLimit,
 	}
 }
 
@@ -71,6 +155,19 @@ func (c *CrawlerRunConf) parseDurationVar(
 	return parsedTime
 }
 
+func (c *CrawlerRunConf) parseDialLimitVar(
+	limitVar string, defaultLimit DialLimit, ctx *cli.Context,
+) DialLimit {
+	parsedLimit, parseErr := ParseDialLimit(ctx.String(limitVar))
+
+	if parseErr != nil {
+		logrus.Warnf("Dial limit %s is not valid (%s). Using %s instead.",
+			ctx.String(limitVar), parseErr, defaultLimit)
+		parsedLimit = defaultLimit
+	}
+	return parsedLimit
+}
+
 // Only considered the configuration for the Execution Layer's crawler -> RunCommand
 func (c *CrawlerRunConf) Apply(ctx *cli.Context) error {
 	config := map[string]func(flag string){
@@ -95,6 +192,37 @@ func (c *CrawlerRunConf) Apply(ctx *cli.Context) error {
 		"snapshot-interval": func(flag string) { c.SnapshotInterval = c.parseDurationVar(flag, DefaultSnapshotInterval, ctx) },
 		"ip-api-url":        func(flag string) { c.IPAPIUrl = ctx.String(flag) },
 		"deprecation-time":  func(flag string) { c.DeprecationTime = c.parseDurationVar(flag, DefaultDeprecationTime, ctx) },
+		"discovery":         func(flag string) { c.Discovery = ctx.StringSlice(flag) },
+		"discv5-port":       func(flag string) { c.Discv5Port = ctx.Int(flag) },
+		"network":           func(flag string) { c.Network = ctx.String(flag) },
+		"genesis-file":      func(flag string) { c.GenesisFile = ctx.String(flag) },
+		"bootnodes":         func(flag string) { c.Bootnodes = ctx.StringSlice(flag) },
+		"node-key":          func(flag string) { c.NodeKey = ctx.String(flag) },
+		"data-dir":          func(flag string) { c.DataDir = ctx.String(flag) },
+		"dead-letter-file":  func(flag string) { c.DeadLetterFile = ctx.String(flag) },
+		"attempts-retention": func(flag string) {
+			c.AttemptsRetention = c.parseDurationVar(flag, DefaultAttemptsRetention, ctx)
+		},
+		"neighbours-retention": func(flag string) {
+			c.NeighboursRetention = c.parseDurationVar(flag, DefaultNeighboursRetention, ctx)
+		},
+		"inbound":           func(flag string) { c.Inbound = ctx.Bool(flag) },
+		"max-inbound":       func(flag string) { c.MaxInboundConns = ctx.Int(flag) },
+		"probe-head":        func(flag string) { c.ProbeHead = ctx.Bool(flag) },
+		"keep-alive":        func(flag string) { c.KeepAlive = ctx.Int(flag) },
+		"tx-sampling":       func(flag string) { c.TxSampling = ctx.Bool(flag) },
+		"les":               func(flag string) { c.LES = ctx.Bool(flag) },
+		"probe-snap":        func(flag string) { c.ProbeSnap = ctx.Bool(flag) },
+		"ip-dial-limit":     func(flag string) { c.IPDialLimit = c.parseDialLimitVar(flag, DefaultIPDialLimit, ctx) },
+		"subnet-dial-limit": func(flag string) { c.SubnetDialLimit = c.parseDialLimitVar(flag, DefaultSubnetDialLimit, ctx) },
+		"asn-dial-limit":    func(flag string) { c.ASNDialLimit = c.parseDialLimitVar(flag, DefaultASNDialLimit, ctx) },
+		"dial-policy":       func(flag string) { c.DialPolicy = ctx.String(flag) },
+		"once":              func(flag string) { c.Once = ctx.Bool(flag) },
+		"discovery-timeout": func(flag string) { c.DiscoveryTimeout = c.parseDurationVar(flag, DefaultDiscoveryTimeout, ctx) },
+		"convergence-window": func(flag string) {
+			c.ConvergenceWindow = c.parseDurationVar(flag, DefaultConvergenceWindow, ctx)
+		},
+		"dial-retries": func(flag string) { c.DialRetries = ctx.Int(flag) },
 	}
 
 	for flag, applier := range config {
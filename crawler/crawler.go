@@ -2,12 +2,20 @@ package crawler
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
 	"github.com/cortze/ragno/db"
+	"github.com/cortze/ragno/models"
 	peerDisc "github.com/cortze/ragno/peerdiscovery"
 	apis "github.com/cortze/ragno/pkg/apis"
 	metrics "github.com/cortze/ragno/pkg/metrics"
@@ -26,30 +34,124 @@ type Crawler struct {
 	// peeringw
 	peering *Peering
 	// database
-	db *db.PostgresDBService
+	db       db.Storage
+	dbCancel context.CancelFunc
 	// IP locator
 	IPLocator *apis.IPLocator
 	// discovery
 	peerDisc *peerDisc.PeerDiscovery
 	// metrics
 	metrics *metrics.PrometheusMetrics
+	// inbound connections
+	inbound         bool
+	maxInboundConns int
+	// one-shot crawls
+	oneShot           bool
+	discoveryTimeout  time.Duration
+	convergenceWindow time.Duration
+	dialRetries       int
+	discoveryClose    sync.Once
 }
 
 func NewCrawler(ctx context.Context, conf CrawlerRunConf) (*Crawler, error) {
-	// create db crawler
-	db, err := db.ConnectToDB(ctx, conf.DbEndpoint, conf.Persisters, conf.SnapshotInterval)
+	// compose the details of the network that we will crawl
+	network, err := models.NewNetwork(conf.Network, conf.GenesisFile, conf.Bootnodes)
 	if err != nil {
+		logrus.Error("unable to compose the network details")
+		return nil, err
+	}
+	logrus.WithFields(logrus.Fields{
+		"network":    network.Name,
+		"network-id": network.NetworkID,
+		"genesis":    network.GenesisHash.String(),
+		"bootnodes":  len(network.Bootnodes),
+	}).Info("crawling network")
+
+	dialPolicy, err := ParseDialPolicy(conf.DialPolicy)
+	if err != nil {
+		return nil, err
+	}
+	logrus.WithField("dial-policy", dialPolicy.String()).Info("scheduling dials")
+	// the tx gossip is only sampled over the sessions we keep open
+	if conf.TxSampling && conf.KeepAlive <= 0 {
+		return nil, errors.New("tx sampling requires keeping sessions open (--keep-alive)")
+	}
+	// the one-shot crawls only make outbound dials
+	if conf.Once && (conf.KeepAlive > 0 || conf.Inbound) {
+		return nil, errors.New("one-shot crawls (--once) can't keep sessions or accept inbound connections")
+	}
+
+	// the same identity is shared by the host and the discovery listeners
+	if conf.NodeKey == "" && conf.DataDir != "" {
+		conf.NodeKey = filepath.Join(conf.DataDir, "nodekey")
+	}
+	privk, err := models.LoadOrCreateNodeKey(conf.NodeKey)
+	if err != nil {
+		logrus.Error("unable to load the node key")
+		return nil, err
+	}
+	logrus.WithFields(logrus.Fields{
+		"node-id":  enode.PubkeyToIDV4(&privk.PublicKey).String(),
+		"node-key": conf.NodeKey,
+	}).Info("crawler identity")
+
+	// the queries that can't be persisted are kept next to the rest of the crawler data
+	if conf.DeadLetterFile == "" {
+		conf.DeadLetterFile = filepath.Join(conf.DataDir, "dead-letters.jsonl")
+	}
+	db.DeadLetterFile = conf.DeadLetterFile
+	db.ConnAttemptsRetention = conf.AttemptsRetention
+	db.NeighboursRetention = conf.NeighboursRetention
+	if (conf.AttemptsRetention > 0 || conf.NeighboursRetention > 0) && strings.HasPrefix(conf.DbEndpoint, db.SQLitePrefix) {
+		logrus.Warn("the retention of the connection attempts and neighbours only applies to postgres")
+	}
+
+	// create db crawler. Its context isn't the one cancelled on the signals, so that
+	// Finish can still flush what is queued, it is cancelled once the db is closed
+	dbCtx, dbCancel := context.WithCancel(context.Background())
+	db, err := db.Connect(dbCtx, conf.DbEndpoint, conf.Persisters, conf.SnapshotInterval, network.NetworkID)
+	if err != nil {
+		dbCancel()
 		logrus.Error("Couldn't init DB")
 		return nil, err
 	}
+	// on any later error, release whatever was already created
+	var (
+		host        *Host
+		discoverers []peerDisc.Discoverer
+		created     bool
+	)
+	defer func() {
+		if created {
+			return
+		}
+		for _, discv := range discoverers {
+			discv.Close()
+		}
+		if host != nil {
+			host.Close()
+		}
+		db.Finish()
+		dbCancel()
+	}()
 
 	// create a host
-	host, err := NewHost(
+	caps := DefaultCaps
+	if conf.LES {
+		caps = append(append([]p2p.Cap{}, DefaultCaps...), LESCaps...)
+	}
+	host, err = NewHost(
 		ctx,
 		conf.HostIP,
 		conf.HostPort,
 		conf.ConnTimeout,
-		// default configuration so far
+		WithNetwork(network),
+		WithPrivKey(privk),
+		WithHeadProbe(conf.ProbeHead),
+		WithKeepAlive(conf.KeepAlive),
+		WithTxSampling(conf.TxSampling),
+		WithCustomCaps(caps),
+		WithSnapProbe(conf.ProbeSnap),
 	)
 	if err != nil {
 		logrus.Error("failed to create host:")
@@ -59,13 +161,13 @@ func NewCrawler(ctx context.Context, conf CrawlerRunConf) (*Crawler, error) {
 	prometheusMetrics := metrics.NewPrometheusMetrics(
 		ctx, conf.MetricsIP, conf.MetricsPort, conf.MetricsEndpoint, MetricLoopInterval)
 
-	// create the peer discoverer
-	discv4, err := peerDisc.NewDiscv4(ctx, conf.HostPort)
+	// create the peer discoverers
+	discoverers, err = newDiscoverers(ctx, conf, network, privk, db)
 	if err != nil {
 		logrus.Error(err)
 		return nil, err
 	}
-	discvService, err := peerDisc.NewPeerDiscovery(ctx, discv4, db)
+	discvService, err := peerDisc.NewPeerDiscovery(ctx, db, discoverers...)
 	if err != nil {
 		logrus.Error(err)
 		return nil, err
@@ -77,19 +179,143 @@ func NewCrawler(ctx context.Context, conf CrawlerRunConf) (*Crawler, error) {
 		ctx:      ctx,
 		doneC:    make(chan struct{}, 1),
 		host:     host,
-		peering:  NewPeeringService(ctx, host, db, conf.Dialers, conf.DeprecationTime, IPLocator),
+		peering:  NewPeeringService(
+			ctx, host, db, conf.Dialers, conf.DeprecationTime, IPLocator, conf.DialLimits(), dialPolicy),
 		db:       db,
+		dbCancel: dbCancel,
 		peerDisc: discvService,
 		metrics:  prometheusMetrics,
 		IPLocator: IPLocator,
+
+		inbound:         conf.Inbound,
+		maxInboundConns: conf.MaxInboundConns,
+
+		discoveryTimeout:  conf.DiscoveryTimeout,
+		convergenceWindow: conf.ConvergenceWindow,
+		dialRetries:       conf.DialRetries,
 	}
 
 	crawlerMetricsModule := crwl.GetMetrics()
 	prometheusMetrics.AddMetricsModule(crawlerMetricsModule)
 
+	created = true
 	return crwl, nil
 }
 
+// newDiscoverers composes the list of discoverers from the discovery sources in the config
+func newDiscoverers(
+	ctx context.Context,
+	conf CrawlerRunConf,
+	network *models.Network,
+	privk *ecdsa.PrivateKey,
+	database db.Storage,
+) ([]peerDisc.Discoverer, error) {
+	discoverers := make([]peerDisc.Discoverer, 0, len(conf.Discovery))
+	// all the given DNS trees are walked by the same discoverer
+	dnsTrees := make([]string, 0)
+	// discv4 and discv4-crawl would both bind the host UDP port
+	discv4Sources := 0
+	for _, source := range conf.Discovery {
+		switch peerDisc.StringToDiscoveryType(source) {
+		case models.Discovery4, models.Discovery4Crawl:
+			discv4Sources++
+		}
+	}
+	if discv4Sources > 1 {
+		return discoverers, errors.New("only one of discv4 or discv4-crawl can be used at once")
+	}
+	for _, source := range conf.Discovery {
+		switch peerDisc.StringToDiscoveryType(source) {
+		case models.Discovery4:
+			seeds := seedENRs(database, network.Bootnodes, discv4SeedOrigins)
+			discv4, err := peerDisc.NewDiscv4(ctx, conf.HostPort, seeds, privk, nodeDBPath(conf.DataDir, "discv4"))
+			if err != nil {
+				return discoverers, err
+			}
+			discoverers = append(discoverers, discv4)
+		case models.Discovery4Crawl:
+			seeds := seedENRs(database, network.Bootnodes, discv4SeedOrigins)
+			discv4Crawl, err := peerDisc.NewDiscv4Crawl(ctx, conf.HostPort, seeds, privk)
+			if err != nil {
+				return discoverers, err
+			}
+			discoverers = append(discoverers, discv4Crawl)
+		case models.Discovery5:
+			seeds := seedENRs(database, params.V5Bootnodes, discv5SeedOrigins)
+			discv5, err := peerDisc.NewDiscv5(ctx, conf.Discv5Port, seeds, privk, nodeDBPath(conf.DataDir, "discv5"))
+			if err != nil {
+				return discoverers, err
+			}
+			discoverers = append(discoverers, discv5)
+		case models.CsvFile:
+			csvDiscv, err := peerDisc.NewCSVPeerDiscoverer(source)
+			if err != nil {
+				return discoverers, errors.Wrap(err, "unable to open csv discovery file "+source)
+			}
+			discoverers = append(discoverers, csvDiscv)
+		case models.DNSTree:
+			if source == "dns" {
+				if len(network.DNSTrees) == 0 {
+					return discoverers, errors.New("no known dns trees for network " + network.Name)
+				}
+				dnsTrees = append(dnsTrees, network.DNSTrees...)
+			} else {
+				dnsTrees = append(dnsTrees, source)
+			}
+		default:
+			return discoverers, errors.New("unknown discovery source " + source)
+		}
+	}
+	if len(dnsTrees) > 0 {
+		dnsDiscv, err := peerDisc.NewDNSDiscoverer(ctx, dnsTrees, nil)
+		if err != nil {
+			return discoverers, err
+		}
+		discoverers = append(discoverers, dnsDiscv)
+	}
+	return discoverers, nil
+}
+
+var (
+	// maximum number of ENRs from the db that are added to the bootnodes
+	MaxSeedENRs       = 1000
+	discv4SeedOrigins = []string{"discv4", "discv4-crawl", "dns"}
+	discv5SeedOrigins = []string{"discv5"}
+)
+
+// seedENRs adds the last seen ENRs from the db to the bootnodes, so that the discovery
+// doesn't need to walk the network from scratch after a restart
+func seedENRs(database db.Storage, bootnodes []string, origins []string) []string {
+	records, err := database.GetSeedENRs(origins, MaxSeedENRs)
+	if err != nil {
+		logrus.Warn(errors.Wrap(err, "unable to seed the discovery from the db"))
+		return bootnodes
+	}
+	seeds := append(make([]string, 0, len(bootnodes)+len(records)), bootnodes...)
+	for _, record := range records {
+		// skip the records that the discovery wouldn't be able to parse
+		if _, err := enode.Parse(enode.ValidSchemes, record); err != nil {
+			continue
+		}
+		seeds = append(seeds, record)
+	}
+	logrus.WithFields(logrus.Fields{
+		"origins":   origins,
+		"bootnodes": len(bootnodes),
+		"seeds":     len(seeds) - len(bootnodes),
+	}).Info("seeding discovery from the db")
+	return seeds
+}
+
+// nodeDBPath returns where the node database of the discovery is stored (in memory if
+// no data dir was given)
+func nodeDBPath(dataDir, discv string) string {
+	if dataDir == "" {
+		return ""
+	}
+	return filepath.Join(dataDir, discv+"-nodes")
+}
+
 func (c *Crawler) Run() error {
 	// start the peer discoverer
 	logrus.Info("Starting peer discoverer")
@@ -101,22 +327,37 @@ func (c *Crawler) Run() error {
 	c.IPLocator.Run()
 	logrus.Info("Starting metrics")
 	c.metrics.Start()
+	if c.inbound {
+		inboundC, err := c.host.Listen(c.maxInboundConns)
+		if err != nil {
+			return errors.Wrap(err, "starting inbound listener")
+		}
+		c.peering.ServeInbound(inboundC)
+	}
+	if c.host.maxSessions > 0 {
+		c.peering.ServeSessions(c.host.Sessions())
+		if txSamplesC := c.host.TxSamples(); txSamplesC != nil {
+			c.peering.ServeTxSamples(txSamplesC)
+		}
+	}
 	return c.peering.Run()
 }
 
 func (c *Crawler) Close() {
 	// finish discovery
-	logrus.Info("crawler: closing peer-discovery")
-	c.peerDisc.Close()
-	// stop peering
-	logrus.Info("crawler: closing peering")
-	c.peering.Close()
+	c.closeDiscovery()
+	if !c.oneShot {
+		// stop peering (and the inbound connections)
+		logrus.Info("crawler: closing peering")
+		c.peering.Close()
+	}
 	// close host
 	logrus.Info("crawler: closing host")
 	c.host.Close()
 	// stop db
 	logrus.Info("crawler: closing database")
 	c.db.Finish()
+	c.dbCancel()
 	// stop IPLocator
 	c.IPLocator.Close()
 	// stop metrics
@@ -0,0 +1,254 @@
+package crawler
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	FixedPolicy       = "fixed"
+	BackoffPolicy     = "backoff"
+	SuccessRatePolicy = "success-rate"
+)
+
+// DialPolicy decides when each node is dialed again after an attempt
+type DialPolicy interface {
+	// NextDial returns the time of the next dial of the node, whose state and counters
+	// were already updated with the result of the attempt made at baseT
+	NextDial(node *QueuedNode, baseT time.Time) time.Time
+	// String returns the policy with its parameters, with the format it is parsed from
+	String() string
+}
+
+// ParseDialPolicy parses policies with the "<name>[:<param>=<value>,...]" format, taking
+// the default values for the parameters that aren't given, e.g.
+// "backoff:factor=3,max=12h" or "success-rate:min=5m"
+func ParseDialPolicy(str string) (DialPolicy, error) {
+	name, rawParams, _ := strings.Cut(str, ":")
+	params := make(map[string]string)
+	if rawParams != "" {
+		for _, param := range strings.Split(rawParams, ",") {
+			key, value, ok := strings.Cut(param, "=")
+			if !ok {
+				return nil, fmt.Errorf("dial policy param %s doesn't follow the <param>=<value> format", param)
+			}
+			params[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	var policy DialPolicy
+	var err error
+	switch name {
+	case FixedPolicy:
+		policy, err = parseFixedDelays(params)
+	case BackoffPolicy:
+		policy, err = parseBackoff(params)
+	case SuccessRatePolicy:
+		policy, err = parseSuccessRate(params)
+	default:
+		return nil, fmt.Errorf("unknown dial policy %s", name)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse "+name+" dial policy")
+	}
+	// the parsers consume the params they know
+	for param := range params {
+		return nil, fmt.Errorf("unknown param %s for the %s dial policy", param, name)
+	}
+	return policy, nil
+}
+
+// --- Fixed Delays ---
+
+// FixedDelays waits the same delay after every attempt that ended in the same state
+type FixedDelays struct {
+	Positive            time.Duration
+	NegativeWithHope    time.Duration
+	NegativeWithoutHope time.Duration
+}
+
+func NewFixedDelays() *FixedDelays {
+	return &FixedDelays{
+		Positive:            PossitiveDelay,
+		NegativeWithHope:    NegativeWithHopeDelay,
+		NegativeWithoutHope: NegativeWithoutHopeDalay,
+	}
+}
+
+func (p *FixedDelays) NextDial(node *QueuedNode, baseT time.Time) time.Time {
+	return baseT.Add(p.delay(node.state))
+}
+
+func (p *FixedDelays) delay(state DialState) time.Duration {
+	switch state {
+	case PossitiveState:
+		return p.Positive
+	case NegativeWithHopeState:
+		return p.NegativeWithHope
+	case NegativeWithoutHopeState:
+		return p.NegativeWithoutHope
+	default:
+		return ZeroDelay
+	}
+}
+
+func (p *FixedDelays) String() string {
+	return fmt.Sprintf("%s:positive=%s,hope=%s,nohope=%s",
+		FixedPolicy, p.Positive, p.NegativeWithHope, p.NegativeWithoutHope)
+}
+
+func parseFixedDelays(params map[string]string) (*FixedDelays, error) {
+	p := NewFixedDelays()
+	err := parseDurationParams(params, map[string]*time.Duration{
+		"positive": &p.Positive,
+		"hope":     &p.NegativeWithHope,
+		"nohope":   &p.NegativeWithoutHope,
+	})
+	return p, err
+}
+
+// --- Exponential Backoff ---
+
+// Backoff multiplies the delay of each error class by the factor on every consecutive
+// failed attempt (up to the max delay), with a random jitter that spreads the dials of
+// the nodes that failed together. Successful attempts reset the backoff
+type Backoff struct {
+	FixedDelays
+	Factor float64
+	Max    time.Duration
+	Jitter float64 // fraction of the delay added or subtracted at random
+}
+
+func NewBackoff() *Backoff {
+	return &Backoff{
+		FixedDelays: *NewFixedDelays(),
+		Factor:      2,
+		Max:         6 * time.Hour,
+		Jitter:      0.2,
+	}
+}
+
+func (p *Backoff) NextDial(node *QueuedNode, baseT time.Time) time.Time {
+	delay := float64(p.delay(node.state))
+	if node.consecutiveFails > 1 {
+		delay *= math.Pow(p.Factor, float64(node.consecutiveFails-1))
+	}
+	// the jitter goes before the cap, so that the max delay is never exceeded
+	delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	delay = math.Min(delay, float64(p.Max))
+	return baseT.Add(time.Duration(delay))
+}
+
+func (p *Backoff) String() string {
+	return fmt.Sprintf("%s:positive=%s,hope=%s,nohope=%s,factor=%g,max=%s,jitter=%g",
+		BackoffPolicy, p.Positive, p.NegativeWithHope, p.NegativeWithoutHope, p.Factor, p.Max, p.Jitter)
+}
+
+func parseBackoff(params map[string]string) (*Backoff, error) {
+	p := NewBackoff()
+	err := parseDurationParams(params, map[string]*time.Duration{
+		"positive": &p.Positive,
+		"hope":     &p.NegativeWithHope,
+		"nohope":   &p.NegativeWithoutHope,
+		"max":      &p.Max,
+	})
+	if err != nil {
+		return p, err
+	}
+	err = parseFloatParams(params, map[string]*float64{
+		"factor": &p.Factor,
+		"jitter": &p.Jitter,
+	})
+	if err != nil {
+		return p, err
+	}
+	if p.Factor < 1 {
+		return p, fmt.Errorf("backoff factor %g can't be lower than 1", p.Factor)
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return p, fmt.Errorf("backoff jitter %g has to be between 0 and 1", p.Jitter)
+	}
+	return p, nil
+}
+
+// --- Success Rate ---
+
+// SuccessRate dials the nodes that usually answer more often than the ones that rarely
+// do, spreading the delays between the min and the max ones by the success rate of
+// the attempts to each node
+type SuccessRate struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+func NewSuccessRate() *SuccessRate {
+	return &SuccessRate{
+		Min: 5 * time.Minute,
+		Max: 2 * time.Hour,
+	}
+}
+
+func (p *SuccessRate) NextDial(node *QueuedNode, baseT time.Time) time.Time {
+	// smoothed, so that a single attempt doesn't set the extremes
+	rate := float64(node.successes+1) / float64(node.attempts+2)
+	delay := float64(p.Min) + (1-rate)*float64(p.Max-p.Min)
+	return baseT.Add(time.Duration(delay))
+}
+
+func (p *SuccessRate) String() string {
+	return fmt.Sprintf("%s:min=%s,max=%s", SuccessRatePolicy, p.Min, p.Max)
+}
+
+func parseSuccessRate(params map[string]string) (*SuccessRate, error) {
+	p := NewSuccessRate()
+	err := parseDurationParams(params, map[string]*time.Duration{
+		"min": &p.Min,
+		"max": &p.Max,
+	})
+	if err != nil {
+		return p, err
+	}
+	if p.Min > p.Max {
+		return p, fmt.Errorf("min delay %s is greater than the max one %s", p.Min, p.Max)
+	}
+	return p, nil
+}
+
+// parseDurationParams consumes the given params from the map
+func parseDurationParams(params map[string]string, targets map[string]*time.Duration) error {
+	for key, target := range targets {
+		value, ok := params[key]
+		if !ok {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration < 0 {
+			return fmt.Errorf("invalid %s duration %s", key, value)
+		}
+		*target = duration
+		delete(params, key)
+	}
+	return nil
+}
+
+// parseFloatParams consumes the given params from the map
+func parseFloatParams(params map[string]string, targets map[string]*float64) error {
+	for key, target := range targets {
+		value, ok := params[key]
+		if !ok {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s value %s", key, value)
+		}
+		*target = parsed
+		delete(params, key)
+	}
+	return nil
+}
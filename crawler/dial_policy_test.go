@@ -0,0 +1,94 @@
+package crawler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortze/ragno/models"
+)
+
+func TestParseDialPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		expected string
+		valid    bool
+	}{
+		{
+			name:     "Test Default Fixed Delays",
+			policy:   "fixed",
+			expected: "fixed:positive=10m0s,hope=3m0s,nohope=20m0s",
+			valid:    true,
+		},
+		{
+			name:     "Test Backoff Params",
+			policy:   "backoff:factor=3,max=12h",
+			expected: "backoff:positive=10m0s,hope=3m0s,nohope=20m0s,factor=3,max=12h0m0s,jitter=0.2",
+			valid:    true,
+		},
+		{
+			name:     "Test Success Rate Params",
+			policy:   "success-rate:min=1m",
+			expected: "success-rate:min=1m0s,max=2h0m0s",
+			valid:    true,
+		},
+		{name: "Test Unknown Policy", policy: "random", valid: false},
+		{name: "Test Unknown Param", policy: "fixed:factor=2", valid: false},
+		{name: "Test Invalid Param", policy: "backoff:jitter=2", valid: false},
+	}
+	for _, testItem := range tests {
+		t.Run(testItem.name, func(t *testing.T) {
+			policy, err := ParseDialPolicy(testItem.policy)
+			if !testItem.valid {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, testItem.expected, policy.String())
+		})
+	}
+}
+
+func TestDialPolicies(t *testing.T) {
+	baseT := time.Now()
+	node := NewQueuedNode(models.HostInfo{})
+
+	// the fixed delays only depend on the result of the last attempt
+	fixed := NewFixedDelays()
+	node.AddNegativeDial(baseT, time.Hour, NegativeWithHopeState, fixed)
+	node.AddNegativeDial(baseT, time.Hour, NegativeWithHopeState, fixed)
+	require.Equal(t, baseT.Add(NegativeWithHopeDelay), node.NextDialTime())
+
+	// the backoff grows with the consecutive failures, and resets on success
+	backoff := NewBackoff()
+	backoff.Jitter = 0
+	node.AddNegativeDial(baseT, time.Hour, NegativeWithHopeState, backoff)
+	require.Equal(t, baseT.Add(4*NegativeWithHopeDelay), node.NextDialTime())
+	for i := 0; i < 10; i++ {
+		node.AddNegativeDial(baseT, time.Hour, NegativeWithoutHopeState, backoff)
+	}
+	require.Equal(t, baseT.Add(backoff.Max), node.NextDialTime())
+	node.AddPositiveDial(baseT, backoff)
+	require.Equal(t, baseT.Add(PossitiveDelay), node.NextDialTime())
+
+	// the jitter doesn't push the delay over the max
+	backoff.Jitter = 0.2
+	for i := 0; i < 20; i++ {
+		node.AddNegativeDial(baseT, time.Hour, NegativeWithoutHopeState, backoff)
+		require.LessOrEqual(t, node.NextDialTime().Sub(baseT), backoff.Max)
+	}
+
+	// the nodes that answer more often are dialed sooner
+	successRate := NewSuccessRate()
+	reliable := NewQueuedNode(models.HostInfo{})
+	unreliable := NewQueuedNode(models.HostInfo{})
+	for i := 0; i < 5; i++ {
+		reliable.AddPositiveDial(baseT, successRate)
+		unreliable.AddNegativeDial(baseT, time.Hour, NegativeWithHopeState, successRate)
+	}
+	require.True(t, reliable.NextDialTime().Before(unreliable.NextDialTime()))
+	require.True(t, reliable.NextDialTime().After(baseT.Add(successRate.Min)))
+	require.True(t, unreliable.NextDialTime().Before(baseT.Add(successRate.Max)))
+}
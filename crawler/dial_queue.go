@@ -0,0 +1,325 @@
+package crawler
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cortze/ragno/models"
+)
+
+// DialQueue is the priority queue of the nodes to dial, ordered by the time of their next
+// dial. The nodes handed to the dialers leave the heap until the result of their dial is
+// tracked, which schedules them again
+type DialQueue struct {
+	m     sync.Mutex
+	heap  nodeHeap
+	nodes map[enode.ID]*QueuedNode
+	// decides when the nodes are dialed again after each attempt
+	policy DialPolicy
+	// wakes up the consumer waiting for the next node when the head of the queue changes
+	wakeC chan struct{}
+}
+
+func NewDialQueue(policy DialPolicy) *DialQueue {
+	return &DialQueue{
+		heap:   make(nodeHeap, 0),
+		nodes:  make(map[enode.ID]*QueuedNode),
+		policy: policy,
+		wakeC:  make(chan struct{}, 1),
+	}
+}
+
+// UpdateListFromSet adds the nodes from the DB that aren't tracked yet
+func (q *DialQueue) UpdateListFromSet(nSet []models.HostInfo) {
+	newNodes := 0
+	for _, newNode := range nSet {
+		if q.AddNode(newNode) {
+			newNodes++
+		}
+	}
+	logrus.WithFields(logrus.Fields{
+		"total-nodes-from-db": len(nSet),
+		"new-nodes-from-db":   newNodes,
+		"total-nodes-in-set":  q.Len(),
+	}).Info("updating node-set from db-non-deprecated-set")
+}
+
+// AddNode queues the node to be dialed right away, returning false if it was already
+// tracked
+func (q *DialQueue) AddNode(hInfo models.HostInfo) bool {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if _, ok := q.nodes[hInfo.ID]; ok {
+		return false
+	}
+	logrus.WithField("nodeID", hInfo.ID.String()).Trace("adding node to node-set")
+	qNode := NewQueuedNode(hInfo)
+	q.nodes[hInfo.ID] = qNode
+	q.push(qNode)
+	return true
+}
+
+func (q *DialQueue) IsPeerAlready(nodeID enode.ID) bool {
+	q.m.Lock()
+	defer q.m.Unlock()
+	_, ok := q.nodes[nodeID]
+	return ok
+}
+
+// RemoveNode stops tracking the node
+func (q *DialQueue) RemoveNode(nodeID enode.ID) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.remove(nodeID)
+}
+
+// Next blocks until the first node of the queue is due, handing it out of the queue.
+// It returns false if the context or the done channel close while waiting
+func (q *DialQueue) Next(ctx context.Context, doneC chan struct{}) (models.HostInfo, bool) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+	for {
+		q.m.Lock()
+		if len(q.heap) > 0 {
+			wait := time.Until(q.heap[0].nextDialTime)
+			if wait <= 0 {
+				qNode := heap.Pop(&q.heap).(*QueuedNode)
+				q.m.Unlock()
+				return qNode.hostInfo, true
+			}
+			timer.Reset(wait)
+		}
+		q.m.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return models.HostInfo{}, false
+		case <-doneC:
+			return models.HostInfo{}, false
+		case <-q.wakeC:
+		case <-timer.C:
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
+
+// Postpone schedules again a node that was handed out but couldn't be dialed
+func (q *DialQueue) Postpone(nodeID enode.ID, t time.Time) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	qNode, ok := q.nodes[nodeID]
+	if !ok {
+		return
+	}
+	qNode.updateNextDialTime(t)
+	q.push(qNode)
+}
+
+// AddPositiveDial schedules the node as if it was successfully dialed at the given time
+func (q *DialQueue) AddPositiveDial(nodeID enode.ID, t time.Time) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	qNode, ok := q.nodes[nodeID]
+	if !ok {
+		return
+	}
+	qNode.AddPositiveDial(t, q.policy)
+	q.push(qNode)
+}
+
+// UpdateNodeFromConnAttempt schedules the next dial of the node from the result of the
+// attempt, removing it if it has to be deprecated
+func (q *DialQueue) UpdateNodeFromConnAttempt(
+	nodeID enode.ID, connAttempt *models.ConnectionAttempt, sameNetwork bool,
+	deprecationTime time.Duration,
+) {
+	logEntry := logrus.WithFields(logrus.Fields{
+		"nodeID":  nodeID.String(),
+		"attempt": connAttempt.Status.String(),
+		"error":   connAttempt.Error,
+	})
+	logEntry.Trace("tracking connection-attempt for node")
+	q.m.Lock()
+	defer q.m.Unlock()
+	node, exists := q.nodes[nodeID]
+	if !exists {
+		logEntry.Warn("connection attempt to a node that is untracked")
+		return
+	}
+	// check the state of the conn attempt
+	switch connAttempt.Status {
+	case models.SuccessfulConnection:
+		if !sameNetwork { // directly prune the node from the list & deprecate it
+			connAttempt.Deprecable = true
+			q.remove(nodeID)
+			return
+		}
+		// if possitive, all god
+		node.AddPositiveDial(connAttempt.Timestamp, q.policy)
+		connAttempt.Deprecable = false
+
+	case models.FailedConnection:
+		// if negative, check if it's deprecable
+		connAttempt.Deprecable = node.IsDeprecable()
+		if connAttempt.Deprecable {
+			q.remove(nodeID)
+			return
+		}
+		node.AddNegativeDial(
+			connAttempt.Timestamp, deprecationTime, ParseStateFromError(connAttempt.Error), q.policy)
+
+	default:
+		logEntry.Warn("unrecognized connection-attempt status for node")
+		logrus.Panic("we should have never reached here", connAttempt)
+	}
+	q.push(node)
+}
+
+// Len returns the number of tracked nodes (including the ones being dialed)
+func (q *DialQueue) Len() int {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return len(q.nodes)
+}
+
+func (q *DialQueue) IsEmpty() bool {
+	return q.Len() == 0
+}
+
+// push (re)places the node in the heap, waking up the consumer if it became the head
+func (q *DialQueue) push(qNode *QueuedNode) {
+	if qNode.index >= 0 {
+		heap.Fix(&q.heap, qNode.index)
+	} else {
+		heap.Push(&q.heap, qNode)
+	}
+	if qNode.index == 0 {
+		select {
+		case q.wakeC <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (q *DialQueue) remove(nodeID enode.ID) {
+	qNode, ok := q.nodes[nodeID]
+	if !ok {
+		logrus.Warn("trying to remove a peer that was no present in the node list")
+		return
+	}
+	logrus.WithField("nodeID", nodeID.String()).Trace("removing node from node-set")
+	delete(q.nodes, nodeID)
+	if qNode.index >= 0 {
+		heap.Remove(&q.heap, qNode.index)
+	}
+}
+
+// nodeHeap implements heap.Interface, keeping the index of each node up to date
+type nodeHeap []*QueuedNode
+
+func (h nodeHeap) Len() int { return len(h) }
+
+func (h nodeHeap) Less(i, j int) bool {
+	return h[i].nextDialTime.Before(h[j].nextDialTime)
+}
+
+func (h nodeHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *nodeHeap) Push(x interface{}) {
+	qNode := x.(*QueuedNode)
+	qNode.index = len(*h)
+	*h = append(*h, qNode)
+}
+
+func (h *nodeHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	qNode := old[n-1]
+	old[n-1] = nil
+	qNode.index = -1
+	*h = old[:n-1]
+	return qNode
+}
+
+// --- QueuedNode ---
+
+// Main structure of a node that is queued to be dialed
+type QueuedNode struct {
+	state           DialState
+	hostInfo        models.HostInfo
+	nextDialTime    time.Time
+	deprecationTime time.Time
+	index           int // position in the heap (-1 while being dialed)
+	// history of the attempts, for the dial policies
+	attempts         int
+	successes        int
+	consecutiveFails int
+}
+
+func NewQueuedNode(hInfo models.HostInfo) *QueuedNode {
+	return &QueuedNode{
+		state:           ZeroState,
+		hostInfo:        hInfo,
+		nextDialTime:    time.Time{},
+		deprecationTime: time.Time{},
+		index:           -1,
+	}
+}
+
+func (n *QueuedNode) ReadyToDial() bool {
+	return n.nextDialTime.Before(time.Now())
+}
+
+func (n *QueuedNode) updateNextDialTime(t time.Time) {
+	n.nextDialTime = t
+}
+
+func (n *QueuedNode) NextDialTime() time.Time {
+	return n.nextDialTime
+}
+
+func (n *QueuedNode) IsDeprecable() bool {
+	return !n.IsEmpty() && !n.deprecationTime.IsZero() && n.deprecationTime.Before(time.Now())
+}
+
+func (n *QueuedNode) IsEmpty() bool {
+	return n.hostInfo == models.HostInfo{}
+}
+
+func (n *QueuedNode) AddPositiveDial(baseT time.Time, policy DialPolicy) {
+	logrus.Trace("adding possitive dial attempt to node", n.hostInfo.ID.String())
+	n.state = PossitiveState
+	n.attempts++
+	n.successes++
+	n.consecutiveFails = 0
+	n.nextDialTime = policy.NextDial(n, baseT)
+	n.deprecationTime = time.Time{}
+}
+
+func (n *QueuedNode) AddNegativeDial(
+	baseT time.Time, deprecationTime time.Duration, state DialState, policy DialPolicy,
+) {
+	logrus.Trace("adding negative dial attempt to node", n.hostInfo.ID.String())
+	n.state = state
+	n.attempts++
+	n.consecutiveFails++
+	n.nextDialTime = policy.NextDial(n, baseT)
+	if n.deprecationTime.IsZero() {
+		n.deprecationTime = baseT.Add(deprecationTime)
+	}
+}
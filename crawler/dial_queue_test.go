@@ -0,0 +1,62 @@
+package crawler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortze/ragno/models"
+)
+
+func TestDialQueue(t *testing.T) {
+	queue := NewDialQueue(NewFixedDelays())
+	doneC := make(chan struct{})
+	nodes := []models.HostInfo{
+		{ID: enode.ID{1}, IP: "10.0.0.1"},
+		{ID: enode.ID{2}, IP: "10.0.0.2"},
+		{ID: enode.ID{3}, IP: "10.0.0.3"},
+	}
+	queue.UpdateListFromSet(nodes)
+	require.False(t, queue.AddNode(nodes[0]))
+	require.Equal(t, 3, queue.Len())
+
+	// the new nodes are due right away, before the rescheduled ones
+	now := time.Now()
+	queue.Postpone(enode.ID{1}, now.Add(time.Hour))
+	queue.Postpone(enode.ID{2}, now.Add(-time.Minute))
+	hInfo, ok := queue.Next(context.Background(), doneC)
+	require.True(t, ok)
+	require.Equal(t, enode.ID{3}, hInfo.ID)
+	hInfo, ok = queue.Next(context.Background(), doneC)
+	require.True(t, ok)
+	require.Equal(t, enode.ID{2}, hInfo.ID)
+
+	// the nodes being dialed stay tracked, and are queued again with the result
+	attempt := models.NewConnectionAttempt(enode.ID{3})
+	attempt.Status = models.SuccessfulConnection
+	queue.UpdateNodeFromConnAttempt(enode.ID{3}, &attempt, true, time.Hour)
+	require.Equal(t, 3, queue.Len())
+	attempt = models.NewConnectionAttempt(enode.ID{2})
+	attempt.Status = models.SuccessfulConnection
+	queue.UpdateNodeFromConnAttempt(enode.ID{2}, &attempt, false, time.Hour)
+	require.True(t, attempt.Deprecable)
+	require.False(t, queue.IsPeerAlready(enode.ID{2}))
+
+	// Next blocks until a node is due, and wakes up if one is rescheduled earlier
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		queue.Postpone(enode.ID{1}, time.Now())
+	}()
+	hInfo, ok = queue.Next(context.Background(), doneC)
+	require.True(t, ok)
+	require.Equal(t, enode.ID{1}, hInfo.ID)
+
+	queue.RemoveNode(enode.ID{3})
+	require.Equal(t, 1, queue.Len())
+	close(doneC)
+	_, ok = queue.Next(context.Background(), doneC)
+	require.False(t, ok)
+}
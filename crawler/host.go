@@ -5,15 +5,22 @@ import (
 	"crypto/ecdsa"
 	"fmt"
 	"math/big"
+	"math/rand"
 	"net"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/cmd/devp2p/tooling/ethtest"
-	"github.com/ethereum/go-ethereum/core"
-	"github.com/ethereum/go-ethereum/core/forkid"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/protocols/eth"
+	"github.com/ethereum/go-ethereum/eth/protocols/snap"
 	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/p2p/rlpx"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
@@ -22,6 +29,24 @@ import (
 
 const (
 	Timeout = 15 * time.Second
+	// soft limit of the response to the snap probe
+	SnapProbeBytes = 4096
+)
+
+var (
+	// subprotocols that the host offers by default
+	DefaultCaps = []p2p.Cap{
+		{Name: "eth", Version: 66},
+		{Name: "eth", Version: 67},
+		{Name: "eth", Version: 68},
+		{Name: "snap", Version: 1},
+	}
+	// the les servers expect their own handshake, so they are only offered on demand
+	LESCaps = []p2p.Cap{
+		{Name: "les", Version: 2},
+		{Name: "les", Version: 3},
+		{Name: "les", Version: 4},
+	}
 )
 
 type Host struct {
@@ -31,48 +56,63 @@ type Host struct {
 	dialer net.Dialer
 	privk  *ecdsa.PrivateKey
 
+	// inbound connections
+	listenAddr *net.TCPAddr
+	listener   net.Listener
+	closeC     chan struct{}
+	wg         sync.WaitGroup
+
 	// HandshakeDetails
 	caps                []p2p.Cap
 	highestProtoVersion uint
 
+	network *models.Network
+	// the status we send, updated with the highest TD seen in the network
+	statusM          sync.RWMutex
 	localChainStatus ethtest.Status
+
+	// head probing
+	probeHead bool
+	probeSnap bool
+	headM     sync.RWMutex
+	bestHead  models.HeadBlock
+
+	// keep-alive sessions
+	maxSessions     int
+	sessionsM       sync.RWMutex
+	sessions        map[enode.ID]*session
+	closingSessions bool
+	sessionsWG      sync.WaitGroup
+	sessionC        chan *models.Session
+	txSampler       *TxSampler
 }
 
 type HostOption func(*Host) error
 
 func NewHost(ctx context.Context, ip string, port int, timeout time.Duration, opts ...HostOption) (*Host, error) {
-	ad := fmt.Sprintf("%s:%d", ip, port)
-	addr, err := net.ResolveTCPAddr("tcp", ad)
+	addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
 
 	if err != nil {
 		return nil, err
 	}
 	logrus.Debugf("pub addr of the host: %s", addr.String())
 	newPrivk, _ := crypto.GenerateKey()
-	genesis := core.DefaultGenesisBlock()
 	h := &Host{
 		ctx: ctx,
 		dialer: net.Dialer{
 			Timeout:   timeout,
 			LocalAddr: addr,
 		},
-		privk: newPrivk,
-		caps: []p2p.Cap{
-			{Name: "eth", Version: 66},
-			{Name: "eth", Version: 67},
-			{Name: "eth", Version: 68},
-		},
+		privk:               newPrivk,
+		listenAddr:          addr,
+		closeC:              make(chan struct{}),
+		sessions:            make(map[enode.ID]*session),
+		sessionC:            make(chan *models.Session),
+		caps:                DefaultCaps,
 		highestProtoVersion: 68,
-		// fill the local status with the mainnet-genesis
-		localChainStatus: ethtest.Status{
-			ProtocolVersion: uint32(0),
-			NetworkID:       uint64(1),
-			TD:              big.NewInt(0),
-			Head:            genesis.ToBlock().Hash(),
-			Genesis:         genesis.ToBlock().Hash(),
-			ForkID:          forkid.NewID(genesis.Config, genesis.ToBlock().Hash(), 0, genesis.Timestamp),
-		},
 	}
+	// fill the local status with the mainnet-genesis by default
+	h.setNetwork(models.MainnetNetworkDetails())
 	for _, opt := range opts {
 		err := opt(h)
 		if err != nil {
@@ -82,6 +122,30 @@ func NewHost(ctx context.Context, ip string, port int, timeout time.Duration, op
 	return h, nil
 }
 
+// setNetwork resets the local chain status to the genesis of the given network
+func (h *Host) setNetwork(network *models.Network) {
+	h.network = network
+	h.localChainStatus = ethtest.Status{
+		ProtocolVersion: uint32(0),
+		NetworkID:       network.NetworkID,
+		TD:              big.NewInt(0),
+		Head:            network.GenesisHash,
+		Genesis:         network.GenesisHash,
+		ForkID:          network.ForkID(),
+	}
+}
+
+// crawl any network different from mainnet
+func WithNetwork(network *models.Network) HostOption {
+	return func(h *Host) error {
+		if network == nil {
+			return errors.New("no network was given")
+		}
+		h.setNetwork(network)
+		return nil
+	}
+}
+
 // overrides the the new key with a custom one (to have the same node_id)
 func WithPrivKey(privk *ecdsa.PrivateKey) HostOption {
 	return func(h *Host) error {
@@ -106,45 +170,99 @@ func WithHighestProtoVersion(version int) HostOption {
 	}
 }
 
+// request the head header of the nodes after the status exchange to know their sync status
+func WithHeadProbe(probe bool) HostOption {
+	return func(h *Host) error {
+		h.probeHead = probe
+		return nil
+	}
+}
+
+// request a range of accounts of the head state to the nodes that negotiate snap, to
+// verify that they serve the snap data (it implies probing the head)
+func WithSnapProbe(probe bool) HostOption {
+	return func(h *Host) error {
+		h.probeSnap = probe
+		return nil
+	}
+}
+
+// keep open up to the given number of connections after identifying the nodes, to
+// measure how long the nodes remain connected
+func WithKeepAlive(maxSessions int) HostOption {
+	return func(h *Host) error {
+		h.maxSessions = maxSessions
+		return nil
+	}
+}
+
+// record the tx gossip that the nodes send over the keep-alive sessions
+func WithTxSampling(sample bool) HostOption {
+	return func(h *Host) error {
+		if sample {
+			h.txSampler = NewTxSampler(h.ctx)
+		}
+		return nil
+	}
+}
+
 // --- host related methods ---
 
-// Connect attempts to connect a given node getting a list of details from each handshake
-func (h *Host) Connect(remoteNode *models.HostInfo) (ethtest.HandshakeDetails, models.ChainDetails, error) {
+// Connect attempts to connect a given node getting a list of details from each handshake,
+// and how long each of the phases of the connection took
+func (h *Host) Connect(remoteNode *models.HostInfo) (ethtest.HandshakeDetails, models.ChainDetails, models.ConnectionTimings, error) {
+	timings := models.ConnectionTimings{}
 	// make handshake
-	conn, hadshakeDetails, err := h.dial(remoteNode.IP, remoteNode.TCP, remoteNode.Pubkey)
+	conn, hadshakeDetails, err := h.dial(remoteNode.IP, remoteNode.TCP, remoteNode.Pubkey, &timings)
 	if err != nil {
-		return hadshakeDetails, models.ChainDetails{}, err
+		return hadshakeDetails, models.ChainDetails{}, timings, err
 	}
-	defer conn.Close()
 
 	// If node provides no eth version, we can skip it.
 	if hadshakeDetails.NegotiatedProtoVersion == 0 {
-		return hadshakeDetails, models.ChainDetails{}, nil
+		conn.Close()
+		return hadshakeDetails, models.ChainDetails{}, timings, nil
 	}
-	chainDetails, err := h.getChainStatus(conn)
+	chainDetails, err := h.getChainStatus(conn, h.MatchProtocols(hadshakeDetails.Capabilities), &timings)
 	if err != nil {
-		return hadshakeDetails, chainDetails, err
+		conn.Close()
+		return hadshakeDetails, chainDetails, timings, err
+	}
+	// the session takes care of the connection from now on
+	if h.keepSession(conn, enode.PubkeyToIDV4(remoteNode.Pubkey), chainDetails) {
+		return hadshakeDetails, chainDetails, timings, nil
 	}
-	return hadshakeDetails, chainDetails, nil
+	h.disconnect(conn)
+	return hadshakeDetails, chainDetails, timings, nil
 }
 
 // dial opens a new net connection with the respective rlxp one to make the handshakes
-func (h *Host) dial(ip string, port int, pubkey *ecdsa.PublicKey) (*ethtest.Conn, ethtest.HandshakeDetails, error) {
-	netConn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", ip, port), h.dialer.Timeout)
+func (h *Host) dial(
+	ip string, port int, pubkey *ecdsa.PublicKey, timings *models.ConnectionTimings,
+) (*ethtest.Conn, ethtest.HandshakeDetails, error) {
+	t := time.Now()
+	netConn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(port)), h.dialer.Timeout)
 	if err != nil {
 		return &ethtest.Conn{}, ethtest.HandshakeDetails{Error: errors.Wrap(err, "unable to net.dial node")}, err
 	}
+	timings.TCP = time.Since(t)
 	conn := &ethtest.Conn{
 		Conn: rlpx.NewConn(netConn, pubkey),
 	}
+	t = time.Now()
 	_, err = conn.Handshake(h.privk)
 	if err != nil {
+		netConn.Close()
 		return &ethtest.Conn{}, ethtest.HandshakeDetails{Error: err}, err
 	}
+	timings.RLPx = time.Since(t)
+	t = time.Now()
 	details, err := h.makeHelloHandshake(conn)
 	if err != nil {
+		conn.Close()
 		return conn, ethtest.HandshakeDetails{Error: err}, errors.Wrap(err, "unable to initiate Handshake with node")
 	}
+	timings.Hello = time.Since(t)
 	return conn, details, err
 }
 
@@ -154,8 +272,15 @@ func (h *Host) makeHelloHandshake(conn *ethtest.Conn) (ethtest.HandshakeDetails,
 	return conn.DetailedHandshake(h.privk, h.caps, h.highestProtoVersion)
 }
 
+// MatchProtocols returns the subprotocols negotiated with a node that offers the given caps
+func (h *Host) MatchProtocols(caps []p2p.Cap) []models.Protocol {
+	return models.MatchProtocols(h.caps, caps)
+}
+
 // check ids at: https://chainid.network/
-func (h *Host) getChainStatus(conn *ethtest.Conn) (models.ChainDetails, error) {
+func (h *Host) getChainStatus(
+	conn *ethtest.Conn, protocols []models.Protocol, timings *models.ConnectionTimings,
+) (models.ChainDetails, error) {
 	// get chain status
 	err := conn.SetDeadline(time.Now().Add(Timeout))
 	if err != nil {
@@ -164,7 +289,8 @@ func (h *Host) getChainStatus(conn *ethtest.Conn) (models.ChainDetails, error) {
 
 	// Regardless of whether we wrote a status message or not, the remote side
 	// might still send us one.
-	err = conn.Write(h.localChainStatus)
+	t := time.Now()
+	err = conn.Write(h.localStatus())
 	if err != nil {
 		return models.ChainDetails{}, err
 	}
@@ -173,10 +299,32 @@ func (h *Host) getChainStatus(conn *ethtest.Conn) (models.ChainDetails, error) {
 	if err != nil {
 		return models.ChainDetails{}, err
 	}
+	timings.Status = time.Since(t)
+	// a failed probe doesn't invalidate the status that we already got
+	if h.probeHead || h.probeSnap {
+		head, err := h.probeHeadBlock(conn, &remoteStatus)
+		if err != nil {
+			logrus.Tracef("unable to probe head block: %s", err.Error())
+		}
+		snapProto, snapNegotiated := models.GetProtocol(protocols, "snap")
+		if h.probeSnap && snapNegotiated && head != nil {
+			remoteStatus.SnapStatus = h.probeSnapState(conn, snapProto, head.Root)
+		}
+	}
+	return remoteStatus, nil
+}
 
-	// Disconnect from client
+// disconnect notifies the node that we are leaving and closes the connection
+func (h *Host) disconnect(conn *ethtest.Conn) {
 	_ = conn.Write(ethtest.Disconnect{Reason: p2p.DiscQuitting})
-	return remoteStatus, nil
+	conn.Close()
+}
+
+// localStatus returns the status that we send to the nodes
+func (h *Host) localStatus() ethtest.Status {
+	h.statusM.RLock()
+	defer h.statusM.RUnlock()
+	return h.localChainStatus
 }
 
 func (h *Host) readStatusBack(conn *ethtest.Conn, status *models.ChainDetails) error {
@@ -187,11 +335,15 @@ func (h *Host) readStatusBack(conn *ethtest.Conn, status *models.ChainDetails) e
 		status.NetworkID = msg.NetworkID
 		status.ProtocolVersion = msg.ProtocolVersion
 		status.TotalDifficulty = msg.TD
+		status.ForkClass = h.network.ClassifyForkID(msg.ForkID)
 		// check if we belong to the same network and update it if we see that they have a bigger head
-		if msg.NetworkID == h.localChainStatus.NetworkID && msg.TD.Cmp(h.localChainStatus.TD) > 0 {
+		h.statusM.Lock()
+		if msg.NetworkID == h.localChainStatus.NetworkID && msg.Genesis == h.localChainStatus.Genesis &&
+			msg.TD.Cmp(h.localChainStatus.TD) > 0 {
 			// update local TD if received TD is higher
 			h.localChainStatus = *msg
 		}
+		h.statusM.Unlock()
 
 	case *ethtest.Disconnect:
 		return fmt.Errorf("bad status handshake disconnect: %v", msg.Reason.Error())
@@ -203,8 +355,238 @@ func (h *Host) readStatusBack(conn *ethtest.Conn, status *models.ChainDetails) e
 	return nil
 }
 
+// probeHeadBlock requests the header of the head that the node announced in its status
+func (h *Host) probeHeadBlock(conn *ethtest.Conn, status *models.ChainDetails) (*types.Header, error) {
+	reqID := rand.Uint64()
+	err := conn.Write(ethtest.GetBlockHeaders{
+		RequestId: reqID,
+		GetBlockHeadersPacket: &eth.GetBlockHeadersPacket{
+			Origin: eth.HashOrNumber{Hash: status.HeadHash},
+			Amount: 1,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to request head header")
+	}
+	// the node might send us some other messages before answering
+	for {
+		switch msg := conn.Read().(type) {
+		case *ethtest.BlockHeaders:
+			if msg.RequestId != reqID {
+				continue
+			}
+			if len(msg.BlockHeadersPacket) == 0 {
+				return nil, errors.New("empty head header response")
+			}
+			header := msg.BlockHeadersPacket[0]
+			if header.Hash() != status.HeadHash {
+				return nil, errors.New("head header doesn't match the announced head")
+			}
+			status.HeadBlock = models.HeadBlock{
+				Number:    header.Number.Uint64(),
+				Timestamp: time.Unix(int64(header.Time), 0),
+			}
+			status.SyncStatus = h.classifySync(status)
+			return header, nil
+		case *ethtest.GetBlockHeaders:
+			// we don't have any block, but we reply to remain a well-behaved peer
+			_ = conn.Write(ethtest.BlockHeaders{RequestId: msg.RequestId})
+		case *ethtest.Ping:
+			_ = conn.Write(ethtest.Pong{})
+		case *ethtest.Disconnect:
+			return nil, fmt.Errorf("disconnected while probing head: %v", msg.Reason.Error())
+		case *ethtest.Error:
+			return nil, errors.Wrap(msg, "unable to read head header")
+		}
+	}
+}
+
+// probeSnapState requests the first accounts of the given state root through snap, the
+// nodes that have the state must return some of them (or at least its proof)
+func (h *Host) probeSnapState(conn *ethtest.Conn, snapProto models.Protocol, root common.Hash) models.SnapStatus {
+	reqID := rand.Uint64()
+	payload, err := rlp.EncodeToBytes(&snap.GetAccountRangePacket{
+		ID:     reqID,
+		Root:   root,
+		Origin: common.Hash{},
+		Limit:  common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+		Bytes:  SnapProbeBytes,
+	})
+	if err != nil {
+		return models.SnapUnknown
+	}
+	_, err = conn.Conn.Write(snapProto.Offset+snap.GetAccountRangeMsg, payload)
+	if err != nil {
+		return models.SnapUnresponsive
+	}
+	// snap messages are read raw, as their codes depend on the negotiated subprotocols
+	for {
+		code, data, _, err := conn.Conn.Read()
+		if err != nil {
+			return models.SnapUnresponsive
+		}
+		switch code {
+		case snapProto.Offset + snap.AccountRangeMsg:
+			var resp struct {
+				ID       uint64
+				Accounts []rlp.RawValue
+				Proof    []rlp.RawValue
+			}
+			if err := rlp.DecodeBytes(data, &resp); err != nil || resp.ID != reqID {
+				continue
+			}
+			if len(resp.Accounts) == 0 && len(resp.Proof) == 0 {
+				return models.SnapNotServing
+			}
+			return models.SnapServing
+		case disconnectMsg:
+			return models.SnapUnresponsive
+		case pingMsg:
+			_, _ = conn.Conn.Write(pongMsg, []byte{0xc0})
+		}
+	}
+}
+
+// classifySync compares the head of the node with the best head seen so far, which is
+// only updated with the heads of nodes that follow our chain
+func (h *Host) classifySync(status *models.ChainDetails) models.SyncStatus {
+	if status.NetworkID != h.network.NetworkID {
+		return models.SyncUnknown
+	}
+	followsChain := status.ForkClass != models.ForkStale && status.ForkClass != models.ForkIncompatible
+	// don't trust heads from the future
+	if followsChain && status.HeadBlock.Timestamp.Before(time.Now().Add(models.SyncedTolerance)) {
+		h.headM.Lock()
+		if status.HeadBlock.Number > h.bestHead.Number {
+			h.bestHead = status.HeadBlock
+		}
+		h.headM.Unlock()
+	}
+	return models.ClassifySync(status.HeadBlock, h.BestHead())
+}
+
+// BestHead returns the highest head that was probed from the nodes of the network
+func (h *Host) BestHead() models.HeadBlock {
+	h.headM.RLock()
+	defer h.headM.RUnlock()
+	return h.bestHead
+}
+
+// InboundConn is the result of identifying a node that dialed us
+type InboundConn struct {
+	HostInfo         models.HostInfo
+	HandshakeDetails ethtest.HandshakeDetails
+	ChainDetails     models.ChainDetails
+	Duration         time.Duration
+	Timings          models.ConnectionTimings // the TCP phase is left at zero
+	Err              error
+}
+
+// Listen starts accepting RLPx connections at the host address, identifying each of the
+// nodes that dial us with the same Hello and Status exchange that we use when dialing
+func (h *Host) Listen(maxConns int) (chan *InboundConn, error) {
+	listener, err := net.ListenTCP("tcp", h.listenAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to listen for inbound connections")
+	}
+	h.listener = listener
+	inboundC := make(chan *InboundConn)
+	slots := make(chan struct{}, maxConns)
+	logrus.WithFields(logrus.Fields{
+		"addr":      listener.Addr().String(),
+		"max-conns": maxConns,
+	}).Info("listening for inbound connections")
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		for {
+			netConn, err := listener.Accept()
+			if err != nil {
+				select {
+				case <-h.closeC:
+				default:
+					logrus.Error(errors.Wrap(err, "unable to accept inbound connection"))
+				}
+				return
+			}
+			// drop the connection if we are already identifying too many nodes
+			select {
+			case slots <- struct{}{}:
+			default:
+				logrus.Debugf("too many inbound connections, dropping %s", netConn.RemoteAddr())
+				netConn.Close()
+				continue
+			}
+			h.wg.Add(1)
+			go func() {
+				defer func() {
+					<-slots
+					h.wg.Done()
+				}()
+				inbound := h.identifyInbound(netConn)
+				select {
+				case inboundC <- inbound:
+				case <-h.closeC:
+				case <-h.ctx.Done():
+				}
+			}()
+		}
+	}()
+	return inboundC, nil
+}
+
+func (h *Host) identifyInbound(netConn net.Conn) *InboundConn {
+	t := time.Now()
+	inbound := &InboundConn{}
+	if tcpAddr, ok := netConn.RemoteAddr().(*net.TCPAddr); ok {
+		inbound.HostInfo.IP = tcpAddr.IP.String()
+	}
+	conn := &ethtest.Conn{
+		// no remote key, as we are the recipient of the connection
+		Conn: rlpx.NewConn(netConn, nil),
+	}
+	defer conn.Close()
+	err := conn.SetDeadline(time.Now().Add(h.dialer.Timeout))
+	if err != nil {
+		inbound.Err = err
+		return inbound
+	}
+	phaseT := time.Now()
+	pubkey, err := conn.Handshake(h.privk)
+	if err != nil {
+		inbound.Err = err
+		return inbound
+	}
+	inbound.Timings.RLPx = time.Since(phaseT)
+	inbound.HostInfo.Pubkey = pubkey
+	inbound.HostInfo.ID = enode.PubkeyToIDV4(pubkey)
+	phaseT = time.Now()
+	inbound.HandshakeDetails, err = h.makeHelloHandshake(conn)
+	if err != nil {
+		inbound.Err = errors.Wrap(err, "unable to initiate Handshake with node")
+		return inbound
+	}
+	inbound.Timings.Hello = time.Since(phaseT)
+	// If node provides no eth version, we can skip the status exchange
+	if inbound.HandshakeDetails.NegotiatedProtoVersion != 0 {
+		protocols := h.MatchProtocols(inbound.HandshakeDetails.Capabilities)
+		inbound.ChainDetails, inbound.Err = h.getChainStatus(conn, protocols, &inbound.Timings)
+		if inbound.Err == nil {
+			_ = conn.Write(ethtest.Disconnect{Reason: p2p.DiscQuitting})
+		}
+	}
+	inbound.Duration = time.Since(t)
+	return inbound
+}
+
+// Close stops accepting inbound connections and waits for the ongoing ones
 func (h *Host) Close() {
-	// close all existing connections
+	close(h.closeC)
+	if h.listener != nil {
+		h.listener.Close()
+	}
+	h.wg.Wait()
 }
 
 func GetPublicIP() (net.IP, error) {
@@ -0,0 +1,218 @@
+package crawler
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+
+	"github.com/cortze/ragno/models"
+)
+
+// window over which the started dials are rate limited
+const DialLimitWindow = 1 * time.Minute
+
+// DialLimit caps the dials towards the nodes that share an ip, a subnet or an ASN
+type DialLimit struct {
+	Concurrent int // dials in flight at the same time (0 for no limit)
+	PerMinute  int // dials started within the last minute (0 for no limit)
+}
+
+// ParseDialLimit parses limits with the "<concurrent>/<per-minute>" format
+func ParseDialLimit(str string) (DialLimit, error) {
+	parts := strings.Split(str, "/")
+	if len(parts) != 2 {
+		return DialLimit{}, fmt.Errorf("dial limit %s doesn't follow the <concurrent>/<per-minute> format", str)
+	}
+	concurrent, err := strconv.Atoi(parts[0])
+	if err != nil || concurrent < 0 {
+		return DialLimit{}, fmt.Errorf("invalid concurrent dials %s", parts[0])
+	}
+	perMinute, err := strconv.Atoi(parts[1])
+	if err != nil || perMinute < 0 {
+		return DialLimit{}, fmt.Errorf("invalid dials per minute %s", parts[1])
+	}
+	return DialLimit{Concurrent: concurrent, PerMinute: perMinute}, nil
+}
+
+func (l DialLimit) String() string {
+	return fmt.Sprintf("%d/%d", l.Concurrent, l.PerMinute)
+}
+
+// DialLimits are the limits applied at each of the levels
+type DialLimits struct {
+	IP     DialLimit
+	Subnet DialLimit // /24 for IPv4 and /48 for IPv6
+	ASN    DialLimit // only applied to the ips located by the IPLocator
+}
+
+type LimitLevel int8
+
+func (l LimitLevel) String() (str string) {
+	switch l {
+	case IPLimit:
+		str = "ip"
+	case SubnetLimit:
+		str = "subnet"
+	case ASNLimit:
+		str = "asn"
+	default:
+		str = "unknown"
+	}
+	return str
+}
+
+const (
+	IPLimit LimitLevel = iota
+	SubnetLimit
+	ASNLimit
+)
+
+type limitKey struct {
+	level LimitLevel
+	key   string
+}
+
+// DialLimiter keeps track of the dials in flight and of the recently started ones, to
+// avoid bursts of dials towards the nodes that are hosted together
+type DialLimiter struct {
+	m        sync.Mutex
+	limits   DialLimits
+	asns     map[string]string // ip -> asn
+	inFlight map[limitKey]int
+	started  map[limitKey][]time.Time
+	// keys acquired by each of the ongoing dials
+	acquired  map[enode.ID][]limitKey
+	throttled map[LimitLevel]uint64
+}
+
+func NewDialLimiter(limits DialLimits) *DialLimiter {
+	return &DialLimiter{
+		limits:    limits,
+		asns:      make(map[string]string),
+		inFlight:  make(map[limitKey]int),
+		started:   make(map[limitKey][]time.Time),
+		acquired:  make(map[enode.ID][]limitKey),
+		throttled: make(map[LimitLevel]uint64),
+	}
+}
+
+// UpdateASNs replaces the ASNs known for each of the ips
+func (l *DialLimiter) UpdateASNs(asns map[string]string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.asns = asns
+}
+
+// Acquire reserves a dial towards the node if none of the limits of its ip, subnet or
+// ASN was reached, returning false otherwise. Acquired dials have to be released
+func (l *DialLimiter) Acquire(hInfo models.HostInfo, now time.Time) bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+	keys := l.keys(hInfo.IP)
+	for _, key := range keys {
+		limit := l.limit(key.level)
+		if limit.Concurrent > 0 && l.inFlight[key] >= limit.Concurrent {
+			l.throttled[key.level]++
+			return false
+		}
+		if limit.PerMinute > 0 && len(l.recentDials(key, now)) >= limit.PerMinute {
+			l.throttled[key.level]++
+			return false
+		}
+	}
+	for _, key := range keys {
+		l.inFlight[key]++
+		l.started[key] = append(l.started[key], now)
+	}
+	l.acquired[hInfo.ID] = keys
+	return true
+}
+
+// Release frees the dial that was acquired for the node
+func (l *DialLimiter) Release(nodeID enode.ID) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	for _, key := range l.acquired[nodeID] {
+		l.inFlight[key]--
+		if l.inFlight[key] <= 0 {
+			delete(l.inFlight, key)
+		}
+	}
+	delete(l.acquired, nodeID)
+}
+
+// Prune drops the dials that are already out of the rate limit window
+func (l *DialLimiter) Prune(now time.Time) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	for key := range l.started {
+		l.recentDials(key, now)
+	}
+}
+
+// Throttled returns the number of dials that were postponed by each of the limit levels
+func (l *DialLimiter) Throttled() map[LimitLevel]uint64 {
+	l.m.Lock()
+	defer l.m.Unlock()
+	throttled := make(map[LimitLevel]uint64, len(l.throttled))
+	for level, count := range l.throttled {
+		throttled[level] = count
+	}
+	return throttled
+}
+
+// recentDials drops the dials that fall out of the window, returning the remaining ones
+func (l *DialLimiter) recentDials(key limitKey, now time.Time) []time.Time {
+	dials := l.started[key]
+	idx := 0
+	for idx < len(dials) && now.Sub(dials[idx]) >= DialLimitWindow {
+		idx++
+	}
+	dials = dials[idx:]
+	if len(dials) == 0 {
+		delete(l.started, key)
+	} else {
+		l.started[key] = dials
+	}
+	return dials
+}
+
+func (l *DialLimiter) limit(level LimitLevel) DialLimit {
+	switch level {
+	case IPLimit:
+		return l.limits.IP
+	case SubnetLimit:
+		return l.limits.Subnet
+	case ASNLimit:
+		return l.limits.ASN
+	default:
+		return DialLimit{}
+	}
+}
+
+func (l *DialLimiter) keys(ip string) []limitKey {
+	keys := make([]limitKey, 0, 3)
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return keys
+	}
+	keys = append(keys, limitKey{level: IPLimit, key: parsed.String()})
+	keys = append(keys, limitKey{level: SubnetLimit, key: subnet(parsed)})
+	if asn, ok := l.asns[parsed.String()]; ok && asn != "" {
+		keys = append(keys, limitKey{level: ASNLimit, key: asn})
+	}
+	return keys
+}
+
+// subnet returns the /24 of the IPv4s, and the /48 of the IPv6s
+func subnet(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
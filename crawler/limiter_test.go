@@ -0,0 +1,77 @@
+package crawler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortze/ragno/models"
+)
+
+func TestParseDialLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    string
+		expected DialLimit
+		valid    bool
+	}{
+		{name: "Test Valid Limit", limit: "2/10", expected: DialLimit{Concurrent: 2, PerMinute: 10}, valid: true},
+		{name: "Test No Limit", limit: "0/0", expected: DialLimit{}, valid: true},
+		{name: "Test Missing Rate", limit: "2", valid: false},
+		{name: "Test Negative Limit", limit: "-1/10", valid: false},
+	}
+	for _, testItem := range tests {
+		t.Run(testItem.name, func(t *testing.T) {
+			limit, err := ParseDialLimit(testItem.limit)
+			if !testItem.valid {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, testItem.expected, limit)
+		})
+	}
+}
+
+func TestDialLimiter(t *testing.T) {
+	limiter := NewDialLimiter(DialLimits{
+		IP:     DialLimit{Concurrent: 1, PerMinute: 2},
+		Subnet: DialLimit{Concurrent: 2},
+		ASN:    DialLimit{Concurrent: 2},
+	})
+	limiter.UpdateASNs(map[string]string{"10.0.1.1": "AS1", "10.0.2.1": "AS1", "10.0.3.1": "AS1"})
+	hostInfo := func(id byte, ip string) models.HostInfo {
+		return models.HostInfo{ID: enode.ID{id}, IP: ip}
+	}
+	now := time.Now()
+
+	// the ip limit applies to the nodes behind the same ip
+	require.True(t, limiter.Acquire(hostInfo(1, "10.0.0.1"), now))
+	require.False(t, limiter.Acquire(hostInfo(2, "10.0.0.1"), now))
+	// the subnet limit to the ones in the same /24
+	require.True(t, limiter.Acquire(hostInfo(3, "10.0.0.2"), now))
+	require.False(t, limiter.Acquire(hostInfo(4, "10.0.0.3"), now))
+	// and the asn limit across subnets
+	require.True(t, limiter.Acquire(hostInfo(5, "10.0.1.1"), now))
+	require.True(t, limiter.Acquire(hostInfo(6, "10.0.2.1"), now))
+	require.False(t, limiter.Acquire(hostInfo(7, "10.0.3.1"), now))
+	// the IPv6 subnets are the /48
+	require.True(t, limiter.Acquire(hostInfo(8, "2001:db8:1::1"), now))
+	require.True(t, limiter.Acquire(hostInfo(10, "2001:db8:1:2::1"), now))
+	require.False(t, limiter.Acquire(hostInfo(11, "2001:db8:1:3::1"), now))
+
+	// releasing the dials frees the concurrent slots, but not the per-minute ones
+	limiter.Release(enode.ID{1})
+	limiter.Release(enode.ID{3})
+	require.True(t, limiter.Acquire(hostInfo(2, "10.0.0.1"), now))
+	limiter.Release(enode.ID{2})
+	require.False(t, limiter.Acquire(hostInfo(9, "10.0.0.1"), now))
+	require.True(t, limiter.Acquire(hostInfo(9, "10.0.0.1"), now.Add(DialLimitWindow)))
+
+	throttled := limiter.Throttled()
+	require.Equal(t, uint64(2), throttled[IPLimit])
+	require.Equal(t, uint64(2), throttled[SubnetLimit])
+	require.Equal(t, uint64(1), throttled[ASNLimit])
+}
@@ -2,10 +2,12 @@ package crawler
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 
+	"github.com/cortze/ragno/models"
 	"github.com/cortze/ragno/pkg/metrics"
 )
 
@@ -80,6 +82,68 @@ var (
 	},
 		[]string{"numbernodes"},
 	)
+	DiscoveredENRs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: moduleName,
+		Name:      "discovered_enrs",
+		Help:      "Number of ENRs received from each of the discovery sources",
+	},
+		[]string{"source"},
+	)
+	Discv4CrawlRound = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: moduleName,
+		Name:      "discv4_crawl_round_nodes",
+		Help:      "Number of nodes discovered (the estimated size of the network) and reachable in the last discv4 crawl round",
+	},
+		[]string{"nodes"},
+	)
+	Discv4CrawlRoundDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: moduleName,
+		Name:      "discv4_crawl_round_duration_seconds",
+		Help:      "Duration of the last discv4 crawl round",
+	})
+	ThrottledDials = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: moduleName,
+		Name:      "throttled_dials",
+		Help:      "Number of dials postponed by the ip, subnet and asn dial limits",
+	},
+		[]string{"limit"},
+	)
+	ForkCompatibility = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: moduleName,
+		Name:      "fork_compatibility",
+		Help:      "Number of active nodes whose fork id is compatible, stale, future or incompatible with the network",
+	},
+		[]string{"class"},
+	)
+	SyncStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: moduleName,
+		Name:      "sync_status",
+		Help:      "Number of active nodes whose probed head is synced, lagging or stalled",
+	},
+		[]string{"status"},
+	)
+	ConnPhaseLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: moduleName,
+		Name:      "conn_phase_latency_seconds",
+		Help:      "Duration of each of the phases of the connections (tcp, rlpx, hello and status)",
+		Buckets:   []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	},
+		[]string{"phase"},
+	)
+	ProtocolDistribution = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: moduleName,
+		Name:      "subprotocol_distribution",
+		Help:      "Number of active nodes that negotiated each of the subprotocols",
+	},
+		[]string{"protocol"},
+	)
+	SnapServing = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: moduleName,
+		Name:      "snap_serving",
+		Help:      "Number of active nodes that serve, or not, the snap data of their head state",
+	},
+		[]string{"status"},
+	)
 )
 
 func (crawler *Crawler) GetMetrics() *metrics.MetricsModule {
@@ -98,6 +162,14 @@ func (crawler *Crawler) GetMetrics() *metrics.MetricsModule {
 	metricsModule.AddMetric(crawler.getHostedPeers())
 	metricsModule.AddMetric(crawler.getRTTDist())
 	metricsModule.AddMetric(crawler.getIPDist())
+	metricsModule.AddMetric(crawler.discoveredENRsMetrics())
+	metricsModule.AddMetric(crawler.discv4CrawlRoundMetrics())
+	metricsModule.AddMetric(crawler.throttledDialsMetrics())
+	metricsModule.AddMetric(crawler.forkCompatibilityMetrics())
+	metricsModule.AddMetric(crawler.syncStatusMetrics())
+	metricsModule.AddMetric(crawler.protocolDistributionMetrics())
+	metricsModule.AddMetric(crawler.snapServingMetrics())
+	metricsModule.AddMetric(crawler.connPhaseLatencyMetrics())
 	return (metricsModule)
 }
 
@@ -177,7 +249,7 @@ func (c *Crawler) nodeDistributionMetrics() *metrics.Metric {
 		return nil
 	}
 	updateFn := func() (interface{}, error) {
-		peerLs, err := c.db.GetNonDeprecatedNodes(c.peering.host.localChainStatus.NetworkID)
+		peerLs, err := c.db.GetNonDeprecatedNodes(c.peering.host.network.NetworkID)
 		if err != nil {
 			return nil, err
 		}
@@ -329,3 +401,192 @@ func (c *Crawler) getIPDist() *metrics.Metric {
 	)
 	return indvMetric
 }
+
+func (c *Crawler) discoveredENRsMetrics() *metrics.Metric {
+	initFn := func() error {
+		prometheus.MustRegister(DiscoveredENRs)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		summary := c.peerDisc.ENRsBySource()
+		for source, cnt := range summary {
+			DiscoveredENRs.WithLabelValues(source).Set(float64(cnt))
+		}
+		return summary, nil
+	}
+	indvMetric := metrics.NewMetric(
+		"discovered_enrs",
+		initFn,
+		updateFn,
+	)
+	return indvMetric
+}
+
+// the rounds are only reported when the discv4 crawler is one of the discovery sources
+func (c *Crawler) discv4CrawlRoundMetrics() *metrics.Metric {
+	initFn := func() error {
+		prometheus.MustRegister(Discv4CrawlRound)
+		prometheus.MustRegister(Discv4CrawlRoundDuration)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		round := c.peerDisc.LastCrawlRound()
+		if round == nil {
+			return nil, nil
+		}
+		Discv4CrawlRound.WithLabelValues("discovered").Set(float64(round.Discovered))
+		Discv4CrawlRound.WithLabelValues("reachable").Set(float64(round.Reachable))
+		Discv4CrawlRoundDuration.Set(round.End.Sub(round.Start).Seconds())
+		return round, nil
+	}
+	indvMetric := metrics.NewMetric(
+		"discv4_crawl_round",
+		initFn,
+		updateFn,
+	)
+	return indvMetric
+}
+
+func (c *Crawler) throttledDialsMetrics() *metrics.Metric {
+	initFn := func() error {
+		prometheus.MustRegister(ThrottledDials)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		summary := c.peering.ThrottledDials()
+		for limit, cnt := range summary {
+			ThrottledDials.WithLabelValues(limit).Set(float64(cnt))
+		}
+		return summary, nil
+	}
+	indvMetric := metrics.NewMetric(
+		"throttled_dials",
+		initFn,
+		updateFn,
+	)
+	return indvMetric
+}
+
+func (c *Crawler) forkCompatibilityMetrics() *metrics.Metric {
+	initFn := func() error {
+		prometheus.MustRegister(ForkCompatibility)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		summary, err := c.db.GetForkClassDistribution()
+		if err != nil {
+			return nil, err
+		}
+		for class, cnt := range summary {
+			ForkCompatibility.WithLabelValues(class).Set(float64(cnt.(int)))
+		}
+		return summary, nil
+	}
+	indvMetric := metrics.NewMetric(
+		"fork_compatibility",
+		initFn,
+		updateFn,
+	)
+	return indvMetric
+}
+
+func (c *Crawler) syncStatusMetrics() *metrics.Metric {
+	initFn := func() error {
+		prometheus.MustRegister(SyncStatus)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		summary, err := c.db.GetSyncStatusDistribution()
+		if err != nil {
+			return nil, err
+		}
+		for status, cnt := range summary {
+			SyncStatus.WithLabelValues(status).Set(float64(cnt.(int)))
+		}
+		return summary, nil
+	}
+	indvMetric := metrics.NewMetric(
+		"sync_status",
+		initFn,
+		updateFn,
+	)
+	return indvMetric
+}
+
+func (c *Crawler) protocolDistributionMetrics() *metrics.Metric {
+	initFn := func() error {
+		prometheus.MustRegister(ProtocolDistribution)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		summary, err := c.db.GetProtocolDistribution()
+		if err != nil {
+			return nil, err
+		}
+		for protocol, cnt := range summary {
+			ProtocolDistribution.WithLabelValues(protocol).Set(float64(cnt.(int)))
+		}
+		return summary, nil
+	}
+	indvMetric := metrics.NewMetric(
+		"subprotocol_distribution",
+		initFn,
+		updateFn,
+	)
+	return indvMetric
+}
+
+func (c *Crawler) snapServingMetrics() *metrics.Metric {
+	initFn := func() error {
+		prometheus.MustRegister(SnapServing)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		summary, err := c.db.GetSnapStatusDistribution()
+		if err != nil {
+			return nil, err
+		}
+		for status, cnt := range summary {
+			SnapServing.WithLabelValues(status).Set(float64(cnt.(int)))
+		}
+		return summary, nil
+	}
+	indvMetric := metrics.NewMetric(
+		"snap_serving",
+		initFn,
+		updateFn,
+	)
+	return indvMetric
+}
+
+// the phase latencies are observed on every connection, there is nothing to update
+func (c *Crawler) connPhaseLatencyMetrics() *metrics.Metric {
+	initFn := func() error {
+		prometheus.MustRegister(ConnPhaseLatency)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		return nil, nil
+	}
+	indvMetric := metrics.NewMetric(
+		"conn_phase_latency",
+		initFn,
+		updateFn,
+	)
+	return indvMetric
+}
+
+// observeConnTimings adds the phases that the connection reached to the histograms
+func observeConnTimings(timings models.ConnectionTimings) {
+	phases := map[string]time.Duration{
+		"tcp":    timings.TCP,
+		"rlpx":   timings.RLPx,
+		"hello":  timings.Hello,
+		"status": timings.Status,
+	}
+	for phase, duration := range phases {
+		if duration > 0 {
+			ConnPhaseLatency.WithLabelValues(phase).Observe(duration.Seconds())
+		}
+	}
+}
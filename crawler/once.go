@@ -0,0 +1,184 @@
+package crawler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cortze/ragno/models"
+)
+
+const (
+	// how often the discovery is checked for convergence
+	ConvergenceCheckInterval = 5 * time.Second
+	// delay between the retries of the nodes that didn't answer
+	OnceRetryDelay = 15 * time.Second
+)
+
+var ErrCrawlInterrupted = errors.New("crawl interrupted before finishing the pass")
+
+// RunOnce makes a single pass over the network: it discovers nodes until the discovery
+// converges (or times out), dials every known node until it answers or runs out of
+// retries, and stores the summary of the pass in the crawls table
+func (c *Crawler) RunOnce() (*models.Crawl, error) {
+	c.oneShot = true
+	crawl := models.NewCrawl(c.host.network.NetworkID)
+
+	logrus.Info("Starting peer discoverer")
+	err := c.peerDisc.Run()
+	if err != nil {
+		return crawl, errors.Wrap(err, "starting peer-discovery")
+	}
+	logrus.Info("Starting IP Locator")
+	c.IPLocator.Run()
+	logrus.Info("Starting metrics")
+	c.metrics.Start()
+
+	crawl.Discovered = c.waitDiscovery()
+	c.closeDiscovery()
+	if c.ctx.Err() == nil {
+		// the discovered nodes have to be stored before reading the ones to dial
+		c.db.Flush()
+		nodes, err := c.db.GetNonDeprecatedNodes(c.host.network.NetworkID)
+		if err != nil {
+			return crawl, errors.Wrap(err, "unable to get the nodes to dial")
+		}
+		logrus.WithFields(logrus.Fields{
+			"discovered": crawl.Discovered,
+			"known":      len(nodes),
+		}).Info("dialing every known node once")
+		c.peering.DialOnce(nodes, c.dialRetries, crawl)
+	}
+	crawl.End = time.Now()
+	crawl.Complete = c.ctx.Err() == nil
+
+	logrus.WithFields(logrus.Fields{
+		"duration":   crawl.Duration().String(),
+		"discovered": crawl.Discovered,
+		"dialed":     crawl.Dialed,
+		"reachable":  crawl.Reachable,
+		"complete":   crawl.Complete,
+	}).Info("crawl finished")
+	// the summary is stored even if the crawl was interrupted, flagged as incomplete
+	if err := c.db.InsertCrawl(crawl); err != nil {
+		return crawl, err
+	}
+	if !crawl.Complete {
+		return crawl, ErrCrawlInterrupted
+	}
+	if crawl.Reachable == 0 {
+		return crawl, errors.New("none of the dialed nodes was reachable")
+	}
+	return crawl, nil
+}
+
+// waitDiscovery waits until no new node is discovered within the convergence window, or
+// until the discovery times out, returning the number of discovered nodes
+func (c *Crawler) waitDiscovery() int {
+	timeout := time.NewTimer(c.discoveryTimeout)
+	defer timeout.Stop()
+	checkT := time.NewTicker(ConvergenceCheckInterval)
+	defer checkT.Stop()
+
+	discovered := 0
+	lastNew := time.Now()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return c.peerDisc.DiscoveredNodes()
+		case <-timeout.C:
+			logrus.Info("discovery timed out")
+			return c.peerDisc.DiscoveredNodes()
+		case <-checkT.C:
+			nodes := c.peerDisc.DiscoveredNodes()
+			if nodes > discovered {
+				discovered = nodes
+				lastNew = time.Now()
+				continue
+			}
+			if time.Since(lastNew) >= c.convergenceWindow {
+				logrus.WithField("nodes", discovered).Info("discovery converged")
+				return discovered
+			}
+		}
+	}
+}
+
+// DialOnce dials each of the nodes until it answers or runs out of retries, adding the
+// results to the summary of the crawl
+func (p *Peering) DialOnce(nodes []models.HostInfo, retries int, crawl *models.Crawl) {
+	var m sync.Mutex
+	var wg sync.WaitGroup
+	nodesC := make(chan models.HostInfo)
+	for workerID := 0; workerID < p.dialers; workerID++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for hInfo := range nodesC {
+				nInfo, reachable := p.dialWithRetries(hInfo, retries)
+				m.Lock()
+				crawl.Dialed++
+				if reachable {
+					crawl.Reachable++
+					crawl.Clients[string(models.ParseUserAgent(nInfo.ClientName).ClientName)]++
+				}
+				m.Unlock()
+			}
+		}()
+	}
+	for _, hInfo := range nodes {
+		select {
+		case nodesC <- hInfo:
+		case <-p.ctx.Done():
+		}
+		if p.ctx.Err() != nil {
+			break
+		}
+	}
+	close(nodesC)
+	wg.Wait()
+}
+
+// dialWithRetries persists every attempt, returning the info of the node and whether it
+// answered from the crawled network
+func (p *Peering) dialWithRetries(hInfo models.HostInfo, retries int) (models.NodeInfo, bool) {
+	var nInfo models.NodeInfo
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(OnceRetryDelay):
+			case <-p.ctx.Done():
+				return nInfo, false
+			}
+		}
+		// respect the dial limits as in the regular peering
+		for !p.limiter.Acquire(hInfo, time.Now()) {
+			select {
+			case <-time.After(LimitedDialDelay):
+			case <-p.ctx.Done():
+				return nInfo, false
+			}
+		}
+		connAttempt, nodeInfo, sameNetwork := p.connect(hInfo)
+		p.limiter.Release(hInfo.ID)
+		nInfo = nodeInfo
+		// a single pass doesn't deprecate the nodes, only the ones from other networks
+		connAttempt.Deprecable = connAttempt.Status == models.SuccessfulConnection && !sameNetwork
+		p.db.PersistNodeInfo(connAttempt, nInfo, sameNetwork)
+		if connAttempt.Status == models.SuccessfulConnection {
+			p.requestIPInfo(hInfo.ID.String(), hInfo.IP)
+			return nInfo, sameNetwork
+		}
+	}
+	return nInfo, false
+}
+
+// closeDiscovery stops the discovery (which the one-shot crawls stop before dialing)
+func (c *Crawler) closeDiscovery() {
+	c.discoveryClose.Do(func() {
+		logrus.Info("crawler: closing peer-discovery")
+		c.peerDisc.Close()
+	})
+}
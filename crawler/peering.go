@@ -3,11 +3,11 @@ package crawler
 import (
 	"context"
 	"net"
-	"sort"
 	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
 	"github.com/cortze/ragno/db"
@@ -16,7 +16,10 @@ import (
 )
 
 const (
-	InitDelay         = 2 * time.Second
+	// how often the nodes from the DB are added to the dial queue
+	NodesRefreshInterval = 10 * time.Second
+	// delay of the dials that hit the dial limits
+	LimitedDialDelay = 5 * time.Second
 )
 
 type Peering struct {
@@ -30,17 +33,29 @@ type Peering struct {
 	dialC           chan models.HostInfo
 	dialers         int
 	deprecationTime time.Duration
+	limiter         *DialLimiter
+
+	// inbound connections identified by the host (if it listens)
+	inboundC     chan *InboundConn
+	inboundWG    sync.WaitGroup
+	inboundDoneC chan struct{}
+
+	// sessions kept open by the host (if it keeps them)
+	sessionC      chan *models.Session
+	txSamplesC    chan *models.TxSamples
+	sessionsWG    sync.WaitGroup
+	sessionsDoneC chan struct{}
 
 	// necessary services
 	host      *Host
-	db        *db.PostgresDBService
-	nodeSet   *NodeOrderedSet
+	db        db.Storage
+	dialQueue *DialQueue
 	IPLocator *apis.IPLocator
 }
 
 func NewPeeringService(
-	ctx context.Context, h *Host, database *db.PostgresDBService, dialers int,
-	deprecationTime time.Duration, IPLocator *apis.IPLocator,
+	ctx context.Context, h *Host, database db.Storage, dialers int,
+	deprecationTime time.Duration, IPLocator *apis.IPLocator, limits DialLimits, policy DialPolicy,
 ) *Peering {
 	return &Peering{
 		ctx:             ctx,
@@ -49,13 +64,32 @@ func NewPeeringService(
 		dialC:           make(chan models.HostInfo),
 		host:            h,
 		db:              database,
-		nodeSet:         NewNodeOrderedSet(),
+		dialQueue:       NewDialQueue(policy),
 		dialers:         dialers,
 		deprecationTime: deprecationTime,
+		limiter:         NewDialLimiter(limits),
 		IPLocator:       IPLocator,
+		inboundDoneC:    make(chan struct{}),
+		sessionsDoneC:   make(chan struct{}),
 	}
 }
 
+// ServeInbound makes the peering persist the nodes that the host identifies from the
+// inbound connections
+func (p *Peering) ServeInbound(inboundC chan *InboundConn) {
+	p.inboundC = inboundC
+}
+
+// ServeSessions makes the peering persist the sessions that the host keeps with the nodes
+func (p *Peering) ServeSessions(sessionC chan *models.Session) {
+	p.sessionC = sessionC
+}
+
+// ServeTxSamples makes the peering persist the tx gossip sampled over the sessions
+func (p *Peering) ServeTxSamples(txSamplesC chan *models.TxSamples) {
+	p.txSamplesC = txSamplesC
+}
+
 func (p *Peering) Run() error {
 	p.appWG.Add(1)
 	logrus.Info("running peering service")
@@ -65,6 +99,20 @@ func (p *Peering) Run() error {
 		p.dialersWG.Add(1)
 		go p.peeringWorker(workerID)
 	}
+	// persist the inbound connections
+	if p.inboundC != nil {
+		p.inboundWG.Add(1)
+		go p.runInbound()
+	}
+	// persist the finished sessions
+	if p.sessionC != nil {
+		p.sessionsWG.Add(1)
+		go p.runSessions()
+	}
+	if p.txSamplesC != nil {
+		p.sessionsWG.Add(1)
+		go p.runTxSamples()
+	}
 	// run orchester
 	p.orchersterWG.Add(1)
 	go p.runOrcherster()
@@ -80,14 +128,29 @@ func (p *Peering) Run() error {
 
 func (p *Peering) Close() {
 	// trigger the cascade closure starting by the orchester
-	p.orchersterDoneC <- struct{}{}
+	close(p.orchersterDoneC)
 	p.dialersWG.Wait()
+	close(p.inboundDoneC)
+	p.inboundWG.Wait()
+	// drop the open sessions, so that they are also recorded
+	p.host.CloseSessions()
+	close(p.sessionsDoneC)
+	p.sessionsWG.Wait()
 	close(p.dialC)
 	close(p.dialersDoneC)
-	close(p.orchersterDoneC)
 	p.appWG.Done() // notify that the dialler has finished
 }
 
+// ThrottledDials returns the number of dials that each of the dial limits postponed
+func (p *Peering) ThrottledDials() map[string]uint64 {
+	throttled := p.limiter.Throttled()
+	summary := make(map[string]uint64, len(throttled))
+	for level, cnt := range throttled {
+		summary[level.String()] = cnt
+	}
+	return summary
+}
+
 func (p *Peering) runOrcherster() {
 	logEntry := logrus.WithField("ocherster", 1)
 	logEntry.Info("spawning peering dialer orcherster")
@@ -95,56 +158,64 @@ func (p *Peering) runOrcherster() {
 		logEntry.Info("closing peering dial orcherster")
 		p.orchersterWG.Done()
 	}()
-	dialedCache := make(map[enode.ID]struct{})
+	p.updateNodes()
+	p.orchersterWG.Add(1)
+	go p.refreshNodes()
 
-	startT := time.NewTicker(InitDelay)
-	// update the nodes from the db
-	updateNodes := func() {
-		newNodeSet, err := p.db.GetNonDeprecatedNodes(p.host.localChainStatus.NetworkID)
-		if err != nil {
-			logEntry.Panic(err.Error())
-			logEntry.Panic("unable to update local set of nodes from DB")
+	for {
+		// wait until the next node is due
+		hInfo, ok := p.dialQueue.Next(p.ctx, p.orchersterDoneC)
+		if !ok {
+			return
+		}
+		if !p.limiter.Acquire(hInfo, time.Now()) {
+			p.dialQueue.Postpone(hInfo.ID, time.Now().Add(LimitedDialDelay))
+			continue
+		}
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-p.orchersterDoneC:
+			return
+		case p.dialC <- hInfo:
 		}
-		p.nodeSet.UpdateListFromSet(newNodeSet)
 	}
-	updateNodes()
+}
+
+// refreshNodes periodically adds the new nodes from the db to the dial queue
+func (p *Peering) refreshNodes() {
+	defer p.orchersterWG.Done()
+	refreshT := time.NewTicker(NodesRefreshInterval)
+	defer refreshT.Stop()
 	for {
-		// give prior to shut down notifications
 		select {
 		case <-p.ctx.Done():
 			return
 		case <-p.orchersterDoneC:
 			return
-		default:
-			if p.nodeSet.IsThereNext() {
-				nextNode := p.nodeSet.NextNode()
-				_, ok := dialedCache[nextNode.hostInfo.ID]
-				if ok {
-					continue
-				}
-				isTimeToDial := nextNode.nextDialTime.Before(time.Now())
-				if isTimeToDial {
-					p.dialC <- nextNode.hostInfo
-				}
-				dialedCache[nextNode.hostInfo.ID] = struct{}{}
-			} else {
-				// still check the contexts in case we have to interrupt
-				select {
-				case <-p.ctx.Done():
-					return
-				case <-p.orchersterDoneC:
-					return
-				case <-startT.C:
-				}
-				// update the nodeSet
-				updateNodes()
-				dialedCache = make(map[enode.ID]struct{})
-				startT.Reset(InitDelay)
-			}
+		case <-refreshT.C:
+			p.updateNodes()
 		}
 	}
 }
 
+// updateNodes adds the nodes from the db to the queue, and the ASNs of their ips to the
+// limiter
+func (p *Peering) updateNodes() {
+	newNodeSet, err := p.db.GetNonDeprecatedNodes(p.host.network.NetworkID)
+	if err != nil {
+		logrus.Panic(errors.Wrap(err, "unable to update local set of nodes from DB"))
+	}
+	p.dialQueue.UpdateListFromSet(newNodeSet)
+	asns, err := p.db.GetIPASNs()
+	if err != nil {
+		logrus.Warn(err.Error())
+	} else {
+		p.limiter.UpdateASNs(asns)
+	}
+	p.limiter.Prune(time.Now())
+}
+
 func (p *Peering) peeringWorker(workerID int) {
 	logEntry := logrus.WithField("dialer-id", workerID)
 	logEntry.Debug("spawning peering dialer")
@@ -160,16 +231,22 @@ func (p *Peering) peeringWorker(workerID int) {
 			return
 		case node := <-p.dialC:
 			p.Connect(node)
+			p.limiter.Release(node.ID)
 		}
 	}
 }
 
 // Connect applies the logic of connecting the remote node and persist the necessary results from the attempt
 func (p *Peering) Connect(hInfo models.HostInfo) {
+	// there is no need to dial the nodes we keep a session with, we know they are online
+	if p.host.InSession(hInfo.ID) {
+		p.dialQueue.AddPositiveDial(hInfo.ID, time.Now())
+		return
+	}
 	// try to connect to the peer
 	connAttempt, nodeInfo, sameNetwork := p.connect(hInfo)
 	// handle the result (check if it's deprecable) and update local perception
-	p.nodeSet.UpdateNodeFromConnAttempt(hInfo.ID, &connAttempt, sameNetwork, p.deprecationTime)
+	p.dialQueue.UpdateNodeFromConnAttempt(hInfo.ID, &connAttempt, sameNetwork, p.deprecationTime)
 	// persist the node with all the necessary info
 	p.db.PersistNodeInfo(connAttempt, nodeInfo, sameNetwork)
 	// If the current node's IP is public, locate IP info and persist if valid
@@ -184,8 +261,10 @@ func (p *Peering) connect(hInfo models.HostInfo) (models.ConnectionAttempt, mode
 	nInfo, _ := models.NewNodeInfo(nodeID, models.WithHostInfo(hInfo))
 
 	t := time.Now()
-	handshakeDetails, chainDetails, err := p.host.Connect(&hInfo)
+	handshakeDetails, chainDetails, timings, err := p.host.Connect(&hInfo)
 	RTT := time.Since(t)
+	connAttempt.Timings = timings
+	observeConnTimings(timings)
 
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
@@ -210,266 +289,97 @@ func (p *Peering) connect(hInfo models.HostInfo) (models.ConnectionAttempt, mode
 		connAttempt.Latency = RTT
 		nInfo.HandshakeDetails = handshakeDetails
 		nInfo.ChainDetails = chainDetails
+		nInfo.Protocols = p.host.MatchProtocols(handshakeDetails.Capabilities)
 	}
-	return connAttempt, *nInfo, (chainDetails.NetworkID == p.host.localChainStatus.NetworkID)
-}
-
-func (p *Peering) requestIPInfo(nodeID string, IP string) {
-	if models.IsIPPublic(net.ParseIP(IP)) {
-		// get location from the received peer
-		p.IPLocator.LocateIP(IP)
-	} else {
-		logrus.Warnf("new peer %s had a non-public IP %s", nodeID, IP)
-	}
-}
-
-// --- Ordered Set ---
-
-// List of Nodes ordered by time for next connection
-type NodeOrderedSet struct {
-	m        sync.RWMutex
-	nodePtr  int
-	nodeList []*QueuedNode
-	nodeMap  map[string]*QueuedNode
-}
-
-func NewNodeOrderedSet() *NodeOrderedSet {
-	return &NodeOrderedSet{
-		nodePtr:  0,
-		nodeList: make([]*QueuedNode, 0),
-		nodeMap:  make(map[string]*QueuedNode),
-	}
+	return connAttempt, *nInfo, (chainDetails.NetworkID == p.host.network.NetworkID)
 }
 
-func (s *NodeOrderedSet) UpdateListFromSet(nSet []models.HostInfo) {
-	// try to add the missing nodes from the DB into the NodeSet
-	newNodes := 0
-	for _, newNode := range nSet {
-		exists := s.IsPeerAlready(newNode.ID)
-		if exists {
-			continue
+func (p *Peering) runInbound() {
+	defer p.inboundWG.Done()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-p.inboundDoneC:
+			return
+		case inbound := <-p.inboundC:
+			p.ConnectInbound(inbound)
 		}
-		newNodes++
-		s.AddNode(newNode)
 	}
-	s.OrderSet()
-	s.resetPointer()
-	logrus.WithFields(logrus.Fields{
-		"total-nodes-from-db": len(nSet),
-		"new-nodes-from-db":   newNodes,
-		"total-nodes-in-set":  s.Len(),
-	}).Info("updating node-set from db-non-deprecated-set")
-}
-
-func (s *NodeOrderedSet) IsPeerAlready(nodeID enode.ID) bool {
-	// IsPeerAlready checks whether a peer is already in the Queue.
-	s.m.RLock()
-	defer s.m.RUnlock()
-	_, ok := s.nodeMap[nodeID.String()]
-	return ok
-}
-
-func (s *NodeOrderedSet) AddNode(hInfo models.HostInfo) {
-	logrus.WithField("nodeID", hInfo.ID.String()).Trace("adding node to node-set")
-	qNode := NewQueuedNode(hInfo)
-	s.m.Lock()
-	defer s.m.Unlock()
-	s.nodeMap[hInfo.ID.String()] = qNode
-	s.nodeList = append([]*QueuedNode{qNode}, s.nodeList[:]...) // add it at the beginning of the queue
 }
 
-func (s *NodeOrderedSet) RemoveNode(nodeID enode.ID) {
-	logrus.WithField("nodeID", nodeID.String()).Trace("removing node from node-set")
-	exists := s.IsPeerAlready(nodeID)
-	if !exists {
-		logrus.Warn("trying to remove a peer that was no present in the node list")
-		return
-	}
-	s.m.Lock()
-	defer s.m.Unlock()
-	// from the map
-	delete(s.nodeMap, nodeID.String())
-	// from the list
-	idx := -1
-	for i, val := range s.nodeList {
-		if val.hostInfo.ID == nodeID {
-			idx = i
-			break
+func (p *Peering) runSessions() {
+	defer p.sessionsWG.Done()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-p.sessionsDoneC:
+			return
+		case session := <-p.sessionC:
+			p.db.PersistSession(session)
 		}
 	}
-	// double-check the index
-	if idx > -1 {
-		s.nodeList = append(s.nodeList[:idx], s.nodeList[idx+1:]...)
-	} else {
-		logrus.Warn("couldn't find the index of the Node to remove from the NodeList")
-	}
 }
 
-func (s *NodeOrderedSet) UpdateNodeFromConnAttempt(
-	nodeID enode.ID, connAttempt *models.ConnectionAttempt, sameNetwork bool,
-	deprecationTime time.Duration,
-	) {
-	logEntry := logrus.WithFields(logrus.Fields{
-		"nodeID":  nodeID.String(),
-		"attempt": connAttempt.Status.String(),
-		"error":   connAttempt.Error,
-	})
-	logEntry.Trace("tracking connection-attempt for node")
-	node, exists := s.GetNode(nodeID)
-	if !exists {
-		logEntry.Warn("connection attempt to a node that is untracked")
-	}
-	// check the state of the conn attempt
-	switch connAttempt.Status {
-	case models.SuccessfulConnection:
-		// directly remove the peer if th
-		if !sameNetwork { // directly prune the node from the list & deprecate it
-			connAttempt.Deprecable = true
-			s.RemoveNode(nodeID)
+func (p *Peering) runTxSamples() {
+	defer p.sessionsWG.Done()
+	for {
+		select {
+		case <-p.ctx.Done():
 			return
+		case <-p.sessionsDoneC:
+			return
+		case samples := <-p.txSamplesC:
+			p.db.PersistTxSamples(samples)
 		}
-		// if possitive, all god
-		node.AddPositiveDial(connAttempt.Timestamp)
-		connAttempt.Deprecable = false
-
-	case models.FailedConnection:
-		// if negative, check if it's deprecable
-		connAttempt.Deprecable = node.IsDeprecable()
-		if connAttempt.Deprecable {
-			s.RemoveNode(nodeID)
-		} else {
-			node.AddNegativeDial(connAttempt.Timestamp, deprecationTime, ParseStateFromError(connAttempt.Error))
-		}
-
-	default:
-		logrus.WithFields(logrus.Fields{
-			"nodeID":  nodeID.String(),
-			"attempt": connAttempt.Status.String(),
-			"error":   connAttempt.Error,
-		}).Warn("unrecognized connection-attempt status for node")
-		logrus.Panic("we should have never reached here", connAttempt)
-	}
-}
-
-// GetNode retrieves the info of the requested node
-func (s *NodeOrderedSet) GetNode(nodeID enode.ID) (*QueuedNode, bool) {
-	s.m.Lock()
-	defer s.m.Unlock()
-	p, ok := s.nodeMap[nodeID.String()]
-	if !ok {
-		return &QueuedNode{}, ok
-	}
-	return p, ok
-}
-
-// IsThereNext returns a boolean indicating whether there is a new item ready to be readed
-func (s *NodeOrderedSet) IsThereNext() bool {
-	// did we hit the max number of peers to dial?
-	if s.nodePtr >= s.Len() {
-		return false
-	}
-	if s.IsEmpty() {
-		return false
 	}
-	return s.nodeList[s.nodePtr].ReadyToDial()
-}
-
-func (s *NodeOrderedSet) NextNode() QueuedNode {
-	s.m.Lock()
-	defer s.m.Unlock()
-	if s.nodePtr > s.Len() || s.nodePtr < 0 {
-		return QueuedNode{}
-	}
-	node := s.nodeList[s.nodePtr]
-	s.nodePtr++ // this will cause s.IsThereNext() to be negative, handled by the upper layer
-	return *node
-}
-
-func (s *NodeOrderedSet) resetPointer() {
-	logrus.Trace("resetting pointer at NodeSet")
-	s.m.Lock()
-	defer s.m.Unlock()
-	s.nodePtr = 0
-}
-
-func (s *NodeOrderedSet) IsEmpty() bool {
-	return s.Len() == 0
-}
-
-func (s *NodeOrderedSet) Len() int {
-	return len(s.nodeList)
-}
-
-// ---  SORTING METHODS FOR PeerQueue ----
-// OrderSet sorts the items based on their next connection time
-func (s *NodeOrderedSet) OrderSet() {
-	logrus.Tracef("ordering NodeSet with %d nodes", s.Len())
-	s.m.Lock()
-	defer s.m.Unlock()
-	sort.Sort(s)
-}
-
-// Swap is part of sort.Interface.
-func (s *NodeOrderedSet) Swap(i, j int) {
-	s.nodeList[i], s.nodeList[j] = s.nodeList[j], s.nodeList[i]
-}
-
-// Less is part of sort.Interface. We use c.PeerList.NextConnection as the value to sort by.
-func (s *NodeOrderedSet) Less(i, j int) bool {
-	return s.nodeList[i].NextDialTime().Before(s.nodeList[j].NextDialTime())
-}
-
-// --- QueuedNode ---
-
-// Main structure of a node that is queued to be dialed
-type QueuedNode struct {
-	state           DialState
-	hostInfo        models.HostInfo
-	nextDialTime    time.Time
-	deprecationTime time.Time
 }
 
-func NewQueuedNode(hInfo models.HostInfo) *QueuedNode {
-	return &QueuedNode{
-		state:           ZeroState,
-		hostInfo:        hInfo,
-		nextDialTime:    time.Time{},
-		deprecationTime: time.Time{},
+// ConnectInbound persists the identification of a node that dialed us
+func (p *Peering) ConnectInbound(inbound *InboundConn) {
+	// failed inbound connections aren't recorded, as the node might not even be identified
+	if inbound.Err != nil {
+		logrus.WithFields(logrus.Fields{
+			"ip":    inbound.HostInfo.IP,
+			"error": inbound.Err.Error(),
+		}).Debug("failed inbound connection")
+		return
 	}
+	nodeID := inbound.HostInfo.ID
+	connAttempt := models.NewConnectionAttempt(nodeID)
+	connAttempt.Inbound = true
+	logrus.WithFields(logrus.Fields{
+		"node-id":          nodeID.String(),
+		"ip":               inbound.HostInfo.IP,
+		"client":           inbound.HandshakeDetails.ClientName,
+		"capabilities":     inbound.HandshakeDetails.Capabilities,
+		"network":          inbound.ChainDetails.NetworkID,
+		"fork-id":          inbound.ChainDetails.ForkID.Hash,
+		"protocol-version": inbound.ChainDetails.ProtocolVersion,
+	}).Info("successfull inbound connection")
+	connAttempt.Error = ErrorNone
+	connAttempt.Status = models.SuccessfulConnection
+	connAttempt.Latency = inbound.Duration
+	connAttempt.Timings = inbound.Timings
+	observeConnTimings(inbound.Timings)
+	nInfo, _ := models.NewNodeInfo(
+		nodeID,
+		models.WithHostInfo(inbound.HostInfo),
+		models.WithHandShakeDetails(inbound.HandshakeDetails),
+		models.WithChainDetails(inbound.ChainDetails),
+		models.WithProtocols(p.host.MatchProtocols(inbound.HandshakeDetails.Capabilities)),
+	)
+	sameNetwork := inbound.ChainDetails.NetworkID == p.host.network.NetworkID
+	p.db.PersistInboundNodeInfo(connAttempt, *nInfo, sameNetwork)
+	p.requestIPInfo(nodeID.String(), inbound.HostInfo.IP)
 }
 
-func (n *QueuedNode) ReadyToDial() bool {
-	return n.nextDialTime.Before(time.Now()) // TODO: should I check if the QueuedNode is empty?
-}
-
-func (n *QueuedNode) updateNextDialTime(t time.Time) {
-	n.nextDialTime = t
-}
-
-func (n *QueuedNode) NextDialTime() time.Time {
-	return n.nextDialTime
-}
-
-func (n *QueuedNode) IsDeprecable() bool {
-	return !n.IsEmpty() && !n.deprecationTime.IsZero() && n.deprecationTime.Before(time.Now())
-}
-
-func (n *QueuedNode) IsEmpty() bool {
-	return n.hostInfo == models.HostInfo{}
-}
-
-func (n *QueuedNode) AddPositiveDial(baseT time.Time) {
-	logrus.Trace("adding possitive dial attempt to node", n.hostInfo.ID.String())
-	n.state = PossitiveState
-	n.nextDialTime = baseT.Add(n.state.DelayFromState())
-	n.deprecationTime = time.Time{}
-}
-
-func (n *QueuedNode) AddNegativeDial(baseT time.Time, deprecationTime time.Duration, state DialState) {
-	logrus.Trace("adding negative dial attempt to node", n.hostInfo.ID.String())
-	n.state = state
-	n.nextDialTime = baseT.Add(state.DelayFromState())
-	if n.deprecationTime.IsZero() {
-		n.deprecationTime = baseT.Add(deprecationTime)
+func (p *Peering) requestIPInfo(nodeID string, IP string) {
+	if models.IsIPPublic(net.ParseIP(IP)) {
+		// get location from the received peer
+		p.IPLocator.LocateIP(IP)
+	} else {
+		logrus.Warnf("new peer %s had a non-public IP %s", nodeID, IP)
 	}
 }
@@ -0,0 +1,279 @@
+package crawler
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/devp2p/tooling/ethtest"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cortze/ragno/models"
+)
+
+const (
+	// same ping interval as geth, which drops the peers that don't read for 30 secs
+	SessionPingInterval = 15 * time.Second
+	SessionReadTimeout  = 2 * SessionPingInterval
+	SessionWriteTimeout = 5 * time.Second
+)
+
+// message codes of the base protocol and of the eth requests that we answer (with the
+// offset of the base protocol)
+const (
+	disconnectMsg = 0x01
+	pingMsg       = 0x02
+	pongMsg       = 0x03
+
+	getBlockHeadersMsg       = 0x13
+	blockHeadersMsg          = 0x14
+	getBlockBodiesMsg        = 0x15
+	blockBodiesMsg           = 0x16
+	getPooledTransactionsMsg = 0x19
+	pooledTransactionsMsg    = 0x1a
+	getReceiptsMsg           = 0x1f
+	receiptsMsg              = 0x20
+)
+
+// responses to the eth requests, answered empty as we don't keep any chain data
+var emptyResponses = map[uint64]uint64{
+	getBlockHeadersMsg:       blockHeadersMsg,
+	getBlockBodiesMsg:        blockBodiesMsg,
+	getPooledTransactionsMsg: pooledTransactionsMsg,
+	getReceiptsMsg:           receiptsMsg,
+}
+
+type session struct {
+	conn  *ethtest.Conn
+	info  *models.Session
+	doneC chan struct{}
+	// rlpx connections allow a reader and a writer, but not concurrent writers
+	writeC chan func() error
+	// records the tx gossip (if sampling)
+	sampler *TxSampler
+}
+
+// keepSession takes over the connection with the node if there is room for one more
+// session, returning false if the connection has to be closed
+func (h *Host) keepSession(conn *ethtest.Conn, nodeID enode.ID, chainDetails models.ChainDetails) bool {
+	if h.maxSessions <= 0 {
+		return false
+	}
+	if chainDetails.NetworkID != h.network.NetworkID || chainDetails.ForkClass == models.ForkIncompatible {
+		return false
+	}
+	h.sessionsM.Lock()
+	defer h.sessionsM.Unlock()
+	if h.closingSessions || len(h.sessions) >= h.maxSessions {
+		return false
+	}
+	if _, ok := h.sessions[nodeID]; ok {
+		return false
+	}
+	// the status exchange left a deadline in the connection
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return false
+	}
+	s := &session{
+		conn:    conn,
+		info:    models.NewSession(nodeID),
+		doneC:   make(chan struct{}),
+		writeC:  make(chan func() error),
+		sampler: h.txSampler,
+	}
+	h.sessions[nodeID] = s
+	h.sessionsWG.Add(1)
+	go h.runSession(s)
+	return true
+}
+
+// InSession returns whether we are keeping a connection open with the node
+func (h *Host) InSession(nodeID enode.ID) bool {
+	h.sessionsM.RLock()
+	defer h.sessionsM.RUnlock()
+	_, ok := h.sessions[nodeID]
+	return ok
+}
+
+// Sessions returns the channel where the finished sessions are notified
+func (h *Host) Sessions() chan *models.Session {
+	return h.sessionC
+}
+
+// TxSamples starts the tx sampler, returning the channel where the sampled gossip is
+// notified (nil if the host doesn't sample)
+func (h *Host) TxSamples() chan *models.TxSamples {
+	if h.txSampler == nil {
+		return nil
+	}
+	return h.txSampler.Run()
+}
+
+// CloseSessions drops all the open sessions and waits until they are notified
+func (h *Host) CloseSessions() {
+	h.sessionsM.Lock()
+	h.closingSessions = true
+	for _, s := range h.sessions {
+		close(s.doneC)
+	}
+	h.sessionsM.Unlock()
+	h.sessionsWG.Wait()
+	if h.txSampler != nil {
+		h.txSampler.Close()
+	}
+}
+
+func (h *Host) runSession(s *session) {
+	logEntry := logrus.WithField("node-id", s.info.ID.String())
+	logEntry.Debug("keeping session with node")
+	defer func() {
+		h.sessionsM.Lock()
+		delete(h.sessions, s.info.ID)
+		h.sessionsM.Unlock()
+		logEntry.WithFields(logrus.Fields{
+			"duration": s.info.Duration().String(),
+			"reason":   s.info.DisconnectReason,
+			"local":    s.info.LocalDisconnect,
+		}).Debug("session with node finished")
+		select {
+		case h.sessionC <- s.info:
+		case <-h.ctx.Done():
+		}
+		h.sessionsWG.Done()
+	}()
+
+	// the writer keeps pinging the node and sends the replies of the reader
+	readerDoneC := make(chan struct{})
+	writerDoneC := make(chan struct{})
+	go func() {
+		defer close(writerDoneC)
+		pingT := time.NewTicker(SessionPingInterval)
+		defer pingT.Stop()
+		for {
+			select {
+			case <-readerDoneC:
+				return
+			case <-s.doneC:
+				s.write(disconnectMsg, []p2p.DiscReason{p2p.DiscQuitting})
+				s.conn.Close()
+				return
+			case <-h.ctx.Done():
+				s.write(disconnectMsg, []p2p.DiscReason{p2p.DiscQuitting})
+				s.conn.Close()
+				return
+			case <-pingT.C:
+				if err := s.write(pingMsg, []interface{}{}); err != nil {
+					s.conn.Close()
+					return
+				}
+			case writeFn := <-s.writeC:
+				if err := writeFn(); err != nil {
+					s.conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	reason, err := s.readLoop(writerDoneC)
+	close(readerDoneC)
+	s.info.End = time.Now()
+	select {
+	case <-s.doneC:
+		s.info.DisconnectReason = p2p.DiscQuitting.String()
+		s.info.LocalDisconnect = true
+	case <-h.ctx.Done():
+		s.info.DisconnectReason = p2p.DiscQuitting.String()
+		s.info.LocalDisconnect = true
+	default:
+		if err != nil {
+			s.info.DisconnectReason = ParseConnError(err)
+		} else {
+			s.info.DisconnectReason = reason.String()
+		}
+		s.conn.Close()
+	}
+	<-writerDoneC
+}
+
+// readLoop reads from the node until it disconnects, answering its pings and requests.
+// It returns the reason the node gave, or the error that closed the connection
+func (s *session) readLoop(writerDoneC chan struct{}) (p2p.DiscReason, error) {
+	for {
+		err := s.conn.SetReadDeadline(time.Now().Add(SessionReadTimeout))
+		if err != nil {
+			return 0, err
+		}
+		code, data, _, err := s.conn.Conn.Read()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case code == disconnectMsg:
+			return decodeDiscReason(data), nil
+		case code == pingMsg:
+			s.reply(writerDoneC, pongMsg, []interface{}{})
+		case code == pongMsg:
+		case code == transactionsMsg || code == newPooledTransactionHashesMsg:
+			if s.sampler == nil {
+				continue
+			}
+			if err := s.sampler.handleMsg(s.info.ID, code, data); err != nil {
+				logrus.WithField("node-id", s.info.ID.String()).Trace(err.Error())
+			}
+		default:
+			respCode, ok := emptyResponses[code]
+			if !ok {
+				// we aren't interested in announcements
+				continue
+			}
+			var req struct {
+				RequestId uint64
+				Rest      []rlp.RawValue `rlp:"tail"`
+			}
+			if err := rlp.DecodeBytes(data, &req); err != nil {
+				return 0, errors.Wrap(err, "unable to decode eth request")
+			}
+			s.reply(writerDoneC, respCode, []interface{}{req.RequestId, []interface{}{}})
+		}
+	}
+}
+
+// reply hands the message to the writer, unless it is already gone
+func (s *session) reply(writerDoneC chan struct{}, code uint64, msg interface{}) {
+	select {
+	case s.writeC <- func() error { return s.write(code, msg) }:
+	case <-writerDoneC:
+	}
+}
+
+func (s *session) write(code uint64, msg interface{}) error {
+	payload, err := rlp.EncodeToBytes(msg)
+	if err != nil {
+		return err
+	}
+	err = s.conn.SetWriteDeadline(time.Now().Add(SessionWriteTimeout))
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.Conn.Write(code, payload)
+	return err
+}
+
+// decodeDiscReason supports both the list and the plain encodings of the disconnect reason
+func decodeDiscReason(data []byte) p2p.DiscReason {
+	var reasons []p2p.DiscReason
+	if err := rlp.DecodeBytes(data, &reasons); err == nil {
+		if len(reasons) == 0 {
+			return p2p.DiscRequested
+		}
+		return reasons[0]
+	}
+	var reason p2p.DiscReason
+	if err := rlp.DecodeBytes(data, &reason); err == nil {
+		return reason
+	}
+	return p2p.DiscRequested
+}
@@ -0,0 +1,216 @@
+package crawler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cortze/ragno/models"
+)
+
+var (
+	// how often the sampled gossip is notified
+	TxSamplingInterval = 1 * time.Minute
+	// how long we remember the hashes we already saw
+	TxSeenExpiry = 1 * time.Hour
+)
+
+// message codes of the tx gossip (with the offset of the base protocol)
+const (
+	transactionsMsg               = 0x12
+	newPooledTransactionHashesMsg = 0x18
+)
+
+// txAnnouncement68 is the eth/68 NewPooledTransactionHashes, which includes the types and
+// sizes of the announced transactions
+type txAnnouncement68 struct {
+	Types  []byte
+	Sizes  []uint32
+	Hashes []common.Hash
+}
+
+// TxSampler records the transactions that the nodes we keep a session with gossip to us
+type TxSampler struct {
+	ctx context.Context
+
+	m sync.Mutex
+	// the seen hashes are kept in two generations, so that they expire in bulk
+	seen         map[common.Hash]struct{}
+	prevSeen     map[common.Hash]struct{}
+	lastRotation time.Time
+	windowStart  time.Time
+	sightings    []*models.TxSighting
+	stats        map[enode.ID]*models.PeerTxStats
+
+	samplesC chan *models.TxSamples
+	doneC    chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewTxSampler(ctx context.Context) *TxSampler {
+	return &TxSampler{
+		ctx:          ctx,
+		seen:         make(map[common.Hash]struct{}),
+		prevSeen:     make(map[common.Hash]struct{}),
+		lastRotation: time.Now(),
+		windowStart:  time.Now(),
+		sightings:    make([]*models.TxSighting, 0),
+		stats:        make(map[enode.ID]*models.PeerTxStats),
+		samplesC:     make(chan *models.TxSamples),
+		doneC:        make(chan struct{}),
+	}
+}
+
+// Run notifies the sampled gossip at every interval
+func (s *TxSampler) Run() chan *models.TxSamples {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(TxSamplingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-s.doneC:
+				// notify whatever was sampled since the last interval
+				s.notify()
+				return
+			case <-ticker.C:
+				s.notify()
+			}
+		}
+	}()
+	return s.samplesC
+}
+
+func (s *TxSampler) notify() {
+	samples := s.flush(time.Now())
+	if len(samples.Sightings) == 0 && len(samples.Stats) == 0 {
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"new-txs": len(samples.Sightings),
+		"peers":   len(samples.Stats),
+	}).Debug("notifying sampled tx gossip")
+	select {
+	case s.samplesC <- samples:
+	case <-s.ctx.Done():
+	}
+}
+
+// flush returns the gossip sampled in the current window and starts a new one
+func (s *TxSampler) flush(now time.Time) *models.TxSamples {
+	s.m.Lock()
+	defer s.m.Unlock()
+	samples := &models.TxSamples{
+		Sightings: s.sightings,
+		Stats:     make([]*models.PeerTxStats, 0, len(s.stats)),
+	}
+	for _, stats := range s.stats {
+		stats.WindowEnd = now
+		samples.Stats = append(samples.Stats, stats)
+	}
+	s.sightings = make([]*models.TxSighting, 0)
+	s.stats = make(map[enode.ID]*models.PeerTxStats)
+	s.windowStart = now
+	if now.Sub(s.lastRotation) >= TxSeenExpiry {
+		s.prevSeen = s.seen
+		s.seen = make(map[common.Hash]struct{})
+		s.lastRotation = now
+	}
+	return samples
+}
+
+// handleMsg records the gossip messages, ignoring the rest of them
+func (s *TxSampler) handleMsg(nodeID enode.ID, code uint64, data []byte) error {
+	now := time.Now()
+	sightings := make([]*models.TxSighting, 0)
+	switch code {
+	case newPooledTransactionHashesMsg:
+		var ann68 txAnnouncement68
+		err := rlp.DecodeBytes(data, &ann68)
+		if err == nil && len(ann68.Types) == len(ann68.Hashes) && len(ann68.Sizes) == len(ann68.Hashes) {
+			for idx, hash := range ann68.Hashes {
+				sightings = append(sightings, &models.TxSighting{
+					Hash:    hash,
+					HasMeta: true,
+					Type:    ann68.Types[idx],
+					Size:    ann68.Sizes[idx],
+				})
+			}
+			break
+		}
+		// eth/66 and eth/67 only announce the hashes
+		var hashes []common.Hash
+		if err := rlp.DecodeBytes(data, &hashes); err != nil {
+			return errors.Wrap(err, "unable to decode tx announcement")
+		}
+		for _, hash := range hashes {
+			sightings = append(sightings, &models.TxSighting{Hash: hash})
+		}
+	case transactionsMsg:
+		var txs []*types.Transaction
+		if err := rlp.DecodeBytes(data, &txs); err != nil {
+			return errors.Wrap(err, "unable to decode transactions")
+		}
+		for _, tx := range txs {
+			sightings = append(sightings, &models.TxSighting{
+				Hash:    tx.Hash(),
+				HasMeta: true,
+				Type:    tx.Type(),
+				Size:    uint32(tx.Size()),
+				Full:    true,
+			})
+		}
+	default:
+		return nil
+	}
+	s.record(nodeID, code, sightings, now)
+	return nil
+}
+
+func (s *TxSampler) record(nodeID enode.ID, code uint64, sightings []*models.TxSighting, now time.Time) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	stats, ok := s.stats[nodeID]
+	if !ok {
+		stats = &models.PeerTxStats{
+			ID:          nodeID,
+			WindowStart: s.windowStart,
+		}
+		s.stats[nodeID] = stats
+	}
+	stats.Messages++
+	if code == transactionsMsg {
+		stats.Transactions += len(sightings)
+	} else {
+		stats.Hashes += len(sightings)
+	}
+	for _, sighting := range sightings {
+		if _, ok := s.seen[sighting.Hash]; ok {
+			continue
+		}
+		if _, ok := s.prevSeen[sighting.Hash]; ok {
+			continue
+		}
+		s.seen[sighting.Hash] = struct{}{}
+		sighting.ID = nodeID
+		sighting.FirstSeen = now
+		s.sightings = append(s.sightings, sighting)
+		stats.FirstSeen++
+	}
+}
+
+// Close stops the sampler, notifying the last window
+func (s *TxSampler) Close() {
+	close(s.doneC)
+	s.wg.Wait()
+}
@@ -0,0 +1,74 @@
+package crawler
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxSamplerHandleMsg(t *testing.T) {
+	peerA := enode.ID{0x0a}
+	peerB := enode.ID{0x0b}
+	hash1 := common.HexToHash("0x01")
+	hash2 := common.HexToHash("0x02")
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID: big.NewInt(1),
+		Nonce:   1,
+		Gas:     21000,
+	})
+
+	ann68, err := rlp.EncodeToBytes(&txAnnouncement68{
+		Types:  []byte{types.DynamicFeeTxType, types.AccessListTxType},
+		Sizes:  []uint32{120, 131000},
+		Hashes: []common.Hash{hash1, hash2},
+	})
+	require.NoError(t, err)
+	ann66, err := rlp.EncodeToBytes([]common.Hash{hash1})
+	require.NoError(t, err)
+	txs, err := rlp.EncodeToBytes([]*types.Transaction{tx})
+	require.NoError(t, err)
+
+	sampler := NewTxSampler(context.Background())
+	require.NoError(t, sampler.handleMsg(peerA, newPooledTransactionHashesMsg, ann68))
+	require.NoError(t, sampler.handleMsg(peerB, newPooledTransactionHashesMsg, ann66))
+	require.NoError(t, sampler.handleMsg(peerB, transactionsMsg, txs))
+	require.Error(t, sampler.handleMsg(peerB, transactionsMsg, []byte{0x01}))
+
+	samples := sampler.flush(time.Now())
+	require.Len(t, samples.Sightings, 3)
+	require.Equal(t, hash1, samples.Sightings[0].Hash)
+	require.Equal(t, peerA, samples.Sightings[0].ID)
+	require.True(t, samples.Sightings[0].HasMeta)
+	require.Equal(t, uint8(types.DynamicFeeTxType), samples.Sightings[0].Type)
+	require.Equal(t, uint32(131000), samples.Sightings[1].Size)
+	require.Equal(t, tx.Hash(), samples.Sightings[2].Hash)
+	require.True(t, samples.Sightings[2].Full)
+
+	require.Len(t, samples.Stats, 2)
+	for _, stats := range samples.Stats {
+		switch stats.ID {
+		case peerA:
+			require.Equal(t, 1, stats.Messages)
+			require.Equal(t, 2, stats.Hashes)
+			require.Equal(t, 2, stats.FirstSeen)
+		case peerB:
+			require.Equal(t, 2, stats.Messages)
+			require.Equal(t, 1, stats.Hashes)
+			require.Equal(t, 1, stats.Transactions)
+			require.Equal(t, 1, stats.FirstSeen)
+		}
+	}
+
+	// the hashes are only reported the first time they are seen
+	require.NoError(t, sampler.handleMsg(peerB, newPooledTransactionHashesMsg, ann68))
+	samples = sampler.flush(time.Now())
+	require.Len(t, samples.Sightings, 0)
+	require.Equal(t, 0, samples.Stats[0].FirstSeen)
+}
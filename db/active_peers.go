@@ -19,11 +19,12 @@ func (DB *PostgresDBService) getActivePeers() ([]int, error) {
 		FROM node_info
 		WHERE deprecated = 'false' AND
 		first_connected IS NOT NULL AND
-		network_id = 1 AND
+		network_id = $2 AND
 		client_name IS NOT NULL AND
 		last_connected > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
 		`,
 		LastActivityValidRange,
+		DB.networkID,
 	)
 	if err != nil {
 		return activePeers, errors.Wrap(err, "unable to retrieve active peer's ids")
@@ -41,7 +42,7 @@ func (DB *PostgresDBService) getActivePeers() ([]int, error) {
 	return activePeers, nil
 }
 
-func (DB *PostgresDBService) insertActivePeers(activePeers []int) (query string, args []interface{}) {
+func insertActivePeers(activePeers []int) (query string, args []interface{}) {
 	query = `
 		INSERT INTO active_peers(
 			timestamp,
@@ -67,7 +68,7 @@ func (DB *PostgresDBService) PersistActivePeers() error {
 		return nil
 	}
 	pAttempt := NewPersistable()
-	pAttempt.query, pAttempt.values = DB.insertActivePeers(activePeers)
+	pAttempt.query, pAttempt.values = insertActivePeers(activePeers)
 	DB.writeChan <- pAttempt
 
 	return err
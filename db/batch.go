@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/jackc/pgconn"
 	pgx "github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/pkg/errors"
@@ -12,7 +13,10 @@ import (
 
 var (
 	QueryTimeout = 5 * time.Minute
-	MaxRetries   = 1
+	// MaxRetries is the number of times a batch is retried after a transient error,
+	// waiting RetryBackoff before the first retry and doubling it for the next ones
+	MaxRetries   = 3
+	RetryBackoff = 500 * time.Millisecond
 
 	ErrorNoConnFree        = "no connection adquirable"
 	noQueryError    string = "no error"
@@ -22,7 +26,6 @@ var (
 type QueryBatch struct {
 	ctx          context.Context
 	pgxPool      *pgxpool.Pool
-	batch        *pgx.Batch
 	size         int
 	persistables []Persistable
 }
@@ -31,95 +34,156 @@ func NewQueryBatch(ctx context.Context, pgxPool *pgxpool.Pool, batchSize int) *Q
 	return &QueryBatch{
 		ctx:          ctx,
 		pgxPool:      pgxPool,
-		batch:        &pgx.Batch{},
 		size:         batchSize,
 		persistables: make([]Persistable, 0),
 	}
 }
 
 func (q *QueryBatch) IsReadyToPersist() bool {
-	return q.batch.Len() >= q.size
+	return q.Len() >= q.size
 }
 
 func (q *QueryBatch) AddQuery(persis Persistable) {
-	q.batch.Queue(persis.query, persis.values...)
 	q.persistables = append(q.persistables, persis)
 }
 
 func (q *QueryBatch) Len() int {
-	return q.batch.Len()
+	return len(q.persistables)
 }
 
+// PersistBatch persists the queued queries, dropping only the ones that can't be
+// persisted (see persistIsolating)
 func (q *QueryBatch) PersistBatch() error {
-	logEntry := log.WithFields(log.Fields{
-		"mod": "batch-persister",
-	})
+	defer q.cleanBatch()
 	wlog.Debugf("persisting batch of queries with len(%d)", q.Len())
-	var err error
-persistRetryLoop:
-	for i := 0; i < MaxRetries; i++ {
-		t := time.Now()
-		err = q.persistBatch()
-		duration := time.Since(t)
-		switch err {
-		case nil:
-			logEntry.Tracef("persisted %d queries in %s", q.Len(), duration)
-			break persistRetryLoop
-		default:
-			logEntry.Tracef("attempt numb %d failed %s", i+1, err.Error())
+	return persistIsolating(q.ctx, q.persistables, q.persistBatch, isTransientPgError)
+}
+
+// persistBatch sends the persistables in a single pgx.Batch, which runs as a single
+// transaction: if any of the queries fails, none of them is persisted
+func (q *QueryBatch) persistBatch(persistables []Persistable) error {
+	ctx, cancel := context.WithTimeout(q.ctx, QueryTimeout)
+	defer cancel()
+
+	batch := &pgx.Batch{}
+	for _, persis := range persistables {
+		batch.Queue(persis.query, persis.values...)
+	}
+	batchResults := q.pgxPool.SendBatch(ctx, batch)
+	for range persistables {
+		if _, err := batchResults.Exec(); err != nil {
+			batchResults.Close()
+			return err
 		}
 	}
-	q.cleanBatch()
-	return errors.Wrap(err, "unable to persist batch query")
+	return batchResults.Close()
 }
 
-func (q *QueryBatch) persistBatch() error {
-	logEntry := log.WithFields(log.Fields{
-		"mod": "batch-persister",
-	})
+func (q *QueryBatch) cleanBatch() {
+	q.persistables = make([]Persistable, 0)
+}
+
+// isTransientPgError returns whether the error might not happen again when retrying the
+// batch: the connection and resource errors, and the transactions aborted by a
+// serialization failure or a deadlock. The rest of errors returned by the server (bad
+// values, constraints, syntax...) are permanent
+func isTransientPgError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code[:2] {
+		case "08", "40", "53", "57":
+			return true
+		}
+		return false
+	}
+	return pgconn.Timeout(err) || pgconn.SafeToRetry(err) || isConnError(err)
+}
+
+// isConnError returns whether the error comes from the connection to the database
+func isConnError(err error) bool {
+	var connErr interface{ Timeout() bool } // net.Error
+	return errors.As(err, &connErr) || errors.Is(err, context.DeadlineExceeded)
+}
 
-	if q.Len() == 0 {
-		logEntry.Trace("skipping batch-query, no queries to persist")
+// persistFn persists the given persistables atomically
+type persistFn func([]Persistable) error
+
+// persistIsolating persists the persistables, retrying with backoff while the errors
+// are transient. On a permanent error the batch is bisected, so that only the
+// persistables that fail by themselves are dropped, and written to the dead-letter
+// file with the error. If the retries run out, the whole batch is dead-lettered
+func persistIsolating(
+	ctx context.Context,
+	persistables []Persistable,
+	persist persistFn,
+	isTransient func(error) bool) error {
+
+	if len(persistables) == 0 {
 		return nil
 	}
+	dropped, err := bisectPersist(ctx, persistables, persist, isTransient)
+	if err != nil {
+		return errors.Wrapf(err, "unable to persist %d of %d queries", dropped, len(persistables))
+	}
+	return nil
+}
 
-	ctx, cancel := context.WithTimeout(q.ctx, QueryTimeout)
-	defer cancel()
+func bisectPersist(
+	ctx context.Context,
+	persistables []Persistable,
+	persist persistFn,
+	isTransient func(error) bool) (int, error) {
 
-	batchResults := q.pgxPool.SendBatch(ctx, q.batch)
-	defer batchResults.Close()
-
-	var qerr error
-	var rows pgx.Rows
-	nextQuery := true
-	cnt := 0
-	for nextQuery && qerr == nil {
-		rows, qerr = batchResults.Query()
-		nextQuery = rows.Next() // it closes all the rows if all the rows are readed
-		cnt++
+	err := persistWithRetries(ctx, persistables, persist, isTransient)
+	if err == nil {
+		return 0, nil
 	}
-	// check if there was any error
-	if qerr != nil {
-		return errors.Wrap(qerr, "error persisting batch")
+	if ctx.Err() != nil {
+		// shutting down, there is no point on isolating the error
+		return len(persistables), err
 	}
-	/*
-		// debug the queries (redice batch size and enable this)
-		for idx, item := range q.persistables {
-			fmt.Println("-> q:  ", idx)
-			fmt.Println("query: ", item.query)
-			fmt.Println("values:", item.values)
-		}
-		log.Panicf("panic on query")
-	*/
-	if ctx.Err() == context.DeadlineExceeded {
-		return errors.Wrap(ctx.Err(), "error persisting batch")
+	if isTransient(err) || len(persistables) == 1 {
+		writeDeadLetters(err, persistables)
+		return len(persistables), err
 	}
-	return nil
+	half := len(persistables) / 2
+	droppedFirst, errFirst := bisectPersist(ctx, persistables[:half], persist, isTransient)
+	droppedSecond, errSecond := bisectPersist(ctx, persistables[half:], persist, isTransient)
+	if errFirst != nil {
+		return droppedFirst + droppedSecond, errFirst
+	}
+	return droppedFirst + droppedSecond, errSecond
 }
 
-func (q *QueryBatch) cleanBatch() {
-	q.batch = &pgx.Batch{}
-	q.persistables = make([]Persistable, 0)
+func persistWithRetries(
+	ctx context.Context,
+	persistables []Persistable,
+	persist persistFn,
+	isTransient func(error) bool) error {
+
+	logEntry := log.WithFields(log.Fields{
+		"mod": "batch-persister",
+	})
+	backoff := RetryBackoff
+	for i := 0; ; i++ {
+		t := time.Now()
+		err := persist(persistables)
+		if err == nil {
+			logEntry.Tracef("persisted %d queries in %s", len(persistables), time.Since(t))
+			return nil
+		}
+		if !isTransient(err) || i >= MaxRetries {
+			return err
+		}
+		logEntry.Warnf("attempt numb %d to persist %d queries failed, retrying in %s: %s",
+			i+1, len(persistables), backoff, err.Error())
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return err
+		}
+	}
 }
 
 // persistable is the main structure fed to the batcher
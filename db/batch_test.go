@@ -0,0 +1,69 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistIsolating(t *testing.T) {
+	defer func(file string, backoff time.Duration) {
+		DeadLetterFile, RetryBackoff = file, backoff
+	}(DeadLetterFile, RetryBackoff)
+	DeadLetterFile = filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	RetryBackoff = time.Millisecond
+
+	errBad := errors.New("bad value")
+	errBusy := errors.New("busy")
+	isTransient := func(err error) bool { return err == errBusy }
+
+	persistables := make([]Persistable, 0)
+	for i := 0; i < 100; i++ {
+		persistables = append(persistables, Persistable{query: "INSERT $1", values: []interface{}{i}})
+	}
+	// the batches fail as a whole if any of them has a bad value, and the first attempts are busy
+	persisted := make(map[int]bool)
+	busy := 2
+	persist := func(batch []Persistable) error {
+		if busy > 0 {
+			busy--
+			return errBusy
+		}
+		for _, persis := range batch {
+			if v := persis.values[0].(int); v == 13 || v == 77 {
+				return errBad
+			}
+		}
+		for _, persis := range batch {
+			persisted[persis.values[0].(int)] = true
+		}
+		return nil
+	}
+
+	err := persistIsolating(context.Background(), persistables, persist, isTransient)
+	require.ErrorIs(t, err, errBad)
+	require.Len(t, persisted, 98)
+	require.False(t, persisted[13])
+	require.False(t, persisted[77])
+
+	f, err := os.Open(DeadLetterFile)
+	require.NoError(t, err)
+	defer f.Close()
+	dropped := make([]float64, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var letter deadLetter
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &letter))
+		require.Equal(t, "INSERT $1", letter.Query)
+		require.Equal(t, errBad.Error(), letter.Error)
+		dropped = append(dropped, letter.Values[0].(float64))
+	}
+	require.Equal(t, []float64{13, 77}, dropped)
+}
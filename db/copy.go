@@ -0,0 +1,271 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+
+	"github.com/cortze/ragno/models"
+)
+
+var (
+	// MAX_COPY_QUEUE is the number of staged rows that can wait to be copied before the
+	// persists block, MAX_COPY_BATCH the number of rows sent on each COPY
+	MAX_COPY_QUEUE = 50000
+	MAX_COPY_BATCH = 5000
+	// CopyMergeInterval is how often the staged rows are merged into the final tables
+	CopyMergeInterval = 5 * time.Second
+	// StagingOrphanTimeout is how long a staged attempt waits for its node to be in
+	// node_info before being dropped (to the dead-letter file)
+	StagingOrphanTimeout = 1 * time.Hour
+)
+
+// copyTable is a staging table where the append-only rows are bulk copied (COPY), instead
+// of inserting them one by one through the batches
+type copyTable struct {
+	name    string
+	columns []string
+}
+
+// the staging tables have the columns of the inserts they replace, in the same order
+var (
+	connAttemptsStaging = &copyTable{
+		name: "conn_attempts_staging",
+		columns: []string{"node_id", "tried_at", "error", "deprecated", "latency", "inbound",
+			"tcp_latency", "rlpx_latency", "hello_latency", "status_latency"},
+	}
+	enrSightingsStaging = &copyTable{
+		name: "enr_sightings_staging",
+		columns: []string{"node_id", "origin", "first_seen", "last_seen", "ip", "tcp", "udp",
+			"seq", "pubkey", "record", "score", "ip6", "ip_family"},
+	}
+)
+
+type stagedRow struct {
+	table *copyTable
+	row   Persistable
+}
+
+// stageConnectionAttempt queues the attempt to be copied into the staging table
+func (p *PostgresDBService) stageConnectionAttempt(attempt models.ConnectionAttempt) {
+	_, values := insertConnectionAttempt(attempt)
+	p.stage(connAttemptsStaging, values)
+}
+
+// stageENR queues the sighting of the ENR to be copied into the staging table
+func (p *PostgresDBService) stageENR(enr *models.ENR) {
+	_, values := insertENR(enr)
+	values = append(values, models.GetIPFamily(enr.IP).String())
+	p.stage(enrSightingsStaging, values)
+}
+
+func (p *PostgresDBService) stage(table *copyTable, values []interface{}) {
+	row := NewPersistable()
+	// the query only identifies the row in the dead-letter file
+	row.query = "COPY " + table.name
+	row.values = values
+	p.copyChan <- stagedRow{table: table, row: row}
+}
+
+// runCopier copies the staged rows in bulk and periodically merges them into the final
+// tables. It runs on a single routine, so the rows are always copied before being merged
+func (p *PostgresDBService) runCopier() {
+	defer p.wgCopier.Done()
+	clog := wlog.WithField("db-writer", "copier")
+	pending := map[*copyTable][]Persistable{
+		connAttemptsStaging: make([]Persistable, 0),
+		enrSightingsStaging: make([]Persistable, 0),
+	}
+	flush := func(table *copyTable) {
+		if err := persistIsolating(p.ctx, pending[table], p.copyRows(table), isTransientPgError); err != nil {
+			clog.Errorf("unable to copy the rows into %s: %s", table.name, err.Error())
+		}
+		pending[table] = make([]Persistable, 0)
+	}
+	mergeStaged := func() {
+		for table := range pending {
+			flush(table)
+		}
+		if err := p.mergeStaged(); err != nil {
+			clog.Errorf("unable to merge the staged rows: %s", err.Error())
+		}
+	}
+
+	flushTicker := time.NewTicker(RoutineFlushTimeout)
+	defer flushTicker.Stop()
+	mergeTicker := time.NewTicker(CopyMergeInterval)
+	defer mergeTicker.Stop()
+	for {
+		select {
+		case staged := <-p.copyChan:
+			pending[staged.table] = append(pending[staged.table], staged.row)
+			if len(pending[staged.table]) >= MAX_COPY_BATCH {
+				flush(staged.table)
+			}
+
+		case <-flushTicker.C:
+			for table, rows := range pending {
+				if len(rows) > 0 {
+					flush(table)
+				}
+			}
+
+		case <-mergeTicker.C:
+			mergeStaged()
+
+		case flushed := <-p.copyFlushC:
+			for len(p.copyChan) > 0 {
+				staged := <-p.copyChan
+				pending[staged.table] = append(pending[staged.table], staged.row)
+			}
+			mergeStaged()
+			close(flushed)
+
+		case <-p.copyDone:
+			for len(p.copyChan) > 0 {
+				staged := <-p.copyChan
+				pending[staged.table] = append(pending[staged.table], staged.row)
+			}
+			mergeStaged()
+			return
+
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// copyRows returns the function that copies the rows into the staging table, which
+// fails as a whole if any of the rows can't be copied
+func (p *PostgresDBService) copyRows(table *copyTable) persistFn {
+	return func(rows []Persistable) error {
+		ctx, cancel := context.WithTimeout(p.ctx, QueryTimeout)
+		defer cancel()
+		values := make([][]interface{}, len(rows))
+		for i, row := range rows {
+			values[i] = row.values
+		}
+		_, err := p.psqlPool.CopyFrom(ctx, pgx.Identifier{table.name}, table.columns, pgx.CopyFromRows(values))
+		return err
+	}
+}
+
+// mergeStaged moves the staged rows into the final tables. The ENRs go first, as they
+// add the nodes to node_info that the attempts reference. The attempts of nodes that
+// aren't in node_info yet stay staged for the next merge, until they are orphans
+func (p *PostgresDBService) mergeStaged() error {
+	ctx, cancel := context.WithTimeout(p.ctx, QueryTimeout)
+	defer cancel()
+	t := time.Now()
+	var enrs, attempts int64
+	orphans := make([]Persistable, 0)
+	err := p.psqlPool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, mergeENRSightings)
+		if err != nil {
+			return errors.Wrap(err, "unable to merge the enr sightings")
+		}
+		enrs = tag.RowsAffected()
+		tag, err = tx.Exec(ctx, mergeConnAttempts)
+		if err != nil {
+			return errors.Wrap(err, "unable to merge the connection attempts")
+		}
+		attempts = tag.RowsAffected()
+
+		rows, err := tx.Query(ctx, deleteOrphanConnAttempts, time.Now().Add(-StagingOrphanTimeout))
+		if err != nil {
+			return errors.Wrap(err, "unable to delete the orphan connection attempts")
+		}
+		defer rows.Close()
+		for rows.Next() {
+			values, err := rows.Values()
+			if err != nil {
+				return errors.Wrap(err, "unable to read the orphan connection attempts")
+			}
+			orphan := NewPersistable()
+			orphan.query = "COPY " + connAttemptsStaging.name
+			orphan.values = values
+			orphans = append(orphans, orphan)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return err
+	}
+	if len(orphans) > 0 {
+		writeDeadLetters(errors.New("the node of the attempt was never stored in node_info"), orphans)
+	}
+	wlog.Tracef("merged %d enrs and %d connection attempts in %s", enrs, attempts, time.Since(t))
+	return nil
+}
+
+const (
+	// mergeENRSightings keeps the last sighting of each node (and the first time it was
+	// seen), and upserts it in enrs and node_info as insertENR and upsertHostInfoFromENR do
+	mergeENRSightings = `
+	WITH staged AS (
+		DELETE FROM enr_sightings_staging
+		RETURNING *
+	), latest AS (
+		SELECT DISTINCT ON (node_id)
+			node_id,
+			origin,
+			MIN(first_seen) OVER (PARTITION BY node_id) AS first_seen,
+			last_seen,
+			ip,
+			tcp,
+			udp,
+			seq,
+			pubkey,
+			record,
+			score,
+			ip6,
+			ip_family
+		FROM staged
+		ORDER BY node_id, last_seen DESC
+	), merged_enrs AS (
+		INSERT INTO enrs (node_id, origin, first_seen, last_seen, ip, tcp, udp, seq, pubkey, record, score, ip6)
+		SELECT node_id, origin, first_seen, last_seen, ip, tcp, udp, seq, pubkey, record, score, ip6
+		FROM latest
+		ON CONFLICT (node_id) DO UPDATE SET
+			origin = EXCLUDED.origin,
+			last_seen = EXCLUDED.last_seen,
+			ip = EXCLUDED.ip,
+			tcp = EXCLUDED.tcp,
+			udp = EXCLUDED.udp,
+			seq = EXCLUDED.seq,
+			pubkey = EXCLUDED.pubkey,
+			record = EXCLUDED.record,
+			score = EXCLUDED.score,
+			ip6 = EXCLUDED.ip6
+	)
+	INSERT INTO node_info (node_id, pubkey, ip, tcp, deprecated, ip_family)
+	SELECT node_id, pubkey, ip, tcp, false, ip_family
+	FROM latest
+	ON CONFLICT (node_id) DO UPDATE SET
+		ip = EXCLUDED.ip,
+		tcp = EXCLUDED.tcp,
+		deprecated = EXCLUDED.deprecated,
+		ip_family = EXCLUDED.ip_family;
+	`
+
+	mergeConnAttempts = `
+	WITH staged AS (
+		DELETE FROM conn_attempts_staging
+		WHERE node_id IN (SELECT node_id FROM node_info)
+		RETURNING *
+	)
+	INSERT INTO conn_attempts (node_id, tried_at, error, deprecated, latency, inbound,
+		tcp_latency, rlpx_latency, hello_latency, status_latency)
+	SELECT node_id, tried_at, error, deprecated, latency, inbound,
+		tcp_latency, rlpx_latency, hello_latency, status_latency
+	FROM staged;
+	`
+
+	deleteOrphanConnAttempts = `
+	DELETE FROM conn_attempts_staging
+	WHERE tried_at < $1 AND node_id NOT IN (SELECT node_id FROM node_info)
+	RETURNING *;
+	`
+)
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cortze/ragno/models"
+)
+
+func insertCrawl(crawl *models.Crawl) (query string, args []interface{}) {
+	query = `
+	INSERT INTO crawls (
+		network_id,
+		started_at,
+		ended_at,
+		discovered,
+		dialed,
+		reachable,
+		clients,
+		complete
+	) VALUES ($1,$2,$3,$4,$5,$6,$7,$8);
+	`
+	args = append(args, crawl.NetworkID)
+	args = append(args, crawl.Start)
+	args = append(args, crawl.End)
+	args = append(args, crawl.Discovered)
+	args = append(args, crawl.Dialed)
+	args = append(args, crawl.Reachable)
+	args = append(args, crawl.Clients)
+	args = append(args, crawl.Complete)
+	return query, args
+}
+
+// InsertCrawl stores the summary of a one-shot crawl right away, as the crawler exits
+// right after it. It doesn't depend on the context of the service, so that the summary of
+// an interrupted crawl is still stored
+func (d *PostgresDBService) InsertCrawl(crawl *models.Crawl) error {
+	log.Debug("inserting the summary of the crawl")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	query, args := insertCrawl(crawl)
+	_, err := d.psqlPool.Exec(ctx, query, args...)
+	if err != nil {
+		return errors.Wrap(err, "unable to insert the crawl summary")
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DeadLetterFile is where the queries that couldn't be persisted are appended, one json
+// per line, so that they can be inspected and replayed
+var DeadLetterFile = "dead-letters.jsonl"
+
+var deadLettersMu sync.Mutex
+
+type deadLetter struct {
+	Time   time.Time     `json:"time"`
+	Error  string        `json:"error"`
+	Query  string        `json:"query"`
+	Values []interface{} `json:"values"`
+}
+
+// writeDeadLetters logs the dropped persistables and appends them to the dead-letter file
+func writeDeadLetters(err error, persistables []Persistable) {
+	deadLettersMu.Lock()
+	defer deadLettersMu.Unlock()
+
+	f, ferr := os.OpenFile(DeadLetterFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if ferr != nil {
+		log.Errorf("unable to open the dead-letter file %s: %s", DeadLetterFile, ferr.Error())
+	} else {
+		defer f.Close()
+	}
+	for _, persis := range persistables {
+		letter := deadLetter{
+			Time:   time.Now(),
+			Error:  err.Error(),
+			Query:  strings.Join(strings.Fields(persis.query), " "),
+			Values: persis.values,
+		}
+		log.WithFields(log.Fields{
+			"query":  letter.Query,
+			"values": letter.Values,
+		}).Errorf("dropping query: %s", letter.Error)
+		if f == nil {
+			continue
+		}
+		line, jerr := json.Marshal(letter)
+		if jerr != nil {
+			// keep at least the printed values
+			letter.Values = []interface{}{fmt.Sprint(persis.values...)}
+			line, _ = json.Marshal(letter)
+		}
+		f.Write(append(line, '\n'))
+	}
+}
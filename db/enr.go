@@ -4,12 +4,13 @@ import (
 	"encoding/hex"
 
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/cortze/ragno/models"
 )
 
-func (d *PostgresDBService) insertENR(node *models.ENR) (query string, args []interface{}) {
+func insertENR(node *models.ENR) (query string, args []interface{}) {
 	log.Trace("Upserting new enr to Eth Nodes")
 	query = `
 	INSERT INTO enrs (
@@ -23,8 +24,9 @@ func (d *PostgresDBService) insertENR(node *models.ENR) (query string, args []in
 		seq,
 		pubkey,
 		record,
-	    score
-	) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+	    score,
+		ip6
+	) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
 	ON CONFLICT (node_id) DO UPDATE SET
 		node_id = $1,
 	    origin = $2,
@@ -35,7 +37,8 @@ func (d *PostgresDBService) insertENR(node *models.ENR) (query string, args []in
 		seq = $8,
 		pubkey = $9,
 		record = $10,
-		score = $11;
+		score = $11,
+		ip6 = $12;
 	`
 
 	pubBytes := crypto.FromECDSAPub(node.Node.Pubkey())
@@ -52,18 +55,50 @@ func (d *PostgresDBService) insertENR(node *models.ENR) (query string, args []in
 	args = append(args, pubKey)
 	args = append(args, node.Node.String())
 	args = append(args, node.Score)
+	args = append(args, ip6Arg(node.IP6))
 
 	return query, args
 }
 
-// PersistENR queues a new ENR into the databas-e
+// ip6Arg returns NULL for the records that don't announce an IPv6
+func ip6Arg(ip6 string) interface{} {
+	if ip6 == "" {
+		return nil
+	}
+	return ip6
+}
+
+// PersistENR queues a new ENR into the databas-e. The sightings are bulk copied and then
+// merged into enrs and node_info (with its host info)
 func (d *PostgresDBService) PersistENR(enr *models.ENR) {
-	p := NewPersistable()
-	p.query, p.values = d.insertENR(enr)
-	d.writeChan <- p
-	// insert new row at node_info with host_info
-	p = NewPersistable()
-	hInfo := enr.GetHostInfo()
-	p.query, p.values = d.upsertHostInfoFromENR(hInfo)
-	d.writeChan <- p
+	d.stageENR(enr)
+}
+
+// GetSeedENRs returns the records of the most recently seen ENRs from the given origins
+// that weren't deprecated, to seed the discovery tables with them
+func (d *PostgresDBService) GetSeedENRs(origins []string, limit int) ([]string, error) {
+	query := `
+	SELECT
+		enrs.record
+	FROM enrs
+	LEFT JOIN node_info ON enrs.node_id = node_info.node_id
+	WHERE enrs.origin = ANY($1) AND node_info.deprecated IS NOT TRUE
+	ORDER BY enrs.last_seen DESC
+	LIMIT $2;
+	`
+	records := make([]string, 0)
+	rows, err := d.psqlPool.Query(d.ctx, query, origins, limit)
+	if err != nil {
+		return records, errors.Wrap(err, "unable to retrieve the seed enrs")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var record string
+		err := rows.Scan(&record)
+		if err != nil {
+			return records, errors.Wrap(err, "unable to parse the seed enrs from db")
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
 }
@@ -9,8 +9,8 @@ import (
 	"github.com/cortze/ragno/models"
 )
 
-// UpsertIP attemtps to insert IP in the DB - or Updates the data info if they where already there
-func (p *PostgresDBService) UpsertIpInfo(IPInfo models.IPInfo) (query string, args []interface{}) {
+// upsertIPInfo attemtps to insert IP in the DB - or Updates the data info if they where already there
+func upsertIPInfo(IPInfo models.IPInfo) (query string, args []interface{}) {
 	query = `
 	INSERT INTO ip_info(
 		ip,
@@ -186,6 +186,29 @@ func (p *PostgresDBService) CheckIPRecords(ip string) (exists bool, expired bool
 
 func (p *PostgresDBService) PersistIPInfo(ip models.IPInfo) {
 	pAttempt := NewPersistable()
-	pAttempt.query, pAttempt.values = p.UpsertIpInfo(ip)
+	pAttempt.query, pAttempt.values = upsertIPInfo(ip)
 	p.writeChan <- pAttempt
 }
+
+// GetIPASNs returns the AS number (e.g. AS24940) of each of the located IPs
+func (p *PostgresDBService) GetIPASNs() (map[string]string, error) {
+	asns := make(map[string]string)
+	rows, err := p.psqlPool.Query(p.ctx, `
+		SELECT ip, split_part(as_raw, ' ', 1)
+		FROM ip_info
+		WHERE as_raw <> '';
+	`)
+	if err != nil {
+		return asns, errors.Wrap(err, "unable to get the asn of the ips")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ip, asn string
+		if err := rows.Scan(&ip, &asn); err != nil {
+			return asns, errors.Wrap(err, "error parsing readed row for ip asns")
+		}
+		asns[ip] = asn
+	}
+	return asns, nil
+}
@@ -23,7 +23,7 @@ func (db *PostgresDBService) GetClientDistribution() (map[string]interface{}, er
 			FROM node_info
 			WHERE
 				first_connected IS NOT NULL AND
-				network_id = 1 AND
+				network_id = $2 AND
 				deprecated = 'false' AND
 				client_name IS NOT NULL AND
 				last_connected > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
@@ -31,6 +31,7 @@ func (db *PostgresDBService) GetClientDistribution() (map[string]interface{}, er
 			ORDER BY count DESC;
 		`,
 		LastActivityValidRange,
+		db.networkID,
 	)
 
 	// close rows AND free the connection/session
@@ -67,7 +68,7 @@ func (db *PostgresDBService) GetVersionDistribution() (map[string]interface{}, e
 			FROM node_info
 			WHERE
 				first_connected IS NOT NULL AND
-				network_id = 1 AND
+				network_id = $2 AND
 				deprecated = 'false' AND
 				client_name IS NOT NULL AND
 				last_connected > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
@@ -75,6 +76,7 @@ func (db *PostgresDBService) GetVersionDistribution() (map[string]interface{}, e
 			ORDER BY client_name DESC, cnt DESC;
 		`,
 		LastActivityValidRange,
+		db.networkID,
 	)
 	// make sure we close the rows AND we free the connection/session
 	defer rows.Close()
@@ -112,7 +114,7 @@ func (db *PostgresDBService) GetGeoDistribution() (map[string]interface{}, error
 				FROM node_info
 				RIGHT JOIN ip_info on node_info.ip = ip_info.ip
 				WHERE first_connected IS NOT NULL AND
-				network_id = 1 AND
+				network_id = $2 AND
 					deprecated = 'false' AND
 					client_name IS NOT NULL AND
 					last_connected > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
@@ -121,6 +123,7 @@ func (db *PostgresDBService) GetGeoDistribution() (map[string]interface{}, error
 			ORDER BY cnt DESC;
 		`,
 		LastActivityValidRange,
+		db.networkID,
 	)
 	// make sure we close the rows and we free the connection/session
 	defer rows.Close()
@@ -151,7 +154,7 @@ func (db *PostgresDBService) GetOsDistribution() (map[string]interface{}, error)
 				count(client_os) as nodes
 			FROM node_info
 			WHERE first_connected IS NOT NULL AND
-				network_id = 1 AND
+				network_id = $2 AND
 				deprecated = 'false' AND
 				client_name IS NOT NULL AND
 				last_connected > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
@@ -159,6 +162,7 @@ func (db *PostgresDBService) GetOsDistribution() (map[string]interface{}, error)
 			ORDER BY nodes DESC;
 		`,
 		LastActivityValidRange,
+		db.networkID,
 	)
 	if err != nil {
 		return summary, err
@@ -185,7 +189,7 @@ func (db *PostgresDBService) GetArchDistribution() (map[string]interface{}, erro
 				count(client_arch) as nodes
 			FROM node_info
 			WHERE first_connected IS NOT NULL AND
-				network_id = 1 AND
+				network_id = $2 AND
 				deprecated = 'false' AND
 				client_name IS NOT NULL AND
 				last_connected > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
@@ -193,6 +197,7 @@ func (db *PostgresDBService) GetArchDistribution() (map[string]interface{}, erro
 			ORDER BY nodes DESC;
 		`,
 		LastActivityValidRange,
+		db.networkID,
 	)
 	if err != nil {
 		return summary, err
@@ -230,13 +235,14 @@ func (db *PostgresDBService) GetHostingDistribution() (map[string]interface{}, e
 			INNER JOIN ip_info ON ni.ip=ip_info.ip
 			WHERE ni.deprecated='false' and
 			      first_connected IS NOT NULL AND
-				  network_id = 1 AND
+				  network_id = $2 AND
 			      client_name IS NOT NULL and
 			      ip_info.mobile='true' and
 			      last_connected > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
 		) as aux
 		`,
 		LastActivityValidRange,
+		db.networkID,
 	).Scan(&mobile)
 	if err != nil {
 		return summary, err
@@ -262,12 +268,13 @@ func (db *PostgresDBService) GetHostingDistribution() (map[string]interface{}, e
 			INNER JOIN ip_info ON ni.ip=ip_info.ip
 			WHERE ni.deprecated='false' and
 			      first_connected IS NOT NULL AND
-				  network_id = 1 AND
+				  network_id = $2 AND
 			      client_name IS NOT NULL and ip_info.proxy='true' and
 			      last_connected > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
 		) as aux
 		`,
 		LastActivityValidRange,
+		db.networkID,
 	).Scan(&proxy)
 	if err != nil {
 		return summary, err
@@ -293,13 +300,14 @@ func (db *PostgresDBService) GetHostingDistribution() (map[string]interface{}, e
 			INNER JOIN ip_info ON ni.ip=ip_info.ip
 			WHERE ni.deprecated='false' and
 			      first_connected IS NOT NULL AND
-				  network_id = 1 AND
+				  network_id = $2 AND
 			      client_name IS NOT NULL and
 			      ip_info.hosting='true' and
 			      last_connected > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
 		) as aux
 		`,
 		LastActivityValidRange,
+		db.networkID,
 	).Scan(&hosted)
 	if err != nil {
 		return summary, err
@@ -323,7 +331,7 @@ func (db *PostgresDBService) GetIPDistribution() (map[string]interface{}, error)
 					count(ip) as nodes
 				FROM node_info
 				WHERE deprecated = false AND
-					network_id = 1 AND
+					network_id = $2 AND
 					client_name IS NOT NULL AND
 					last_connected > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
 				GROUP BY ip
@@ -333,6 +341,7 @@ func (db *PostgresDBService) GetIPDistribution() (map[string]interface{}, error)
 			ORDER BY number_of_ip_info DESC;
 		`,
 		LastActivityValidRange,
+		db.networkID,
 	)
 	if err != nil {
 		return summary, err
@@ -379,7 +388,7 @@ func (db *PostgresDBService) GetRTTDistribution() (map[string]interface{}, error
 					END as latency
 				FROM node_info
 				WHERE deprecated = false AND
-					network_id = 1 AND
+					network_id = $2 AND
 					client_name IS NOT NULL AND
 					last_connected > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
 			) as t
@@ -387,6 +396,7 @@ func (db *PostgresDBService) GetRTTDistribution() (map[string]interface{}, error
 			ORDER BY nodes DESC;
 		`,
 		LastActivityValidRange,
+		db.networkID,
 	)
 	if err != nil {
 		return summary, err
@@ -427,3 +437,178 @@ func (db *PostgresDBService) GetDeprecatedNodes() (int, error) {
 
 	return deprecatedCount, nil
 }
+
+// GetForkClassDistribution returns the number of active nodes that fall in each of
+// the fork-id compatibility classes
+func (db *PostgresDBService) GetForkClassDistribution() (map[string]interface{}, error) {
+	log.Debug("fetching fork class distribution metrics")
+	forkDist := make(map[string]interface{}, 0)
+
+	rows, err := db.psqlPool.Query(
+		db.ctx,
+		`
+			SELECT
+				fork_class, count(fork_class) as cnt
+			FROM node_info
+			WHERE
+				first_connected IS NOT NULL AND
+				network_id = $2 AND
+				deprecated = 'false' AND
+				fork_class IS NOT NULL AND
+				last_connected > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
+			GROUP BY fork_class
+			ORDER BY cnt DESC;
+		`,
+		LastActivityValidRange,
+		db.networkID,
+	)
+	// make sure we close the rows AND we free the connection/session
+	defer rows.Close()
+	if err != nil {
+		return forkDist, errors.Wrap(err, "unable to fetch fork class distribution")
+	}
+
+	for rows.Next() {
+		var class string
+		var count int
+		err = rows.Scan(&class, &count)
+		if err != nil {
+			return forkDist, errors.Wrap(err, "unable to parse fork class distribution")
+		}
+		forkDist[class] = count
+	}
+
+	return forkDist, nil
+}
+
+// GetSyncStatusDistribution returns the number of active nodes whose probed head is
+// synced, lagging or stalled
+func (db *PostgresDBService) GetSyncStatusDistribution() (map[string]interface{}, error) {
+	log.Debug("fetching sync status distribution metrics")
+	syncDist := make(map[string]interface{}, 0)
+
+	rows, err := db.psqlPool.Query(
+		db.ctx,
+		`
+			SELECT
+				sync_status, count(sync_status) as cnt
+			FROM node_info
+			WHERE
+				first_connected IS NOT NULL AND
+				network_id = $2 AND
+				deprecated = 'false' AND
+				head_number IS NOT NULL AND
+				last_connected > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
+			GROUP BY sync_status
+			ORDER BY cnt DESC;
+		`,
+		LastActivityValidRange,
+		db.networkID,
+	)
+	// make sure we close the rows AND we free the connection/session
+	defer rows.Close()
+	if err != nil {
+		return syncDist, errors.Wrap(err, "unable to fetch sync status distribution")
+	}
+
+	for rows.Next() {
+		var status string
+		var count int
+		err = rows.Scan(&status, &count)
+		if err != nil {
+			return syncDist, errors.Wrap(err, "unable to parse sync status distribution")
+		}
+		syncDist[status] = count
+	}
+
+	return syncDist, nil
+}
+
+// GetProtocolDistribution returns the number of active nodes that negotiated each of the
+// subprotocols with us
+func (db *PostgresDBService) GetProtocolDistribution() (map[string]interface{}, error) {
+	log.Debug("fetching subprotocol distribution metrics")
+	protoDist := make(map[string]interface{}, 0)
+
+	rows, err := db.psqlPool.Query(
+		db.ctx,
+		`
+			SELECT
+				aux.protocol, count(aux.node_id) as cnt
+			FROM (
+				SELECT node_id, unnest(protocols) as protocol
+				FROM node_info
+				WHERE
+					first_connected IS NOT NULL AND
+					network_id = $2 AND
+					deprecated = 'false' AND
+					last_connected > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
+			) as aux
+			GROUP BY aux.protocol
+			ORDER BY cnt DESC;
+		`,
+		LastActivityValidRange,
+		db.networkID,
+	)
+	// make sure we close the rows AND we free the connection/session
+	defer rows.Close()
+	if err != nil {
+		return protoDist, errors.Wrap(err, "unable to fetch subprotocol distribution")
+	}
+
+	for rows.Next() {
+		var protocol string
+		var count int
+		err = rows.Scan(&protocol, &count)
+		if err != nil {
+			return protoDist, errors.Wrap(err, "unable to parse subprotocol distribution")
+		}
+		protoDist[protocol] = count
+	}
+
+	return protoDist, nil
+}
+
+// GetSnapStatusDistribution returns the number of active nodes that do or don't serve
+// the snap data they were asked for
+func (db *PostgresDBService) GetSnapStatusDistribution() (map[string]interface{}, error) {
+	log.Debug("fetching snap status distribution metrics")
+	snapDist := make(map[string]interface{}, 0)
+
+	rows, err := db.psqlPool.Query(
+		db.ctx,
+		`
+			SELECT
+				snap_status, count(snap_status) as cnt
+			FROM node_info
+			WHERE
+				first_connected IS NOT NULL AND
+				network_id = $2 AND
+				deprecated = 'false' AND
+				snap_status IS NOT NULL AND
+				snap_status != 'unknown' AND
+				last_connected > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
+			GROUP BY snap_status
+			ORDER BY cnt DESC;
+		`,
+		LastActivityValidRange,
+		db.networkID,
+	)
+	// make sure we close the rows AND we free the connection/session
+	defer rows.Close()
+	if err != nil {
+		return snapDist, errors.Wrap(err, "unable to fetch snap status distribution")
+	}
+
+	for rows.Next() {
+		var status string
+		var count int
+		err = rows.Scan(&status, &count)
+		if err != nil {
+			return snapDist, errors.Wrap(err, "unable to parse snap status distribution")
+		}
+		snapDist[status] = count
+	}
+
+	return snapDist, nil
+}
@@ -1,27 +1,58 @@
 package db
 
 import (
+	"embed"
+	"strings"
+
 	migrate "github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/pkg/errors"
+)
+
+// the migrations are embedded, so that the binary doesn't depend on the folder it runs from
+//
+//go:embed migrations/*.sql migrations/sqlite/*.sql
+var migrationsFS embed.FS
+
+const (
+	postgresMigrations = "migrations"
+	sqliteMigrations   = "migrations/sqlite"
 )
 
-// Use as a reference because it contains sync.WaitGroup
-func (p *PostgresDBService) makeMigrations() error {
-	m, err := migrate.New("file://db/migrations", p.connectionUrl)
+// NewMigrator returns the migrator of the schema of the database behind the endpoint,
+// with the migrations of its backend. It has to be closed once used
+func NewMigrator(endpoint string) (*migrate.Migrate, error) {
+	dir := postgresMigrations
+	if strings.HasPrefix(endpoint, SQLitePrefix) {
+		dir = sqliteMigrations
+	}
+	source, err := iofs.New(migrationsFS, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read the embedded migrations")
+	}
+	m, err := migrate.NewWithSourceInstance("iofs", source, endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open the database to migrate")
+	}
+	return m, nil
+}
+
+// makeMigrations applies the pending migrations to the database behind the endpoint
+func makeMigrations(endpoint string) error {
+	m, err := NewMigrator(endpoint)
 	if err != nil {
 		return err
 	}
 	wlog.Infof("applying database migrations...")
-	if err := m.Up(); err != nil {
-		if err != migrate.ErrNoChange {
-			return err
-		}
-	}
-	connErr, dbErr := m.Close()
-	if connErr != nil {
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		m.Close()
 		return err
 	}
+	srcErr, dbErr := m.Close()
+	if srcErr != nil {
+		return srcErr
+	}
 	if dbErr != nil {
 		return dbErr
 	}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPartitionConnAttemptsMigration needs a disposable postgres database, as it drops
+// everything in it, at the endpoint of RAGNO_TEST_POSTGRES
+func TestPartitionConnAttemptsMigration(t *testing.T) {
+	endpoint := os.Getenv("RAGNO_TEST_POSTGRES")
+	if endpoint == "" {
+		t.Skip("RAGNO_TEST_POSTGRES not set")
+	}
+	ctx := context.Background()
+
+	m, err := NewMigrator(endpoint)
+	require.NoError(t, err)
+	require.NoError(t, m.Drop())
+	m.Close()
+	// a new migrator creates again the version table that Drop removed
+	m, err = NewMigrator(endpoint)
+	require.NoError(t, err)
+	defer m.Close()
+	require.NoError(t, m.Migrate(18))
+
+	conn, err := pgx.Connect(ctx, endpoint)
+	require.NoError(t, err)
+	defer conn.Close(ctx)
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	_, err = conn.Exec(ctx, `INSERT INTO node_info (node_id, pubkey, ip, tcp) VALUES ('node', 'pubkey', '8.8.8.8', 30303);`)
+	require.NoError(t, err)
+	for _, triedAt := range []time.Time{today.AddDate(0, 0, -10), today.AddDate(0, 0, -1), today.Add(time.Minute)} {
+		_, err = conn.Exec(ctx, `INSERT INTO conn_attempts (node_id, tried_at, error) VALUES ('node', $1, 'none');`, triedAt)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, m.Up())
+
+	var attempts int
+	require.NoError(t, conn.QueryRow(ctx, `SELECT COUNT(*) FROM conn_attempts;`).Scan(&attempts))
+	require.Equal(t, 3, attempts)
+
+	legacy := legacyPartitionPrefix + today.Format(partitionDayFormat)
+	daily := dailyPartitionPrefix + today.Format(partitionDayFormat)
+	partitions := make(map[string]int)
+	for _, partition := range []string{legacy, daily, defaultPartition} {
+		var count int
+		err := conn.QueryRow(ctx, `SELECT COUNT(*) FROM `+pgx.Identifier{partition}.Sanitize()+`;`).Scan(&count)
+		require.NoError(t, err, partition)
+		partitions[partition] = count
+	}
+	require.Equal(t, map[string]int{legacy: 2, daily: 1, defaultPartition: 0}, partitions)
+
+	// the ids keep coming from the same sequence
+	var id int
+	err = conn.QueryRow(ctx, `INSERT INTO conn_attempts (node_id, tried_at, error) VALUES ('node', $1, 'none') RETURNING id;`,
+		time.Now()).Scan(&id)
+	require.NoError(t, err)
+	require.Equal(t, 4, id)
+}
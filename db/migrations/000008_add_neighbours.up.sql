@@ -0,0 +1,11 @@
+-- Create table to store which nodes each discv4 node returned as neighbours, with the
+-- last time that each of them was returned
+CREATE TABLE IF NOT EXISTS neighbours (
+  node_id       TEXT NOT NULL,
+  neighbour_id  TEXT NOT NULL,
+  seen_at       TIMESTAMPTZ NOT NULL,
+  network_id    BIGINT NOT NULL,
+  PRIMARY KEY (node_id, neighbour_id, network_id)
+);
+
+CREATE INDEX IF NOT EXISTS neighbours_seen_at_idx ON neighbours (seen_at);
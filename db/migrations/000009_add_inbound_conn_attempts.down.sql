@@ -0,0 +1 @@
+ALTER TABLE conn_attempts DROP COLUMN IF EXISTS inbound;
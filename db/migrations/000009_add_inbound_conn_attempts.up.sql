@@ -0,0 +1,2 @@
+-- Mark the connections that the remote nodes initiated
+ALTER TABLE conn_attempts ADD COLUMN IF NOT EXISTS inbound BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1 @@
+ALTER TABLE node_info DROP COLUMN IF EXISTS fork_class;
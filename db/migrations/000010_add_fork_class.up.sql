@@ -0,0 +1,2 @@
+-- Compatibility of the fork id of the node with the crawled network
+ALTER TABLE node_info ADD COLUMN IF NOT EXISTS fork_class TEXT;
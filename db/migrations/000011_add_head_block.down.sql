@@ -0,0 +1,3 @@
+ALTER TABLE node_info DROP COLUMN IF EXISTS sync_status;
+ALTER TABLE node_info DROP COLUMN IF EXISTS head_timestamp;
+ALTER TABLE node_info DROP COLUMN IF EXISTS head_number;
@@ -0,0 +1,4 @@
+-- Head block of the node (only filled when the head is probed)
+ALTER TABLE node_info ADD COLUMN IF NOT EXISTS head_number BIGINT;
+ALTER TABLE node_info ADD COLUMN IF NOT EXISTS head_timestamp TIMESTAMP;
+ALTER TABLE node_info ADD COLUMN IF NOT EXISTS sync_status TEXT;
@@ -0,0 +1,13 @@
+-- Create table to store the connections that were kept open with the nodes
+CREATE TABLE IF NOT EXISTS sessions (
+  id                SERIAL PRIMARY KEY,
+  node_id           TEXT NOT NULL REFERENCES node_info(node_id),
+  started_at        TIMESTAMPTZ NOT NULL,
+  ended_at          TIMESTAMPTZ NOT NULL,
+  duration          BIGINT NOT NULL,
+  disconnect_reason TEXT,
+  local_disconnect  BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE INDEX IF NOT EXISTS sessions_node_id_idx ON sessions (node_id);
+CREATE INDEX IF NOT EXISTS sessions_started_at_idx ON sessions (started_at);
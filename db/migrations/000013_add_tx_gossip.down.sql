@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS tx_announcements;
+DROP TABLE IF EXISTS tx_sightings;
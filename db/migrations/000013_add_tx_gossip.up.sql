@@ -0,0 +1,26 @@
+-- Create table to store the first time each transaction was announced to us
+CREATE TABLE IF NOT EXISTS tx_sightings (
+  hash          TEXT PRIMARY KEY,
+  first_seen    TIMESTAMPTZ NOT NULL,
+  node_id       TEXT NOT NULL,
+  tx_type       SMALLINT,
+  size          BIGINT,
+  full_tx       BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE INDEX IF NOT EXISTS tx_sightings_first_seen_idx ON tx_sightings (first_seen);
+
+-- Create table to store the tx announcements that each node sent us in every window
+CREATE TABLE IF NOT EXISTS tx_announcements (
+  id            SERIAL PRIMARY KEY,
+  node_id       TEXT NOT NULL REFERENCES node_info(node_id),
+  window_start  TIMESTAMPTZ NOT NULL,
+  window_end    TIMESTAMPTZ NOT NULL,
+  messages      INT NOT NULL,
+  hashes        INT NOT NULL,
+  transactions  INT NOT NULL,
+  first_seen    INT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS tx_announcements_node_id_idx ON tx_announcements (node_id);
+CREATE INDEX IF NOT EXISTS tx_announcements_window_start_idx ON tx_announcements (window_start);
@@ -0,0 +1,2 @@
+ALTER TABLE node_info DROP COLUMN IF EXISTS snap_status;
+ALTER TABLE node_info DROP COLUMN IF EXISTS protocols;
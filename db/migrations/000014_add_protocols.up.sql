@@ -0,0 +1,3 @@
+-- Subprotocols negotiated with the node and whether it serves snap data
+ALTER TABLE node_info ADD COLUMN IF NOT EXISTS protocols TEXT[];
+ALTER TABLE node_info ADD COLUMN IF NOT EXISTS snap_status TEXT;
@@ -0,0 +1,4 @@
+ALTER TABLE conn_attempts DROP COLUMN IF EXISTS status_latency;
+ALTER TABLE conn_attempts DROP COLUMN IF EXISTS hello_latency;
+ALTER TABLE conn_attempts DROP COLUMN IF EXISTS rlpx_latency;
+ALTER TABLE conn_attempts DROP COLUMN IF EXISTS tcp_latency;
@@ -0,0 +1,5 @@
+-- Duration in milliseconds of each of the phases of the connection attempts
+ALTER TABLE conn_attempts ADD COLUMN IF NOT EXISTS tcp_latency BIGINT;
+ALTER TABLE conn_attempts ADD COLUMN IF NOT EXISTS rlpx_latency BIGINT;
+ALTER TABLE conn_attempts ADD COLUMN IF NOT EXISTS hello_latency BIGINT;
+ALTER TABLE conn_attempts ADD COLUMN IF NOT EXISTS status_latency BIGINT;
@@ -0,0 +1,2 @@
+ALTER TABLE node_info DROP COLUMN IF EXISTS ip_family;
+ALTER TABLE enrs DROP COLUMN IF EXISTS ip6;
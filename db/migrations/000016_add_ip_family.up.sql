@@ -0,0 +1,6 @@
+-- IPv6 announced by the dual-stack nodes next to their IPv4
+ALTER TABLE enrs ADD COLUMN IF NOT EXISTS ip6 TEXT;
+
+-- Family (ipv4 / ipv6) of the ip of the node
+ALTER TABLE node_info ADD COLUMN IF NOT EXISTS ip_family TEXT;
+UPDATE node_info SET ip_family = CASE WHEN ip LIKE '%:%' THEN 'ipv6' ELSE 'ipv4' END;
@@ -0,0 +1,14 @@
+-- Create table to store the summary of each one-shot crawl (ragno run --once)
+CREATE TABLE IF NOT EXISTS crawls (
+  id            SERIAL PRIMARY KEY,
+  network_id    BIGINT NOT NULL,
+  started_at    TIMESTAMPTZ NOT NULL,
+  ended_at      TIMESTAMPTZ NOT NULL,
+  discovered    INT NOT NULL,
+  dialed        INT NOT NULL,
+  reachable     INT NOT NULL,
+  clients       JSONB NOT NULL,
+  complete      BOOLEAN NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS crawls_started_at_idx ON crawls (started_at);
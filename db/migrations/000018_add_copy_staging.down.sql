@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS enr_sightings_staging;
+DROP TABLE IF EXISTS conn_attempts_staging;
@@ -0,0 +1,31 @@
+-- Staging tables the connection attempts and the ENR sightings are bulk copied into (COPY),
+-- to be merged periodically into conn_attempts, enrs and node_info. They are unlogged, so
+-- the rows that weren't merged yet are lost if postgres crashes
+CREATE UNLOGGED TABLE IF NOT EXISTS conn_attempts_staging (
+  node_id         TEXT NOT NULL,
+  tried_at        TIMESTAMPTZ NOT NULL,
+  error           TEXT,
+  deprecated      BOOLEAN,
+  latency         BIGINT,
+  inbound         BOOLEAN NOT NULL DEFAULT false,
+  tcp_latency     BIGINT,
+  rlpx_latency    BIGINT,
+  hello_latency   BIGINT,
+  status_latency  BIGINT
+);
+
+CREATE UNLOGGED TABLE IF NOT EXISTS enr_sightings_staging (
+  node_id     TEXT NOT NULL,
+  origin      TEXT NOT NULL,
+  first_seen  TIMESTAMP NOT NULL,
+  last_seen   TIMESTAMP NOT NULL,
+  ip          TEXT NOT NULL,
+  tcp         INT NOT NULL,
+  udp         INT NOT NULL,
+  seq         BIGINT NOT NULL,
+  pubkey      TEXT NOT NULL,
+  record      TEXT NOT NULL,
+  score       INT,
+  ip6         TEXT,
+  ip_family   TEXT
+);
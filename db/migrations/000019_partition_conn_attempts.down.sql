@@ -0,0 +1,24 @@
+-- Move the attempts back to a single table (the rolled up ones are lost)
+CREATE TABLE conn_attempts_unpartitioned (
+  id              INTEGER NOT NULL DEFAULT nextval('conn_attempts_id_seq') PRIMARY KEY,
+  node_id         TEXT NOT NULL REFERENCES node_info(node_id),
+  tried_at        TIMESTAMPTZ NOT NULL,
+  error           TEXT,
+  deprecated      BOOLEAN,
+  latency         BIGINT,
+  inbound         BOOLEAN NOT NULL DEFAULT false,
+  tcp_latency     BIGINT,
+  rlpx_latency    BIGINT,
+  hello_latency   BIGINT,
+  status_latency  BIGINT
+);
+INSERT INTO conn_attempts_unpartitioned SELECT * FROM conn_attempts;
+
+ALTER SEQUENCE conn_attempts_id_seq OWNED BY NONE;
+DROP TABLE conn_attempts;
+ALTER TABLE conn_attempts_unpartitioned RENAME TO conn_attempts;
+ALTER TABLE conn_attempts RENAME CONSTRAINT conn_attempts_unpartitioned_pkey TO conn_attempts_pkey;
+ALTER SEQUENCE conn_attempts_id_seq OWNED BY conn_attempts.id;
+
+DROP FUNCTION IF EXISTS jsonb_sum_counts(JSONB, JSONB);
+DROP TABLE IF EXISTS conn_attempts_daily;
@@ -0,0 +1,62 @@
+-- Partition the connection attempts by day (UTC). The existing table becomes the partition
+-- of all the attempts before the day of the migration (conn_attempts_legacy_<day>), and the
+-- ones of that day are moved to its own partition. The daily partitions are created ahead
+-- by the crawler, the default one only keeps the attempts that fall out of them
+DO $$
+DECLARE
+  today   TIMESTAMPTZ := date_trunc('day', now() AT TIME ZONE 'UTC') AT TIME ZONE 'UTC';
+  suffix  TEXT := to_char(now() AT TIME ZONE 'UTC', 'YYYYMMDD');
+  legacy  TEXT := 'conn_attempts_legacy_' || suffix;
+BEGIN
+  EXECUTE format('ALTER TABLE conn_attempts RENAME TO %I', legacy);
+  -- the partitions need the primary key of the parent, which includes the partition key
+  EXECUTE format('ALTER TABLE %I DROP CONSTRAINT conn_attempts_pkey', legacy);
+  EXECUTE format('ALTER TABLE %I ADD CONSTRAINT %I PRIMARY KEY (id, tried_at)', legacy, legacy || '_pkey');
+
+  CREATE TABLE conn_attempts (
+    id              INTEGER NOT NULL DEFAULT nextval('conn_attempts_id_seq'),
+    node_id         TEXT NOT NULL REFERENCES node_info(node_id),
+    tried_at        TIMESTAMPTZ NOT NULL,
+    error           TEXT,
+    deprecated      BOOLEAN,
+    latency         BIGINT,
+    inbound         BOOLEAN NOT NULL DEFAULT false,
+    tcp_latency     BIGINT,
+    rlpx_latency    BIGINT,
+    hello_latency   BIGINT,
+    status_latency  BIGINT,
+    PRIMARY KEY (id, tried_at)
+  ) PARTITION BY RANGE (tried_at);
+  ALTER SEQUENCE conn_attempts_id_seq OWNED BY conn_attempts.id;
+
+  CREATE TABLE conn_attempts_default PARTITION OF conn_attempts DEFAULT;
+  EXECUTE format('CREATE TABLE %I PARTITION OF conn_attempts FOR VALUES FROM (%L) TO (%L)',
+    'conn_attempts_p' || suffix, today, today + interval '1 day');
+
+  EXECUTE format('WITH moved AS (DELETE FROM %I WHERE tried_at >= %L RETURNING *) '
+    'INSERT INTO conn_attempts SELECT * FROM moved', legacy, today);
+  EXECUTE format('ALTER TABLE conn_attempts ATTACH PARTITION %I FOR VALUES FROM (MINVALUE) TO (%L)',
+    legacy, today);
+END $$;
+
+-- Daily summary of the attempts to each node, that the partitions are rolled up into
+-- once they are older than the retention window
+CREATE TABLE IF NOT EXISTS conn_attempts_daily (
+  node_id         TEXT NOT NULL,
+  day             DATE NOT NULL,
+  attempts        INT NOT NULL,
+  successes       INT NOT NULL,
+  errors          JSONB NOT NULL,
+  median_latency  BIGINT,
+  PRIMARY KEY (node_id, day)
+);
+
+-- Adds up the counts of two json objects, to merge the error histograms
+CREATE OR REPLACE FUNCTION jsonb_sum_counts(a JSONB, b JSONB) RETURNS JSONB AS $$
+  SELECT COALESCE(jsonb_object_agg(key, total), '{}'::JSONB)
+  FROM (
+    SELECT key, SUM(value::BIGINT) AS total
+    FROM (SELECT * FROM jsonb_each_text(a) UNION ALL SELECT * FROM jsonb_each_text(b)) AS counts
+    GROUP BY key
+  ) AS totals;
+$$ LANGUAGE SQL IMMUTABLE;
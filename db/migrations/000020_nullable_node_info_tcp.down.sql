@@ -0,0 +1,2 @@
+UPDATE node_info SET tcp = 0 WHERE tcp IS NULL;
+ALTER TABLE node_info ALTER COLUMN tcp SET NOT NULL;
@@ -0,0 +1,2 @@
+-- The nodes that dialed us are stored before knowing the port they listen at
+ALTER TABLE node_info ALTER COLUMN tcp DROP NOT NULL;
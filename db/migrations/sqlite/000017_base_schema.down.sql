@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS crawls;
+DROP TABLE IF EXISTS tx_announcements;
+DROP TABLE IF EXISTS tx_sightings;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS neighbours;
+DROP TABLE IF EXISTS conn_attempts;
+DROP TABLE IF EXISTS ip_info;
+DROP TABLE IF EXISTS active_peers;
+DROP TABLE IF EXISTS node_info;
+DROP TABLE IF EXISTS enrs;
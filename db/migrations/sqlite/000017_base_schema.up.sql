@@ -0,0 +1,156 @@
+-- SQLite schema equivalent to the Postgres one up to 000017_add_crawls, so that both
+-- backends share the version numbers of the next migrations.
+-- The arrays and json columns are stored as json text, and the tables without a serial
+-- primary key use the implicit rowid as their id
+
+CREATE TABLE IF NOT EXISTS enrs (
+    node_id TEXT PRIMARY KEY,
+    origin TEXT NOT NULL,
+    first_seen TIMESTAMP NOT NULL,
+    last_seen TIMESTAMP NOT NULL,
+    ip TEXT NOT NULL,
+    tcp INT NOT NULL,
+    udp INT NOT NULL,
+    seq BIGINT NOT NULL,
+    pubkey TEXT NOT NULL,
+    record TEXT NOT NULL,
+    score INT,
+    ip6 TEXT
+);
+
+CREATE TABLE IF NOT EXISTS node_info (
+    node_id TEXT PRIMARY KEY,
+    pubkey TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    tcp INT,
+    first_connected TIMESTAMP,
+    last_connected TIMESTAMP,
+    last_tried TIMESTAMP,
+    raw_user_agent TEXT,
+    capabilities TEXT,
+    software_info INT,
+    error TEXT,
+    deprecated BOOL,
+    client_name TEXT,
+    client_raw_version TEXT,
+    client_clean_version TEXT,
+    client_os TEXT,
+    client_arch TEXT,
+    client_language TEXT,
+    fork_id TEXT,
+    protocol_version INT,
+    head_hash TEXT,
+    network_id NUMERIC,
+    total_difficulty NUMERIC,
+    latency INT,
+    fork_class TEXT,
+    head_number BIGINT,
+    head_timestamp TIMESTAMP,
+    sync_status TEXT,
+    protocols TEXT,
+    snap_status TEXT,
+    ip_family TEXT
+);
+
+CREATE TABLE IF NOT EXISTS active_peers (
+    timestamp TIMESTAMP PRIMARY KEY,
+    peers TEXT
+);
+
+CREATE TABLE IF NOT EXISTS ip_info (
+    ip TEXT PRIMARY KEY,
+    expiration_time TIMESTAMP NOT NULL,
+    continent TEXT NOT NULL,
+    continent_code TEXT NOT NULL,
+    country TEXT NOT NULL,
+    country_code TEXT NOT NULL,
+    region TEXT NOT NULL,
+    region_name TEXT NOT NULL,
+    city TEXT NOT NULL,
+    zip TEXT NOT NULL,
+    lat REAL NOT NULL,
+    lon REAL NOT NULL,
+    isp TEXT NOT NULL,
+    org TEXT NOT NULL,
+    as_raw TEXT NOT NULL,
+    asname TEXT NOT NULL,
+    mobile BOOL NOT NULL,
+    proxy BOOL NOT NULL,
+    hosting BOOL NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS conn_attempts (
+    id             INTEGER PRIMARY KEY,
+    node_id        TEXT NOT NULL REFERENCES node_info(node_id),
+    tried_at       TIMESTAMP NOT NULL,
+    error          TEXT,
+    deprecated     BOOLEAN,
+    latency        BIGINT,
+    inbound        BOOLEAN NOT NULL DEFAULT false,
+    tcp_latency    BIGINT,
+    rlpx_latency   BIGINT,
+    hello_latency  BIGINT,
+    status_latency BIGINT
+);
+
+CREATE TABLE IF NOT EXISTS neighbours (
+    node_id       TEXT NOT NULL,
+    neighbour_id  TEXT NOT NULL,
+    seen_at       TIMESTAMP NOT NULL,
+    network_id    BIGINT NOT NULL,
+    PRIMARY KEY (node_id, neighbour_id, network_id)
+);
+
+CREATE INDEX IF NOT EXISTS neighbours_seen_at_idx ON neighbours (seen_at);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id                INTEGER PRIMARY KEY,
+    node_id           TEXT NOT NULL REFERENCES node_info(node_id),
+    started_at        TIMESTAMP NOT NULL,
+    ended_at          TIMESTAMP NOT NULL,
+    duration          BIGINT NOT NULL,
+    disconnect_reason TEXT,
+    local_disconnect  BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE INDEX IF NOT EXISTS sessions_node_id_idx ON sessions (node_id);
+CREATE INDEX IF NOT EXISTS sessions_started_at_idx ON sessions (started_at);
+
+CREATE TABLE IF NOT EXISTS tx_sightings (
+    hash          TEXT PRIMARY KEY,
+    first_seen    TIMESTAMP NOT NULL,
+    node_id       TEXT NOT NULL,
+    tx_type       SMALLINT,
+    size          BIGINT,
+    full_tx       BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE INDEX IF NOT EXISTS tx_sightings_first_seen_idx ON tx_sightings (first_seen);
+
+CREATE TABLE IF NOT EXISTS tx_announcements (
+    id            INTEGER PRIMARY KEY,
+    node_id       TEXT NOT NULL REFERENCES node_info(node_id),
+    window_start  TIMESTAMP NOT NULL,
+    window_end    TIMESTAMP NOT NULL,
+    messages      INT NOT NULL,
+    hashes        INT NOT NULL,
+    transactions  INT NOT NULL,
+    first_seen    INT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS tx_announcements_node_id_idx ON tx_announcements (node_id);
+CREATE INDEX IF NOT EXISTS tx_announcements_window_start_idx ON tx_announcements (window_start);
+
+CREATE TABLE IF NOT EXISTS crawls (
+    id            INTEGER PRIMARY KEY,
+    network_id    BIGINT NOT NULL,
+    started_at    TIMESTAMP NOT NULL,
+    ended_at      TIMESTAMP NOT NULL,
+    discovered    INT NOT NULL,
+    dialed        INT NOT NULL,
+    reachable     INT NOT NULL,
+    clients       TEXT NOT NULL,
+    complete      BOOLEAN NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS crawls_started_at_idx ON crawls (started_at);
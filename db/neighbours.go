@@ -0,0 +1,79 @@
+package db
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cortze/ragno/models"
+)
+
+func insertNeighbour(
+	source, neighbour enode.ID, seenAt time.Time, networkID uint64,
+) (query string, args []interface{}) {
+	log.Trace("Inserting new neighbour relationship")
+	query = `
+	INSERT INTO neighbours (
+		node_id,
+		neighbour_id,
+		seen_at,
+		network_id
+	) VALUES ($1,$2,$3,$4)
+	ON CONFLICT (node_id, neighbour_id, network_id) DO UPDATE SET
+		seen_at = EXCLUDED.seen_at;
+	`
+	args = append(args, source.String())
+	args = append(args, neighbour.String())
+	args = append(args, seenAt)
+	args = append(args, networkID)
+	return query, args
+}
+
+// PersistNeighbours queues each of the neighbours that the source node returned
+func (d *PostgresDBService) PersistNeighbours(neighbours *models.Neighbours) {
+	for _, neighbour := range neighbours.Neighbours {
+		p := NewPersistable()
+		p.query, p.values = insertNeighbour(neighbours.Source.ID(), neighbour.ID(), neighbours.Timestamp, d.networkID)
+		d.writeChan <- p
+	}
+}
+
+// GetNeighbourEdges returns the neighbour relationships seen in the network since the
+// given time, with the last time each of them was seen
+func (d *PostgresDBService) GetNeighbourEdges(since time.Time) ([]models.NeighbourEdge, error) {
+	query := `
+	SELECT
+		node_id,
+		neighbour_id,
+		seen_at
+	FROM neighbours
+	WHERE seen_at >= $1 AND network_id = $2;
+	`
+	edges := make([]models.NeighbourEdge, 0)
+	rows, err := d.psqlPool.Query(d.ctx, query, since, d.networkID)
+	if err != nil {
+		return edges, errors.Wrap(err, "unable to retrieve the neighbours")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		edge := models.NeighbourEdge{}
+		var sourceStr string
+		var neighbourStr string
+		err := rows.Scan(&sourceStr, &neighbourStr, &edge.SeenAt)
+		if err != nil {
+			return edges, errors.Wrap(err, "unable to parse the neighbours from db")
+		}
+		edge.Source, err = enode.ParseID(sourceStr)
+		if err != nil {
+			return edges, errors.Wrap(err, "unable to parse NodeID of a neighbour source")
+		}
+		edge.Neighbour, err = enode.ParseID(neighbourStr)
+		if err != nil {
+			return edges, errors.Wrap(err, "unable to parse NodeID of a neighbour")
+		}
+		edges = append(edges, edge)
+	}
+	return edges, rows.Err()
+}
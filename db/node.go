@@ -2,6 +2,7 @@ package db
 
 import (
 	"encoding/hex"
+	"time"
 
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/p2p/enode"
@@ -10,30 +11,50 @@ import (
 	"github.com/cortze/ragno/models"
 )
 
-func (d *PostgresDBService) insertConnectionAttempt(attempt models.ConnectionAttempt) (query string, args []interface{}) {
+func insertConnectionAttempt(attempt models.ConnectionAttempt) (query string, args []interface{}) {
 	query = `
 		INSERT INTO conn_attempts
-		(node_id, tried_at, error, deprecated, latency)
+		(node_id, tried_at, error, deprecated, latency, inbound,
+		tcp_latency, rlpx_latency, hello_latency, status_latency)
 		VALUES
-		($1, $2, $3, $4, $5);
+		($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
 	`
 	args = append(args, attempt.ID.String())
 	args = append(args, attempt.Timestamp)
 	args = append(args, attempt.Error)
 	args = append(args, attempt.Deprecable)
 	args = append(args, attempt.Latency.Milliseconds())
+	args = append(args, attempt.Inbound)
+	args = append(args, phaseLatencyArg(attempt.Timings.TCP))
+	args = append(args, phaseLatencyArg(attempt.Timings.RLPx))
+	args = append(args, phaseLatencyArg(attempt.Timings.Hello))
+	args = append(args, phaseLatencyArg(attempt.Timings.Status))
 
 	return query, args
 }
 
-func (d *PostgresDBService) updateNodeChainDetails(nInfo models.NodeInfo) (query string, args []interface{}) {
+// phaseLatencyArg returns the latency of a phase in milliseconds, or NULL if the phase
+// wasn't reached
+func phaseLatencyArg(latency time.Duration) interface{} {
+	if latency <= 0 {
+		return nil
+	}
+	return latency.Milliseconds()
+}
+
+func updateNodeChainDetails(nInfo models.NodeInfo) (query string, args []interface{}) {
 	query = `
 	UPDATE node_info SET
 	    fork_id = $2,
 		protocol_version = $3,
 		head_hash = $4,
 		network_id = $5,
-		total_difficulty = $6
+		total_difficulty = $6,
+		fork_class = $7,
+		head_number = $8,
+		head_timestamp = $9,
+		sync_status = $10,
+		snap_status = $11
 	WHERE node_id = $1;
 	`
 	args = append(args, nInfo.ID.String())
@@ -43,11 +64,17 @@ func (d *PostgresDBService) updateNodeChainDetails(nInfo models.NodeInfo) (query
 	args = append(args, hex.EncodeToString(nInfo.HeadHash.Bytes()))
 	args = append(args, nInfo.NetworkID)
 	args = append(args, nInfo.TotalDifficulty.Uint64())
+	args = append(args, nInfo.ForkClass.String())
+	headNumber, headTimestamp := headBlockArgs(nInfo.HeadBlock)
+	args = append(args, headNumber)
+	args = append(args, headTimestamp)
+	args = append(args, nInfo.SyncStatus.String())
+	args = append(args, nInfo.SnapStatus.String())
 
 	return query, args
 }
 
-func (d *PostgresDBService) upsertNodeInfo(nInfo models.NodeInfo, sameNetwork bool) (query string, args []interface{}) {
+func upsertNodeInfo(nInfo models.NodeInfo, sameNetwork bool) (query string, args []interface{}) {
 	query = `
 	INSERT INTO node_info(
 		node_id,
@@ -65,8 +92,10 @@ func (d *PostgresDBService) upsertNodeInfo(nInfo models.NodeInfo, sameNetwork bo
 		client_language,
 		capabilities,
 		software_info,
-		deprecated
-	) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16)
+		deprecated,
+		protocols,
+		ip_family
+	) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18)
 	ON CONFLICT (node_id) DO UPDATE SET
 		ip = $3,
 		tcp = $4,
@@ -81,7 +110,9 @@ func (d *PostgresDBService) upsertNodeInfo(nInfo models.NodeInfo, sameNetwork bo
 		client_language = $13,
 		capabilities = $14,
 		software_info = $15,
-		deprecated = $16;
+		deprecated = $16,
+		protocols = $17,
+		ip_family = $18;
 	`
 	clientDetails := models.ParseUserAgent(nInfo.ClientName)
 	capabilities := make([]string, len(nInfo.Capabilities))
@@ -109,23 +140,178 @@ func (d *PostgresDBService) upsertNodeInfo(nInfo models.NodeInfo, sameNetwork bo
 	args = append(args, nInfo.SoftwareInfo)
 	// control
 	args = append(args, !sameNetwork) // we identified the peer (un-deprecate it if the are in the same network)
+	args = append(args, protocolNames(nInfo.Protocols))
+	args = append(args, models.GetIPFamily(nInfo.IP).String())
 
 	return query, args
 }
 
-func (d *PostgresDBService) upsertHostInfoFromENR(hInfo *models.HostInfo) (query string, args []interface{}) {
+// upsertInboundNodeInfo identifies a node that dialed us and records the connection in the
+// same query, as the node might not be in node_info yet. We don't know the port the node
+// listens at, so the known ip and tcp of the node are kept (tcp is NULL for the new ones).
+// The chain details are only updated if the node sent its eth Status
+func upsertInboundNodeInfo(
+	attempt models.ConnectionAttempt, nInfo models.NodeInfo, sameNetwork bool,
+) (query string, args []interface{}) {
+	query = `
+	WITH upserted AS (
+		INSERT INTO node_info(
+			node_id,
+			pubkey,
+			ip,
+			tcp,
+			first_connected,
+			last_connected,
+			raw_user_agent,
+			client_name,
+			client_raw_version,
+			client_clean_version,
+			client_os,
+			client_arch,
+			client_language,
+			capabilities,
+			software_info,
+			deprecated,
+			protocols,
+			ip_family,
+			fork_id,
+			protocol_version,
+			head_hash,
+			network_id,
+			total_difficulty,
+			fork_class,
+			head_number,
+			head_timestamp,
+			sync_status,
+			snap_status
+		) VALUES($1,$2,$3,NULL,$4,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$18,$19,$20,$21,$22,$23,$24,$25,$26,$27)
+		ON CONFLICT (node_id) DO UPDATE SET
+			first_connected = COALESCE(node_info.first_connected, $4),
+			last_connected = $4,
+			raw_user_agent = $5,
+			client_name = $6,
+			client_raw_version = $7,
+			client_clean_version = $8,
+			client_os = $9,
+			client_arch = $10,
+			client_language = $11,
+			capabilities = $12,
+			software_info = $13,
+			deprecated = $14,
+			protocols = COALESCE($15, node_info.protocols),
+			fork_id = CASE WHEN $17 THEN $18 ELSE node_info.fork_id END,
+			protocol_version = CASE WHEN $17 THEN $19 ELSE node_info.protocol_version END,
+			head_hash = CASE WHEN $17 THEN $20 ELSE node_info.head_hash END,
+			network_id = CASE WHEN $17 THEN $21 ELSE node_info.network_id END,
+			total_difficulty = CASE WHEN $17 THEN $22 ELSE node_info.total_difficulty END,
+			fork_class = CASE WHEN $17 THEN $23 ELSE node_info.fork_class END,
+			head_number = CASE WHEN $17 THEN $24 ELSE node_info.head_number END,
+			head_timestamp = CASE WHEN $17 THEN $25 ELSE node_info.head_timestamp END,
+			sync_status = CASE WHEN $17 THEN $26 ELSE node_info.sync_status END,
+			snap_status = CASE WHEN $17 THEN $27 ELSE node_info.snap_status END
+		RETURNING node_id
+	)
+	INSERT INTO conn_attempts
+	(node_id, tried_at, error, deprecated, latency, inbound,
+	tcp_latency, rlpx_latency, hello_latency, status_latency)
+	SELECT node_id, $28, $29, $30, $31, true, $32, $33, $34, $35 FROM upserted;
+	`
+	clientDetails := models.ParseUserAgent(nInfo.ClientName)
+	capabilities := make([]string, len(nInfo.Capabilities))
+	for idx, cap := range nInfo.Capabilities {
+		capabilities[idx] = cap.String()
+	}
+	pubBytes := crypto.FromECDSAPub(nInfo.Pubkey)
+	pubKey := hex.EncodeToString(pubBytes)
+
+	args = append(args, nInfo.ID.String())
+	args = append(args, pubKey)
+	args = append(args, nInfo.IP)
+	args = append(args, nInfo.Timestamp)
+	// client info
+	args = append(args, clientDetails.RawClientName)
+	args = append(args, clientDetails.ClientName)
+	args = append(args, clientDetails.ClientVersion)
+	args = append(args, clientDetails.ClientCleanVersion)
+	args = append(args, clientDetails.ClientOS)
+	args = append(args, clientDetails.ClientArch)
+	args = append(args, clientDetails.ClientLanguage)
+	args = append(args, capabilities)
+	args = append(args, nInfo.SoftwareInfo)
+	args = append(args, !sameNetwork)
+	if len(nInfo.Protocols) > 0 {
+		args = append(args, protocolNames(nInfo.Protocols))
+	} else {
+		args = append(args, nil)
+	}
+	args = append(args, models.GetIPFamily(nInfo.IP).String())
+	// node chain status
+	hasStatus := !nInfo.ChainDetails.IsEmpty()
+	args = append(args, hasStatus)
+	if hasStatus {
+		totalDifficulty := uint64(0)
+		if nInfo.TotalDifficulty != nil {
+			totalDifficulty = nInfo.TotalDifficulty.Uint64()
+		}
+		headNumber, headTimestamp := headBlockArgs(nInfo.HeadBlock)
+		args = append(args, hex.EncodeToString([]byte(nInfo.ForkID.Hash[:])))
+		args = append(args, nInfo.ProtocolVersion)
+		args = append(args, hex.EncodeToString(nInfo.HeadHash.Bytes()))
+		args = append(args, nInfo.NetworkID)
+		args = append(args, totalDifficulty)
+		args = append(args, nInfo.ForkClass.String())
+		args = append(args, headNumber)
+		args = append(args, headTimestamp)
+		args = append(args, nInfo.SyncStatus.String())
+		args = append(args, nInfo.SnapStatus.String())
+	} else {
+		args = append(args, make([]interface{}, 10)...)
+	}
+	// connection attempt
+	args = append(args, attempt.Timestamp)
+	args = append(args, attempt.Error)
+	args = append(args, attempt.Deprecable)
+	args = append(args, attempt.Latency.Milliseconds())
+	args = append(args, phaseLatencyArg(attempt.Timings.TCP))
+	args = append(args, phaseLatencyArg(attempt.Timings.RLPx))
+	args = append(args, phaseLatencyArg(attempt.Timings.Hello))
+	args = append(args, phaseLatencyArg(attempt.Timings.Status))
+
+	return query, args
+}
+
+// protocolNames returns the negotiated subprotocols as "name/version" strings
+func protocolNames(protocols []models.Protocol) []string {
+	names := make([]string, len(protocols))
+	for idx, proto := range protocols {
+		names[idx] = proto.String()
+	}
+	return names
+}
+
+// headBlockArgs returns NULL values for the nodes whose head wasn't probed
+func headBlockArgs(head models.HeadBlock) (interface{}, interface{}) {
+	if head.IsEmpty() {
+		return nil, nil
+	}
+	return head.Number, head.Timestamp
+}
+
+func upsertHostInfoFromENR(hInfo *models.HostInfo) (query string, args []interface{}) {
 	query = `
 	INSERT INTO node_info(
 	    node_id,
 		pubkey,
 		ip,
 		tcp,
-		deprecated
-	) VALUES($1,$2,$3,$4,$5)
+		deprecated,
+		ip_family
+	) VALUES($1,$2,$3,$4,$5,$6)
 	ON CONFLICT (node_id) DO UPDATE SET
 		ip = $3,
 		tcp = $4,
-		deprecated = $5;
+		deprecated = $5,
+		ip_family = $6;
 	`
 	// get pubkey and nodeID
 	pubBytes := crypto.FromECDSAPub(hInfo.Pubkey)
@@ -137,6 +323,7 @@ func (d *PostgresDBService) upsertHostInfoFromENR(hInfo *models.HostInfo) (query
 	args = append(args, hInfo.IP)
 	args = append(args, hInfo.TCP)
 	args = append(args, false) // always set to false, as we found again the same ENR
+	args = append(args, models.GetIPFamily(hInfo.IP).String())
 
 	return query, args
 }
@@ -149,7 +336,7 @@ func (d *PostgresDBService) GetNonDeprecatedNodes(networkID uint64) ([]models.Ho
 		ip,
 		tcp
 	FROM node_info
-	WHERE deprecated='false' and (network_id=$1 or network_id IS NULL);
+	WHERE deprecated='false' and (network_id=$1 or network_id IS NULL) and tcp > 0;
 	`
 	nodes := make([]models.HostInfo, 0)
 	rows, err := d.psqlPool.Query(d.ctx, query, networkID)
@@ -179,22 +366,27 @@ func (d *PostgresDBService) GetNonDeprecatedNodes(networkID uint64) ([]models.Ho
 
 // PersistNodeInfo is the main method to persist the node info into the DB
 func (d *PostgresDBService) PersistNodeInfo(attempt models.ConnectionAttempt, nInfo models.NodeInfo, sameNetwork bool) {
-	// persist the attempt
-	pAttempt := NewPersistable()
-	pAttempt.query, pAttempt.values = d.insertConnectionAttempt(attempt)
-	d.writeChan <- pAttempt
+	// the attempts are append-only, so they are bulk copied
+	d.stageConnectionAttempt(attempt)
 
 	// check if the connection was successfull to record the connection
 	if attempt.Status == models.SuccessfulConnection {
 		pNinfo := NewPersistable()
-		pNinfo.query, pNinfo.values = d.upsertNodeInfo(nInfo, sameNetwork)
+		pNinfo.query, pNinfo.values = upsertNodeInfo(nInfo, sameNetwork)
 		d.writeChan <- pNinfo
 		// check if we have chain details
 		if nInfo.ChainDetails.IsEmpty() {
 			return
 		}
 		pChainD := NewPersistable()
-		pChainD.query, pChainD.values = d.updateNodeChainDetails(nInfo)
+		pChainD.query, pChainD.values = updateNodeChainDetails(nInfo)
 		d.writeChan <- pChainD
 	}
 }
+
+// PersistInboundNodeInfo persists the identification of a node that connected to us
+func (d *PostgresDBService) PersistInboundNodeInfo(attempt models.ConnectionAttempt, nInfo models.NodeInfo, sameNetwork bool) {
+	p := NewPersistable()
+	p.query, p.values = upsertInboundNodeInfo(attempt, nInfo, sameNetwork)
+	d.writeChan <- p
+}
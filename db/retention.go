@@ -0,0 +1,221 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ConnAttemptsRetention is how long the connection attempts are kept before rolling
+	// them up into conn_attempts_daily. They are kept forever if zero
+	ConnAttemptsRetention time.Duration
+	// NeighboursRetention is how long the neighbour relationships are kept since the last
+	// time they were seen. They are kept forever if zero
+	NeighboursRetention time.Duration
+	// PartitionsAhead is the number of days ahead that the daily partitions of
+	// conn_attempts are created for
+	PartitionsAhead   = 3
+	RetentionInterval = 1 * time.Hour
+)
+
+const (
+	dailyPartitionPrefix  = "conn_attempts_p"
+	legacyPartitionPrefix = "conn_attempts_legacy_" // all the attempts before the partitioning
+	defaultPartition      = "conn_attempts_default"
+	partitionDayFormat    = "20060102"
+)
+
+// runRetention periodically maintains the partitions of conn_attempts and prunes the
+// neighbours out of the retention window
+func (p *PostgresDBService) runRetention() {
+	ticker := time.NewTicker(RetentionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.maintainConnAttempts(); err != nil {
+				wlog.Error(err)
+			}
+			if err := p.pruneNeighbours(); err != nil {
+				wlog.Error(err)
+			}
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// maintainConnAttempts creates the daily partitions of the next days, and rolls up the
+// attempts older than the retention window (whole days) into conn_attempts_daily
+func (p *PostgresDBService) maintainConnAttempts() error {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for i := 0; i <= PartitionsAhead; i++ {
+		p.createDailyPartition(today.AddDate(0, 0, i))
+	}
+	if ConnAttemptsRetention <= 0 {
+		return nil
+	}
+	cutoff := time.Now().Add(-ConnAttemptsRetention).UTC().Truncate(24 * time.Hour)
+	partitions, err := p.connAttemptsPartitions()
+	if err != nil {
+		return err
+	}
+	for _, partition := range partitions {
+		end, ok := partitionEnd(partition)
+		if !ok || end.After(cutoff) {
+			continue
+		}
+		if err := p.rollupPartition(partition); err != nil {
+			return err
+		}
+	}
+	return p.rollupDefaultPartition(cutoff)
+}
+
+func (p *PostgresDBService) createDailyPartition(day time.Time) {
+	name := dailyPartitionPrefix + day.Format(partitionDayFormat)
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s PARTITION OF conn_attempts
+		FOR VALUES FROM ('%s') TO ('%s');`,
+		pgx.Identifier{name}.Sanitize(), day.Format(time.RFC3339), day.AddDate(0, 0, 1).Format(time.RFC3339))
+	if _, err := p.psqlPool.Exec(p.ctx, query); err != nil {
+		// i.e. the default partition already has attempts of that day
+		wlog.Warnf("unable to create the partition %s: %s", name, err.Error())
+	}
+}
+
+// connAttemptsPartitions returns the names of the partitions of conn_attempts
+func (p *PostgresDBService) connAttemptsPartitions() ([]string, error) {
+	partitions := make([]string, 0)
+	rows, err := p.psqlPool.Query(p.ctx, `
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'conn_attempts'::regclass;
+	`)
+	if err != nil {
+		return partitions, errors.Wrap(err, "unable to list the partitions of conn_attempts")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var partition string
+		if err := rows.Scan(&partition); err != nil {
+			return partitions, errors.Wrap(err, "error parsing readed row for partitions")
+		}
+		partitions = append(partitions, partition)
+	}
+	return partitions, rows.Err()
+}
+
+// partitionEnd returns the time up to which the partition keeps the attempts, from its
+// name. The default partition has no end
+func partitionEnd(partition string) (time.Time, bool) {
+	var end time.Time
+	var err error
+	switch {
+	case strings.HasPrefix(partition, dailyPartitionPrefix):
+		end, err = time.Parse(partitionDayFormat, strings.TrimPrefix(partition, dailyPartitionPrefix))
+		end = end.AddDate(0, 0, 1)
+	case strings.HasPrefix(partition, legacyPartitionPrefix):
+		end, err = time.Parse(partitionDayFormat, strings.TrimPrefix(partition, legacyPartitionPrefix))
+	default:
+		return end, false
+	}
+	return end, err == nil
+}
+
+// rollupPartition summarizes the attempts of the partition and drops it
+func (p *PostgresDBService) rollupPartition(partition string) error {
+	ctx, cancel := context.WithTimeout(p.ctx, QueryTimeout)
+	defer cancel()
+	t := time.Now()
+	table := pgx.Identifier{partition}.Sanitize()
+	err := p.psqlPool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, fmt.Sprintf(rollupConnAttempts, "SELECT * FROM "+table)); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, "DROP TABLE "+table+";")
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to roll up the partition "+partition)
+	}
+	wlog.Infof("rolled up the connection attempts of %s in %s", partition, time.Since(t))
+	return nil
+}
+
+// rollupDefaultPartition summarizes and deletes the attempts of the default partition
+// that are older than the cutoff
+func (p *PostgresDBService) rollupDefaultPartition(cutoff time.Time) error {
+	ctx, cancel := context.WithTimeout(p.ctx, QueryTimeout)
+	defer cancel()
+	source := "DELETE FROM " + defaultPartition + " WHERE tried_at < $1 RETURNING *"
+	_, err := p.psqlPool.Exec(ctx, fmt.Sprintf(rollupConnAttempts, source), cutoff)
+	return errors.Wrap(err, "unable to roll up the default partition")
+}
+
+// pruneNeighbours deletes the neighbour relationships that weren't seen again within the
+// retention window
+func (p *PostgresDBService) pruneNeighbours() error {
+	if NeighboursRetention <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(p.ctx, QueryTimeout)
+	defer cancel()
+	tag, err := p.psqlPool.Exec(ctx, `DELETE FROM neighbours WHERE seen_at < $1;`, time.Now().Add(-NeighboursRetention))
+	if err != nil {
+		return errors.Wrap(err, "unable to prune the neighbours")
+	}
+	wlog.Debugf("pruned %d neighbours not seen in the last %s", tag.RowsAffected(), NeighboursRetention)
+	return nil
+}
+
+// rollupConnAttempts adds up the attempts returned by the given query (%s) to the daily
+// summary of each node. The successful attempts are the ones without error ('none')
+const rollupConnAttempts = `
+	WITH rolled AS (
+		%s
+	), days AS (
+		SELECT
+			node_id,
+			(tried_at AT TIME ZONE 'UTC')::DATE AS day,
+			COUNT(*) AS attempts,
+			COUNT(*) FILTER (WHERE error = 'none') AS successes,
+			percentile_cont(0.5) WITHIN GROUP (ORDER BY latency) FILTER (WHERE error = 'none') AS median_latency
+		FROM rolled
+		GROUP BY 1, 2
+	), error_counts AS (
+		SELECT node_id, day, jsonb_object_agg(error, attempts) AS errors
+		FROM (
+			SELECT node_id, (tried_at AT TIME ZONE 'UTC')::DATE AS day, error, COUNT(*) AS attempts
+			FROM rolled
+			WHERE error IS NOT NULL AND error <> 'none'
+			GROUP BY 1, 2, 3
+		) AS counts
+		GROUP BY 1, 2
+	)
+	INSERT INTO conn_attempts_daily (node_id, day, attempts, successes, errors, median_latency)
+	SELECT
+		days.node_id,
+		days.day,
+		days.attempts,
+		days.successes,
+		COALESCE(error_counts.errors, '{}'::JSONB),
+		days.median_latency::BIGINT
+	FROM days
+	LEFT JOIN error_counts ON error_counts.node_id = days.node_id AND error_counts.day = days.day
+	ON CONFLICT (node_id, day) DO UPDATE SET
+		attempts = conn_attempts_daily.attempts + EXCLUDED.attempts,
+		successes = conn_attempts_daily.successes + EXCLUDED.successes,
+		errors = jsonb_sum_counts(conn_attempts_daily.errors, EXCLUDED.errors),
+		-- the medians can't be merged, the one of the larger sample is kept
+		median_latency = CASE
+			WHEN EXCLUDED.successes > conn_attempts_daily.successes THEN EXCLUDED.median_latency
+			ELSE conn_attempts_daily.median_latency
+		END;
+`
@@ -0,0 +1,23 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitionEnd(t *testing.T) {
+	end, ok := partitionEnd("conn_attempts_p20240131")
+	require.True(t, ok)
+	require.Equal(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), end)
+
+	end, ok = partitionEnd("conn_attempts_legacy_20240115")
+	require.True(t, ok)
+	require.Equal(t, time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), end)
+
+	_, ok = partitionEnd(defaultPartition)
+	require.False(t, ok)
+	_, ok = partitionEnd("conn_attempts_pnotaday")
+	require.False(t, ok)
+}
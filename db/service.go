@@ -36,15 +36,23 @@ type PostgresDBService struct {
 
 	writeChan chan Persistable // Receive persist requests
 	doneC     chan struct{}
+	flushCs   []chan chan struct{} // one per writer, see Flush
 	workerNum int
 
+	// append-only rows, bulk copied into the staging tables
+	copyChan   chan stagedRow
+	copyDone   chan struct{}
+	copyFlushC chan chan struct{}
+	wgCopier   sync.WaitGroup
+
 	snapshotInterval time.Duration // how often do active_peers get stored
+	networkID        uint64        // network the metrics and snapshots are filtered with
 }
 
 // Connect to the PostgreSQL Database and get the multithread-proof connection
 // from the given url-composed credentials
 func ConnectToDB(
-	ctx context.Context, url string, workerNum int, snapshotInterval time.Duration,
+	ctx context.Context, url string, workerNum int, snapshotInterval time.Duration, networkID uint64,
 ) (*PostgresDBService, error) {
 	// spliting the url to don't share any confidential information on wlogs
 	wlog.Infof("Connecting to postgres DB %s", url)
@@ -58,8 +66,6 @@ func ConnectToDB(
 	if strings.Contains(url, "@") {
 		wlog.Infof("PostgresDB %s succesfully connected", strings.Split(url, "@")[1])
 	}
-	// filter the type of network that we are filtering
-
 	psqlDB := &PostgresDBService{
 		ctx:              ctx,
 		connectionUrl:    url,
@@ -67,20 +73,35 @@ func ConnectToDB(
 		writeChan:        make(chan Persistable, workerNum),
 		workerNum:        workerNum,
 		doneC:            make(chan struct{}),
+		flushCs:          make([]chan chan struct{}, workerNum),
+		copyChan:         make(chan stagedRow, MAX_COPY_QUEUE),
+		copyDone:         make(chan struct{}),
+		copyFlushC:       make(chan chan struct{}),
 		snapshotInterval: snapshotInterval,
+		networkID:        networkID,
+	}
+	for i := range psqlDB.flushCs {
+		psqlDB.flushCs[i] = make(chan chan struct{})
 	}
 	// init the psql db
 	err = psqlDB.init(ctx, psqlDB.psqlPool)
 	if err != nil {
 		return psqlDB, errors.Wrap(err, "error initializing the tables of the psqldb")
 	}
+	// the partitions of the attempts have to exist before the first ones are persisted
+	if err := psqlDB.maintainConnAttempts(); err != nil {
+		wlog.Error(err)
+	}
+	go psqlDB.runRetention()
 	go psqlDB.snapshotActivePeers()
 	go psqlDB.runWriters()
+	psqlDB.wgCopier.Add(1)
+	go psqlDB.runCopier()
 	return psqlDB, err
 }
 
 func (p *PostgresDBService) init(ctx context.Context, pool *pgxpool.Pool) error {
-	return p.makeMigrations()
+	return makeMigrations(p.connectionUrl)
 }
 
 func (p *PostgresDBService) snapshotActivePeers() {
@@ -105,18 +126,57 @@ func (p *PostgresDBService) snapshotActivePeers() {
 
 func (p *PostgresDBService) Finish() {
 	for i := 0; i < p.workerNum; i++ {
-		p.doneC <- struct{}{}
+		// the writers already returned if the context was canceled
+		select {
+		case p.doneC <- struct{}{}:
+		case <-p.ctx.Done():
+		}
 	}
 	p.wgDBWriters.Wait()
+	// the staged attempts are merged once the nodes they reference are persisted
+	select {
+	case p.copyDone <- struct{}{}:
+	case <-p.ctx.Done():
+	}
+	p.wgCopier.Wait()
 	p.psqlPool.Close()
 	close(p.writeChan)
 }
 
+// Flush blocks until the persists queued so far are written, and the staged rows merged
+// into their tables
+func (p *PostgresDBService) Flush() {
+	// the writers first, as the staged attempts are only merged once their nodes are stored
+	for _, flushC := range p.flushCs {
+		flushed := make(chan struct{})
+		select {
+		case flushC <- flushed:
+		case <-p.ctx.Done():
+			return
+		}
+		select {
+		case <-flushed:
+		case <-p.ctx.Done():
+			return
+		}
+	}
+	flushed := make(chan struct{})
+	select {
+	case p.copyFlushC <- flushed:
+	case <-p.ctx.Done():
+		return
+	}
+	select {
+	case <-flushed:
+	case <-p.ctx.Done():
+	}
+}
+
 func (p *PostgresDBService) runWriters() {
 	wlog.Infof("Launching %d ELNode Writers", p.workerNum)
 	for i := 0; i < p.workerNum; i++ {
 		p.wgDBWriters.Add(1)
-		go func(dbWriterID int) {
+		go func(dbWriterID int, flushC chan chan struct{}) {
 			defer p.wgDBWriters.Done()
 			batcher := NewQueryBatch(p.ctx, p.psqlPool, MAX_BATCH_QUEUE)
 			wlogWriter := wlog.WithField("db-writer", dbWriterID)
@@ -132,14 +192,34 @@ func (p *PostgresDBService) runWriters() {
 					if batcher.IsReadyToPersist() {
 						err := batcher.PersistBatch()
 						if err != nil {
-							wlogWriter.Error("Error processing batch", err.Error())
+							wlogWriter.Errorf("error processing batch: %s", err)
 						}
 					}
+				case flushed := <-flushC:
+					// the other writers could take part of the queued ones, they are
+					// flushed next
+				drain:
+					for {
+						select {
+						case persis := <-p.writeChan:
+							if !persis.isEmpty() {
+								batcher.AddQuery(persis)
+							}
+						default:
+							break drain
+						}
+					}
+					err := batcher.PersistBatch()
+					if err != nil {
+						wlogWriter.Errorf("error processing batch: %s", err)
+					}
+					close(flushed)
+
 				case <-p.doneC:
 					wlog.Tracef("flushing batcher")
 					err := batcher.PersistBatch()
 					if err != nil {
-						wlogWriter.Error("Error processing batch", err.Error())
+						wlogWriter.Errorf("error processing batch: %s", err)
 					}
 					return
 
@@ -152,11 +232,11 @@ func (p *PostgresDBService) runWriters() {
 						wlog.Tracef("flushing batcher")
 						err := batcher.PersistBatch()
 						if err != nil {
-							wlogWriter.Errorf("Error processing batch", err.Error())
+							wlogWriter.Errorf("error processing batch: %s", err)
 						}
 					}
 				}
 			}
-		}(i)
+		}(i, p.flushCs[i])
 	}
 }
@@ -0,0 +1,35 @@
+package db
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cortze/ragno/models"
+)
+
+func insertSession(session *models.Session) (query string, args []interface{}) {
+	log.Trace("Inserting new session")
+	query = `
+	INSERT INTO sessions (
+		node_id,
+		started_at,
+		ended_at,
+		duration,
+		disconnect_reason,
+		local_disconnect
+	) VALUES ($1,$2,$3,$4,$5,$6);
+	`
+	args = append(args, session.ID.String())
+	args = append(args, session.Start)
+	args = append(args, session.End)
+	args = append(args, int64(session.Duration().Seconds()))
+	args = append(args, session.DisconnectReason)
+	args = append(args, session.LocalDisconnect)
+	return query, args
+}
+
+// PersistSession queues a finished session with a node
+func (d *PostgresDBService) PersistSession(session *models.Session) {
+	p := NewPersistable()
+	p.query, p.values = insertSession(session)
+	d.writeChan <- p
+}
@@ -0,0 +1,277 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var (
+	SQLiteType = "sqlite-db"
+	slog       = logrus.WithField(
+		"module", SQLiteType,
+	)
+	// the times are written in UTC with the sqlite format, so that they compare as text
+	sqliteParams = "?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_time_format=sqlite&_txlock=immediate"
+)
+
+// SQLiteDBService stores the crawl in a single SQLite file (pure-Go driver), for small
+// studies and CI runs that don't have a Postgres at hand
+type SQLiteDBService struct {
+	// Control Variables
+	ctx       context.Context
+	path      string
+	db        *sql.DB
+	wgWriter  sync.WaitGroup
+	writeChan chan Persistable // Receive persist requests
+	doneC     chan struct{}
+	flushC    chan chan struct{}
+
+	snapshotInterval time.Duration // how often do active_peers get stored
+	networkID        uint64        // network the metrics and snapshots are filtered with
+}
+
+// ConnectToSQLite opens (or creates) the SQLite file at the given path and applies the
+// migrations. SQLite only allows one writer at a time, so the persists are written by a
+// single routine, buffering up to workerNum of them
+func ConnectToSQLite(
+	ctx context.Context, path string, workerNum int, snapshotInterval time.Duration, networkID uint64,
+) (*SQLiteDBService, error) {
+	slog.Infof("Opening sqlite DB %s", path)
+	sqliteDB := &SQLiteDBService{
+		ctx:              ctx,
+		path:             path,
+		writeChan:        make(chan Persistable, workerNum),
+		doneC:            make(chan struct{}),
+		flushC:           make(chan chan struct{}),
+		snapshotInterval: snapshotInterval,
+		networkID:        networkID,
+	}
+	err := makeMigrations(SQLitePrefix + path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error initializing the tables of the sqlite db")
+	}
+	sqliteDB.db, err = sql.Open("sqlite", path+sqliteParams)
+	if err != nil {
+		return nil, err
+	}
+	if err := sqliteDB.db.PingContext(ctx); err != nil {
+		sqliteDB.db.Close()
+		return nil, errors.Wrap(err, "unable to open the sqlite db")
+	}
+	go sqliteDB.snapshotActivePeers()
+	sqliteDB.wgWriter.Add(1)
+	go sqliteDB.runWriter()
+	return sqliteDB, nil
+}
+
+func (s *SQLiteDBService) snapshotActivePeers() {
+	// make a first backup of the active peers(if any)
+	err := s.PersistActivePeers()
+	if err != nil {
+		logrus.Error(err)
+	}
+	ticker := time.NewTicker(s.snapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := s.PersistActivePeers()
+			if err != nil {
+				logrus.Error(err)
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *SQLiteDBService) Finish() {
+	// the writer already returned if the context was canceled
+	select {
+	case s.doneC <- struct{}{}:
+	case <-s.ctx.Done():
+	}
+	s.wgWriter.Wait()
+	s.db.Close()
+	close(s.writeChan)
+}
+
+// Flush blocks until the persists queued so far are written
+func (s *SQLiteDBService) Flush() {
+	flushed := make(chan struct{})
+	select {
+	case s.flushC <- flushed:
+	case <-s.ctx.Done():
+		return
+	}
+	select {
+	case <-flushed:
+	case <-s.ctx.Done():
+	}
+}
+
+func (s *SQLiteDBService) runWriter() {
+	slog.Info("Launching the sqlite writer")
+	defer s.wgWriter.Done()
+	batcher := NewSQLiteBatch(s.ctx, s.db, MAX_BATCH_QUEUE)
+	ticker := time.NewTicker(RoutineFlushTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case persis := <-s.writeChan:
+			if !persis.isEmpty() {
+				batcher.AddQuery(persis)
+			}
+			if batcher.IsReadyToPersist() {
+				err := batcher.PersistBatch()
+				if err != nil {
+					slog.Errorf("error processing batch: %s", err)
+				}
+			}
+		case flushed := <-s.flushC:
+			for len(s.writeChan) > 0 {
+				persis := <-s.writeChan
+				if !persis.isEmpty() {
+					batcher.AddQuery(persis)
+				}
+			}
+			err := batcher.PersistBatch()
+			if err != nil {
+				slog.Errorf("error processing batch: %s", err)
+			}
+			close(flushed)
+
+		case <-s.doneC:
+			// persist what is still queued before closing
+			for len(s.writeChan) > 0 {
+				persis := <-s.writeChan
+				if !persis.isEmpty() {
+					batcher.AddQuery(persis)
+				}
+			}
+			err := batcher.PersistBatch()
+			if err != nil {
+				slog.Errorf("error processing batch: %s", err)
+			}
+			return
+
+		case <-s.ctx.Done():
+			return
+
+		case <-ticker.C:
+			if batcher.IsReadyToPersist() || (len(s.writeChan) == 0 && batcher.Len() > 0) {
+				err := batcher.PersistBatch()
+				if err != nil {
+					slog.Errorf("error processing batch: %s", err)
+				}
+			}
+		}
+	}
+}
+
+// SQLiteBatch writes the queued persistables in a single transaction
+type SQLiteBatch struct {
+	ctx          context.Context
+	db           *sql.DB
+	size         int
+	persistables []Persistable
+}
+
+func NewSQLiteBatch(ctx context.Context, db *sql.DB, batchSize int) *SQLiteBatch {
+	return &SQLiteBatch{
+		ctx:          ctx,
+		db:           db,
+		size:         batchSize,
+		persistables: make([]Persistable, 0),
+	}
+}
+
+func (b *SQLiteBatch) IsReadyToPersist() bool {
+	return len(b.persistables) >= b.size
+}
+
+func (b *SQLiteBatch) AddQuery(persis Persistable) {
+	b.persistables = append(b.persistables, persis)
+}
+
+func (b *SQLiteBatch) Len() int {
+	return len(b.persistables)
+}
+
+// PersistBatch persists the queued queries, dropping only the ones that can't be
+// persisted (see persistIsolating)
+func (b *SQLiteBatch) PersistBatch() error {
+	defer func() {
+		b.persistables = make([]Persistable, 0)
+	}()
+	slog.Debugf("persisting batch of queries with len(%d)", b.Len())
+	return persistIsolating(b.ctx, b.persistables, b.persistBatch, isTransientSQLiteError)
+}
+
+// persistBatch writes the persistables in a single transaction
+func (b *SQLiteBatch) persistBatch(persistables []Persistable) error {
+	ctx, cancel := context.WithTimeout(b.ctx, QueryTimeout)
+	defer cancel()
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, persis := range persistables {
+		_, err := tx.ExecContext(ctx, persis.query, sqliteArgs(persis.values)...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// isTransientSQLiteError returns whether the error comes from the database being busy
+// or locked by another connection, the rest of errors are permanent
+func isTransientSQLiteError(err error) bool {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() & 0xff {
+		case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+			return true
+		}
+		return false
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// sqliteArgs adapts the args of the shared query builders to what SQLite can store: the
+// arrays and maps are stored as json, the times in UTC, and the uint64 that don't fit in
+// an int64 as text
+func sqliteArgs(args []interface{}) []interface{} {
+	adapted := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case time.Time:
+			adapted[i] = v.UTC()
+		case []string, []int, map[string]int:
+			encoded, _ := json.Marshal(v)
+			adapted[i] = string(encoded)
+		case uint64:
+			if v > math.MaxInt64 {
+				adapted[i] = strconv.FormatUint(v, 10)
+			} else {
+				adapted[i] = int64(v)
+			}
+		default:
+			adapted[i] = arg
+		}
+	}
+	return adapted
+}
@@ -0,0 +1,645 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cortze/ragno/models"
+)
+
+// The persists reuse the query builders of the Postgres backend, which SQLite understands
+// once the args are adapted (see sqliteArgs). Only the reads that rely on Postgres
+// functions or types are written again for SQLite
+
+func (s *SQLiteDBService) PersistENR(enr *models.ENR) {
+	p := NewPersistable()
+	p.query, p.values = insertENR(enr)
+	s.writeChan <- p
+	// insert new row at node_info with host_info
+	p = NewPersistable()
+	p.query, p.values = upsertHostInfoFromENR(enr.GetHostInfo())
+	s.writeChan <- p
+}
+
+func (s *SQLiteDBService) PersistNeighbours(neighbours *models.Neighbours) {
+	for _, neighbour := range neighbours.Neighbours {
+		p := NewPersistable()
+		p.query, p.values = insertNeighbour(neighbours.Source.ID(), neighbour.ID(), neighbours.Timestamp, s.networkID)
+		s.writeChan <- p
+	}
+}
+
+func (s *SQLiteDBService) PersistNodeInfo(attempt models.ConnectionAttempt, nInfo models.NodeInfo, sameNetwork bool) {
+	pAttempt := NewPersistable()
+	pAttempt.query, pAttempt.values = insertConnectionAttempt(attempt)
+	s.writeChan <- pAttempt
+
+	if attempt.Status == models.SuccessfulConnection {
+		pNinfo := NewPersistable()
+		pNinfo.query, pNinfo.values = upsertNodeInfo(nInfo, sameNetwork)
+		s.writeChan <- pNinfo
+		if nInfo.ChainDetails.IsEmpty() {
+			return
+		}
+		pChainD := NewPersistable()
+		pChainD.query, pChainD.values = updateNodeChainDetails(nInfo)
+		s.writeChan <- pChainD
+	}
+}
+
+// SQLite doesn't support the INSERT inside the WITH of the Postgres query, so the node and
+// the attempt are written by two statements that take the same args (as the single writer
+// keeps their order)
+const (
+	sqliteUpsertInboundNodeInfo = `
+	INSERT INTO node_info(
+		node_id,
+		pubkey,
+		ip,
+		tcp,
+		first_connected,
+		last_connected,
+		raw_user_agent,
+		client_name,
+		client_raw_version,
+		client_clean_version,
+		client_os,
+		client_arch,
+		client_language,
+		capabilities,
+		software_info,
+		deprecated,
+		protocols,
+		ip_family,
+		fork_id,
+		protocol_version,
+		head_hash,
+		network_id,
+		total_difficulty,
+		fork_class,
+		head_number,
+		head_timestamp,
+		sync_status,
+		snap_status
+	) VALUES($1,$2,$3,NULL,$4,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$18,$19,$20,$21,$22,$23,$24,$25,$26,$27)
+	ON CONFLICT (node_id) DO UPDATE SET
+		first_connected = COALESCE(node_info.first_connected, $4),
+		last_connected = $4,
+		raw_user_agent = $5,
+		client_name = $6,
+		client_raw_version = $7,
+		client_clean_version = $8,
+		client_os = $9,
+		client_arch = $10,
+		client_language = $11,
+		capabilities = $12,
+		software_info = $13,
+		deprecated = $14,
+		protocols = COALESCE($15, node_info.protocols),
+		fork_id = CASE WHEN $17 THEN $18 ELSE node_info.fork_id END,
+		protocol_version = CASE WHEN $17 THEN $19 ELSE node_info.protocol_version END,
+		head_hash = CASE WHEN $17 THEN $20 ELSE node_info.head_hash END,
+		network_id = CASE WHEN $17 THEN $21 ELSE node_info.network_id END,
+		total_difficulty = CASE WHEN $17 THEN $22 ELSE node_info.total_difficulty END,
+		fork_class = CASE WHEN $17 THEN $23 ELSE node_info.fork_class END,
+		head_number = CASE WHEN $17 THEN $24 ELSE node_info.head_number END,
+		head_timestamp = CASE WHEN $17 THEN $25 ELSE node_info.head_timestamp END,
+		sync_status = CASE WHEN $17 THEN $26 ELSE node_info.sync_status END,
+		snap_status = CASE WHEN $17 THEN $27 ELSE node_info.snap_status END;
+	`
+	sqliteInsertInboundConnAttempt = `
+	INSERT INTO conn_attempts
+	(node_id, tried_at, error, deprecated, latency, inbound,
+	tcp_latency, rlpx_latency, hello_latency, status_latency)
+	VALUES ($1, $28, $29, $30, $31, true, $32, $33, $34, $35);
+	`
+)
+
+func (s *SQLiteDBService) PersistInboundNodeInfo(attempt models.ConnectionAttempt, nInfo models.NodeInfo, sameNetwork bool) {
+	_, args := upsertInboundNodeInfo(attempt, nInfo, sameNetwork)
+	s.writeChan <- Persistable{query: sqliteUpsertInboundNodeInfo, values: args}
+	s.writeChan <- Persistable{query: sqliteInsertInboundConnAttempt, values: args}
+}
+
+func (s *SQLiteDBService) PersistSession(session *models.Session) {
+	p := NewPersistable()
+	p.query, p.values = insertSession(session)
+	s.writeChan <- p
+}
+
+func (s *SQLiteDBService) PersistTxSamples(samples *models.TxSamples) {
+	log.Tracef("Persisting %d tx sightings", len(samples.Sightings))
+	for _, sighting := range samples.Sightings {
+		p := NewPersistable()
+		p.query, p.values = insertTxSighting(sighting)
+		s.writeChan <- p
+	}
+	for _, stats := range samples.Stats {
+		p := NewPersistable()
+		p.query, p.values = insertPeerTxStats(stats)
+		s.writeChan <- p
+	}
+}
+
+func (s *SQLiteDBService) PersistIPInfo(ip models.IPInfo) {
+	p := NewPersistable()
+	p.query, p.values = upsertIPInfo(ip)
+	s.writeChan <- p
+}
+
+func (s *SQLiteDBService) PersistActivePeers() error {
+	log.Debug("making backup in DB of the actual active peers")
+	rows, err := s.query(`
+		SELECT rowid
+		FROM node_info
+		WHERE deprecated = false AND
+		first_connected IS NOT NULL AND
+		network_id = $2 AND
+		client_name IS NOT NULL AND
+		last_connected > $1
+	`, activeSince(), s.networkID)
+	if err != nil {
+		return errors.Wrap(err, "unable to backup active peers")
+	}
+	defer rows.Close()
+	activePeers := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return errors.Wrap(err, "unable to retrieve active peer's ids")
+		}
+		activePeers = append(activePeers, id)
+	}
+	if len(activePeers) <= 0 {
+		log.Infof("tried to persist %d active peers (skipped)", len(activePeers))
+		return nil
+	}
+	p := NewPersistable()
+	p.query, p.values = insertActivePeers(activePeers)
+	s.writeChan <- p
+	return nil
+}
+
+func (s *SQLiteDBService) InsertCrawl(crawl *models.Crawl) error {
+	log.Debug("inserting the summary of the crawl")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	query, args := insertCrawl(crawl)
+	_, err := s.db.ExecContext(ctx, query, sqliteArgs(args)...)
+	if err != nil {
+		return errors.Wrap(err, "unable to insert the crawl summary")
+	}
+	return nil
+}
+
+func (s *SQLiteDBService) GetSeedENRs(origins []string, limit int) ([]string, error) {
+	records := make([]string, 0)
+	rows, err := s.query(`
+	SELECT
+		enrs.record
+	FROM enrs
+	LEFT JOIN node_info ON enrs.node_id = node_info.node_id
+	WHERE enrs.origin IN (SELECT value FROM json_each($1)) AND node_info.deprecated IS NOT TRUE
+	ORDER BY enrs.last_seen DESC
+	LIMIT $2;
+	`, origins, limit)
+	if err != nil {
+		return records, errors.Wrap(err, "unable to retrieve the seed enrs")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var record string
+		if err := rows.Scan(&record); err != nil {
+			return records, errors.Wrap(err, "unable to parse the seed enrs from db")
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (s *SQLiteDBService) GetNonDeprecatedNodes(networkID uint64) ([]models.HostInfo, error) {
+	nodes := make([]models.HostInfo, 0)
+	rows, err := s.query(`
+	SELECT
+		node_id,
+		pubkey,
+		ip,
+		tcp
+	FROM node_info
+	WHERE deprecated = false and (network_id=$1 or network_id IS NULL) and tcp > 0;
+	`, networkID)
+	if err != nil {
+		return nodes, errors.Wrap(err, "unable to retrieve the non-deprecated nodes")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		hInfo := models.HostInfo{}
+		var nodeIDstr string
+		var pubkeyStr string
+		err := rows.Scan(&nodeIDstr, &pubkeyStr, &hInfo.IP, &hInfo.TCP)
+		if err != nil {
+			return nodes, errors.Wrap(err, "unable to parse the non-deprecated nodes from db")
+		}
+		hInfo.ID, err = enode.ParseID(nodeIDstr)
+		if err != nil {
+			return nodes, errors.Wrap(err, "unable to parse NodeID of a non-deprecated node")
+		}
+		hInfo.Pubkey, err = models.StringToPubkey(pubkeyStr)
+		if err != nil {
+			return nodes, errors.Wrap(err, "unable to parse Pubkey of a non-deprecated node")
+		}
+		nodes = append(nodes, hInfo)
+	}
+	return nodes, rows.Err()
+}
+
+func (s *SQLiteDBService) GetNeighbourEdges(since time.Time) ([]models.NeighbourEdge, error) {
+	edges := make([]models.NeighbourEdge, 0)
+	rows, err := s.query(`
+	SELECT
+		node_id,
+		neighbour_id,
+		seen_at
+	FROM neighbours
+	WHERE seen_at >= $1 AND network_id = $2;
+	`, since, s.networkID)
+	if err != nil {
+		return edges, errors.Wrap(err, "unable to retrieve the neighbours")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		edge := models.NeighbourEdge{}
+		var sourceStr, neighbourStr string
+		err := rows.Scan(&sourceStr, &neighbourStr, &edge.SeenAt)
+		if err != nil {
+			return edges, errors.Wrap(err, "unable to parse the neighbours from db")
+		}
+		edge.Source, err = enode.ParseID(sourceStr)
+		if err != nil {
+			return edges, errors.Wrap(err, "unable to parse NodeID of a neighbour source")
+		}
+		edge.Neighbour, err = enode.ParseID(neighbourStr)
+		if err != nil {
+			return edges, errors.Wrap(err, "unable to parse NodeID of a neighbour")
+		}
+		edges = append(edges, edge)
+	}
+	return edges, rows.Err()
+}
+
+func (s *SQLiteDBService) GetIPInfo(ip string) (models.IPInfo, error) {
+	var ipInfo models.IPInfo
+	err := s.db.QueryRowContext(s.ctx, `
+		SELECT
+			ip,
+			expiration_time,
+			continent,
+			continent_code,
+			country,
+			country_code,
+			region,
+			region_name,
+			city,
+			zip,
+			lat,
+			lon,
+			isp,
+			org,
+			as_raw,
+			asname,
+			mobile,
+			proxy,
+			hosting
+		FROM ip_info
+		WHERE ip=$1
+	`, ip).Scan(
+		&ipInfo.IP,
+		&ipInfo.ExpirationTime,
+		&ipInfo.Continent,
+		&ipInfo.ContinentCode,
+		&ipInfo.Country,
+		&ipInfo.CountryCode,
+		&ipInfo.Region,
+		&ipInfo.RegionName,
+		&ipInfo.City,
+		&ipInfo.Zip,
+		&ipInfo.Lat,
+		&ipInfo.Lon,
+		&ipInfo.Isp,
+		&ipInfo.Org,
+		&ipInfo.As,
+		&ipInfo.AsName,
+		&ipInfo.Mobile,
+		&ipInfo.Proxy,
+		&ipInfo.Hosting,
+	)
+	if err != nil {
+		return models.IPInfo{}, err
+	}
+	return ipInfo, nil
+}
+
+func (s *SQLiteDBService) CheckIPRecords(ip string) (exists bool, expired bool, err error) {
+	var readIp string
+	var expTime time.Time
+	err = s.db.QueryRowContext(s.ctx, `
+		SELECT
+			ip,
+			expiration_time
+		FROM ip_info
+		WHERE ip=$1;
+	`, ip).Scan(&readIp, &expTime)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	} else if err != nil {
+		return
+	}
+	exists = (readIp == ip)
+	expired = (expTime.Before(time.Now()))
+	return
+}
+
+func (s *SQLiteDBService) GetExpiredIPInfo() ([]string, error) {
+	expIPs := make([]string, 0)
+	rows, err := s.query(`
+		SELECT ip
+		FROM ip_info
+		WHERE expiration_time < $1;
+	`, time.Now())
+	if err != nil {
+		return expIPs, errors.Wrap(err, "unable to get expired ip records")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return expIPs, errors.Wrap(err, "error parsing readed row for expired ip records")
+		}
+		expIPs = append(expIPs, ip)
+	}
+	return expIPs, rows.Err()
+}
+
+func (s *SQLiteDBService) GetIPASNs() (map[string]string, error) {
+	asns := make(map[string]string)
+	rows, err := s.query(`
+		SELECT ip, substr(as_raw, 1, instr(as_raw || ' ', ' ') - 1)
+		FROM ip_info
+		WHERE as_raw <> '';
+	`)
+	if err != nil {
+		return asns, errors.Wrap(err, "unable to get the asn of the ips")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ip, asn string
+		if err := rows.Scan(&ip, &asn); err != nil {
+			return asns, errors.Wrap(err, "error parsing readed row for ip asns")
+		}
+		asns[ip] = asn
+	}
+	return asns, rows.Err()
+}
+
+// --- metrics ---
+
+// the nodes identified in the crawled network during the last LastActivityValidRange days
+const sqliteActiveNodes = `
+	first_connected IS NOT NULL AND
+	network_id = $2 AND
+	deprecated = false AND
+	client_name IS NOT NULL AND
+	last_connected > $1`
+
+func (s *SQLiteDBService) GetClientDistribution() (map[string]interface{}, error) {
+	log.Debug("fetching client distribution metrics")
+	return s.countBy(`
+		SELECT client_name, count(client_name) as cnt
+		FROM node_info
+		WHERE `+sqliteActiveNodes+`
+		GROUP BY client_name
+		ORDER BY cnt DESC;
+	`, "client distribution")
+}
+
+func (s *SQLiteDBService) GetVersionDistribution() (map[string]interface{}, error) {
+	log.Debug("fetching client version distribution metrics")
+	return s.countBy(`
+		SELECT client_name || '_' || client_raw_version, count(client_raw_version) as cnt
+		FROM node_info
+		WHERE `+sqliteActiveNodes+`
+		GROUP BY client_name, client_raw_version
+		ORDER BY client_name DESC, cnt DESC;
+	`, "version distribution")
+}
+
+func (s *SQLiteDBService) GetGeoDistribution() (map[string]interface{}, error) {
+	log.Debug("fetching geo distribution metrics")
+	return s.countBy(`
+		SELECT ip_info.country_code, count(ip_info.country_code) as cnt
+		FROM node_info
+		INNER JOIN ip_info ON node_info.ip = ip_info.ip
+		WHERE `+sqliteActiveNodes+`
+		GROUP BY ip_info.country_code
+		ORDER BY cnt DESC;
+	`, "geo distribution")
+}
+
+func (s *SQLiteDBService) GetOsDistribution() (map[string]interface{}, error) {
+	return s.countBy(`
+		SELECT client_os, count(client_os) as cnt
+		FROM node_info
+		WHERE `+sqliteActiveNodes+`
+		GROUP BY client_os
+		ORDER BY cnt DESC;
+	`, "os distribution")
+}
+
+func (s *SQLiteDBService) GetArchDistribution() (map[string]interface{}, error) {
+	return s.countBy(`
+		SELECT client_arch, count(client_arch) as cnt
+		FROM node_info
+		WHERE `+sqliteActiveNodes+`
+		GROUP BY client_arch
+		ORDER BY cnt DESC;
+	`, "arch distribution")
+}
+
+func (s *SQLiteDBService) GetHostingDistribution() (map[string]interface{}, error) {
+	summary := make(map[string]interface{})
+	var mobile, proxy, hosted int
+	err := s.db.QueryRowContext(s.ctx, `
+		SELECT
+			COALESCE(SUM(ip_info.mobile), 0),
+			COALESCE(SUM(ip_info.proxy), 0),
+			COALESCE(SUM(ip_info.hosting), 0)
+		FROM node_info
+		INNER JOIN ip_info ON node_info.ip = ip_info.ip
+		WHERE `+sqliteActiveNodes+`;
+	`, sqliteArgs([]interface{}{activeSince(), s.networkID})...).Scan(&mobile, &proxy, &hosted)
+	if err != nil {
+		return summary, errors.Wrap(err, "unable to fetch hosting distribution")
+	}
+	summary["mobile_ip_info"] = mobile
+	summary["under_proxy"] = proxy
+	summary["hosted_ip_info"] = hosted
+	return summary, nil
+}
+
+func (s *SQLiteDBService) GetIPDistribution() (map[string]interface{}, error) {
+	return s.countBy(`
+		SELECT t.nodes, count(t.nodes) as cnt
+		FROM (
+			SELECT ip, count(ip) as nodes
+			FROM node_info
+			WHERE deprecated = false AND
+				network_id = $2 AND
+				client_name IS NOT NULL AND
+				last_connected > $1
+			GROUP BY ip
+		) as t
+		GROUP BY t.nodes
+		ORDER BY cnt DESC;
+	`, "ip distribution")
+}
+
+func (s *SQLiteDBService) GetRTTDistribution() (map[string]interface{}, error) {
+	return s.countBy(`
+		SELECT t.latency, count(*) as nodes
+		FROM (
+			SELECT
+				CASE
+					WHEN latency between 0 AND 100 THEN ' 0-100ms'
+					WHEN latency between 101 AND 200 THEN '101-200ms'
+					WHEN latency between 201 AND 300 THEN '201-300ms'
+					WHEN latency between 301 AND 400 THEN '301-400ms'
+					WHEN latency between 401 AND 500 THEN '401-500ms'
+					WHEN latency between 501 AND 600 THEN '501-600ms'
+					WHEN latency between 601 AND 700 THEN '601-700ms'
+					WHEN latency between 701 AND 800 THEN '701-800ms'
+					WHEN latency between 801 AND 900 THEN '801-900ms'
+					WHEN latency between 901 AND 1000 THEN '901-1000ms'
+					ELSE '+1s'
+				END as latency
+			FROM node_info
+			WHERE deprecated = false AND
+				network_id = $2 AND
+				client_name IS NOT NULL AND
+				last_connected > $1
+		) as t
+		GROUP BY t.latency
+		ORDER BY nodes DESC;
+	`, "rtt distribution")
+}
+
+func (s *SQLiteDBService) GetDeprecatedNodes() (int, error) {
+	log.Debug("fetching deprecated node count")
+	var deprecatedCount int
+	err := s.db.QueryRowContext(s.ctx, `
+		SELECT count(deprecated)
+		FROM node_info
+		WHERE deprecated = true;
+	`).Scan(&deprecatedCount)
+	if err != nil {
+		return deprecatedCount, errors.Wrap(err, "unable to fetch deprecated node count")
+	}
+	return deprecatedCount, nil
+}
+
+func (s *SQLiteDBService) GetForkClassDistribution() (map[string]interface{}, error) {
+	log.Debug("fetching fork class distribution metrics")
+	return s.countBy(`
+		SELECT fork_class, count(fork_class) as cnt
+		FROM node_info
+		WHERE
+			first_connected IS NOT NULL AND
+			network_id = $2 AND
+			deprecated = false AND
+			fork_class IS NOT NULL AND
+			last_connected > $1
+		GROUP BY fork_class
+		ORDER BY cnt DESC;
+	`, "fork class distribution")
+}
+
+func (s *SQLiteDBService) GetSyncStatusDistribution() (map[string]interface{}, error) {
+	log.Debug("fetching sync status distribution metrics")
+	return s.countBy(`
+		SELECT sync_status, count(sync_status) as cnt
+		FROM node_info
+		WHERE
+			first_connected IS NOT NULL AND
+			network_id = $2 AND
+			deprecated = false AND
+			head_number IS NOT NULL AND
+			last_connected > $1
+		GROUP BY sync_status
+		ORDER BY cnt DESC;
+	`, "sync status distribution")
+}
+
+func (s *SQLiteDBService) GetProtocolDistribution() (map[string]interface{}, error) {
+	log.Debug("fetching subprotocol distribution metrics")
+	return s.countBy(`
+		SELECT protocol.value, count(node_info.node_id) as cnt
+		FROM node_info, json_each(node_info.protocols) as protocol
+		WHERE
+			first_connected IS NOT NULL AND
+			network_id = $2 AND
+			deprecated = false AND
+			last_connected > $1
+		GROUP BY protocol.value
+		ORDER BY cnt DESC;
+	`, "subprotocol distribution")
+}
+
+func (s *SQLiteDBService) GetSnapStatusDistribution() (map[string]interface{}, error) {
+	log.Debug("fetching snap status distribution metrics")
+	return s.countBy(`
+		SELECT snap_status, count(snap_status) as cnt
+		FROM node_info
+		WHERE
+			first_connected IS NOT NULL AND
+			network_id = $2 AND
+			deprecated = false AND
+			snap_status IS NOT NULL AND
+			snap_status != 'unknown' AND
+			last_connected > $1
+		GROUP BY snap_status
+		ORDER BY cnt DESC;
+	`, "snap status distribution")
+}
+
+// countBy runs a metric query over the active nodes that returns (key, count) rows
+func (s *SQLiteDBService) countBy(query, metric string) (map[string]interface{}, error) {
+	dist := make(map[string]interface{})
+	rows, err := s.query(query, activeSince(), s.networkID)
+	if err != nil {
+		return dist, errors.Wrap(err, "unable to fetch "+metric)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key interface{}
+		var count int
+		if err := rows.Scan(&key, &count); err != nil {
+			return dist, errors.Wrap(err, "unable to parse "+metric)
+		}
+		dist[fmt.Sprint(key)] = count
+	}
+	return dist, rows.Err()
+}
+
+func (s *SQLiteDBService) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.QueryContext(s.ctx, query, sqliteArgs(args)...)
+}
+
+// activeSince returns the oldest connection for a node to count as active
+func activeSince() time.Time {
+	return time.Now().Add(-LastActivityValidRange * 24 * time.Hour)
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"database/sql"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/devp2p/tooling/ethtest"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortze/ragno/models"
+)
+
+func TestSQLiteStorage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage, err := Connect(ctx, SQLitePrefix+filepath.Join(t.TempDir(), "ragno.db"), 10, time.Hour, 1)
+	require.NoError(t, err)
+
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	node := enode.NewV4(&key.PublicKey, net.ParseIP("8.8.8.8"), 30303, 30303)
+	enr, err := models.NewENR(models.FromDiscv4(node), models.WithTimestamp(time.Now()))
+	require.NoError(t, err)
+	storage.PersistENR(enr)
+
+	attempt := models.NewConnectionAttempt(node.ID())
+	attempt.Status = models.SuccessfulConnection
+	attempt.Latency = 50 * time.Millisecond
+	nInfo, err := models.NewNodeInfo(
+		node.ID(),
+		models.WithHostInfo(*enr.GetHostInfo()),
+		models.WithHandShakeDetails(ethtest.HandshakeDetails{ClientName: "Geth/v1.11.6-stable/linux-amd64/go1.20.3"}),
+		models.WithProtocols([]models.Protocol{{Name: "eth", Version: 68}, {Name: "snap", Version: 1}}),
+		models.WithChainDetails(models.ChainDetails{NetworkID: 1, TotalDifficulty: big.NewInt(1)}),
+	)
+	require.NoError(t, err)
+	storage.PersistNodeInfo(attempt, *nInfo, true)
+
+	storage.PersistIPInfo(models.IPInfo{
+		IPInfoMsg: models.IPInfoMsg{
+			IP:          "8.8.8.8",
+			Country:     "United States",
+			CountryCode: "US",
+			As:          "AS15169 Google LLC",
+			Hosting:     true,
+		},
+		ExpirationTime: time.Now().Add(time.Hour),
+	})
+	// the persists are written in order, so the rest are stored once the last one is
+	require.Eventually(t, func() bool {
+		exists, expired, err := storage.CheckIPRecords("8.8.8.8")
+		return err == nil && exists && !expired
+	}, 5*time.Second, 100*time.Millisecond)
+
+	nodes, err := storage.GetNonDeprecatedNodes(1)
+	require.NoError(t, err)
+	require.Len(t, nodes, 1)
+	require.Equal(t, node.ID(), nodes[0].ID)
+	require.Equal(t, "8.8.8.8", nodes[0].IP)
+	require.Equal(t, 30303, nodes[0].TCP)
+	require.True(t, key.PublicKey.Equal(nodes[0].Pubkey))
+
+	seeds, err := storage.GetSeedENRs([]string{"discv4"}, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{node.String()}, seeds)
+
+	clients, err := storage.GetClientDistribution()
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"geth": 1}, clients)
+
+	protocols, err := storage.GetProtocolDistribution()
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"eth/68": 1, "snap/1": 1}, protocols)
+
+	hosting, err := storage.GetHostingDistribution()
+	require.NoError(t, err)
+	require.Equal(t, 1, hosting["hosted_ip_info"])
+	require.Equal(t, 0, hosting["mobile_ip_info"])
+
+	asns, err := storage.GetIPASNs()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"8.8.8.8": "AS15169"}, asns)
+
+	crawl := models.NewCrawl(1)
+	crawl.Clients["geth"] = 1
+	crawl.End = time.Now()
+	require.NoError(t, storage.InsertCrawl(crawl))
+
+	storage.Finish()
+}
+
+func TestSQLiteNeighboursByNetwork(t *testing.T) {
+	endpoint := SQLitePrefix + filepath.Join(t.TempDir(), "ragno.db")
+	nodes := make([]*enode.Node, 0, 2)
+	for i := 0; i < 2; i++ {
+		key, err := crypto.GenerateKey()
+		require.NoError(t, err)
+		nodes = append(nodes, enode.NewV4(&key.PublicKey, net.ParseIP("8.8.8.8"), 30303, 30303))
+	}
+
+	storage, err := Connect(context.Background(), endpoint, 10, time.Hour, 1)
+	require.NoError(t, err)
+	// the relationship is kept once, with the last time it was seen
+	lastSeen := time.Now()
+	for _, seenAt := range []time.Time{lastSeen.Add(-time.Minute), lastSeen} {
+		storage.PersistNeighbours(&models.Neighbours{
+			Source:     nodes[0],
+			Neighbours: []*enode.Node{nodes[1]},
+			Timestamp:  seenAt,
+		})
+	}
+	storage.Finish()
+
+	edges := func(networkID uint64) []models.NeighbourEdge {
+		storage, err := Connect(context.Background(), endpoint, 10, time.Hour, networkID)
+		require.NoError(t, err)
+		defer storage.Finish()
+		edges, err := storage.GetNeighbourEdges(time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		return edges
+	}
+	mainnetEdges := edges(1)
+	require.Len(t, mainnetEdges, 1)
+	require.Equal(t, nodes[0].ID(), mainnetEdges[0].Source)
+	require.Equal(t, nodes[1].ID(), mainnetEdges[0].Neighbour)
+	require.WithinDuration(t, lastSeen, mainnetEdges[0].SeenAt, time.Second)
+	require.Empty(t, edges(11155111))
+}
+
+func TestSQLiteInboundWithoutStatus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage, err := ConnectToSQLite(ctx, filepath.Join(t.TempDir(), "ragno.db"), 10, time.Hour, 1)
+	require.NoError(t, err)
+	defer storage.Finish()
+
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	node := enode.NewV4(&key.PublicKey, net.ParseIP("8.8.8.8"), 30303, 30303)
+	attempt := models.NewConnectionAttempt(node.ID())
+	attempt.Status = models.SuccessfulConnection
+	known, err := models.NewNodeInfo(
+		node.ID(),
+		models.WithHostInfo(models.HostInfo{ID: node.ID(), Pubkey: &key.PublicKey, IP: "8.8.8.8", TCP: 30303}),
+		models.WithHandShakeDetails(ethtest.HandshakeDetails{ClientName: "Geth/v1.11.6-stable/linux-amd64/go1.20.3"}),
+		models.WithProtocols([]models.Protocol{{Name: "eth", Version: 68}}),
+		models.WithChainDetails(models.ChainDetails{NetworkID: 1, TotalDifficulty: big.NewInt(1)}),
+	)
+	require.NoError(t, err)
+	storage.PersistNodeInfo(attempt, *known, true)
+
+	// the known node dials us without sending its status, and so does a new one
+	newKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	for _, pubkey := range []*ecdsa.PublicKey{&key.PublicKey, &newKey.PublicKey} {
+		id := enode.PubkeyToIDV4(pubkey)
+		inbound := models.NewConnectionAttempt(id)
+		inbound.Inbound = true
+		nInfo, err := models.NewNodeInfo(
+			id,
+			models.WithHostInfo(models.HostInfo{ID: id, Pubkey: pubkey, IP: "1.1.1.1"}),
+			models.WithHandShakeDetails(ethtest.HandshakeDetails{ClientName: "Geth/v1.11.6-stable/linux-amd64/go1.20.3"}),
+		)
+		require.NoError(t, err)
+		storage.PersistInboundNodeInfo(inbound, *nInfo, false)
+	}
+	storage.Flush()
+	var inbounds int
+	require.NoError(t, storage.db.QueryRow(`SELECT COUNT(*) FROM conn_attempts WHERE inbound = true;`).Scan(&inbounds))
+	require.Equal(t, 2, inbounds)
+
+	var networkID, tcp int
+	var protocols string
+	err = storage.db.QueryRow(`SELECT network_id, tcp, protocols FROM node_info WHERE node_id = $1;`,
+		node.ID().String()).Scan(&networkID, &tcp, &protocols)
+	require.NoError(t, err)
+	require.Equal(t, 1, networkID)
+	require.Equal(t, 30303, tcp)
+	require.Equal(t, `["eth/68"]`, protocols)
+
+	var newTCP sql.NullInt64
+	err = storage.db.QueryRow(`SELECT tcp FROM node_info WHERE node_id = $1;`,
+		enode.PubkeyToIDV4(&newKey.PublicKey).String()).Scan(&newTCP)
+	require.NoError(t, err)
+	require.False(t, newTCP.Valid)
+}
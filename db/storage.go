@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/cortze/ragno/models"
+)
+
+// Storage is the set of methods that the crawler, the discovery and the IP locator use to
+// store and read what they gather, implemented by the Postgres and the SQLite backends.
+// The query builders are shared by both backends, each one adapting the args to its driver
+type Storage interface {
+	// the persists are queued and written in batches
+	PersistENR(*models.ENR)
+	PersistNeighbours(*models.Neighbours)
+	PersistNodeInfo(models.ConnectionAttempt, models.NodeInfo, bool)
+	PersistInboundNodeInfo(models.ConnectionAttempt, models.NodeInfo, bool)
+	PersistSession(*models.Session)
+	PersistTxSamples(*models.TxSamples)
+	InsertCrawl(*models.Crawl) error
+
+	// nodes
+	GetSeedENRs(origins []string, limit int) ([]string, error)
+	GetNonDeprecatedNodes(networkID uint64) ([]models.HostInfo, error)
+	GetNeighbourEdges(since time.Time) ([]models.NeighbourEdge, error)
+
+	// ip info
+	PersistIPInfo(models.IPInfo)
+	GetIPInfo(ip string) (models.IPInfo, error)
+	CheckIPRecords(ip string) (exists bool, expired bool, err error)
+	GetExpiredIPInfo() ([]string, error)
+	GetIPASNs() (map[string]string, error)
+
+	// metrics
+	GetClientDistribution() (map[string]interface{}, error)
+	GetVersionDistribution() (map[string]interface{}, error)
+	GetGeoDistribution() (map[string]interface{}, error)
+	GetOsDistribution() (map[string]interface{}, error)
+	GetArchDistribution() (map[string]interface{}, error)
+	GetHostingDistribution() (map[string]interface{}, error)
+	GetIPDistribution() (map[string]interface{}, error)
+	GetRTTDistribution() (map[string]interface{}, error)
+	GetDeprecatedNodes() (int, error)
+	GetForkClassDistribution() (map[string]interface{}, error)
+	GetSyncStatusDistribution() (map[string]interface{}, error)
+	GetProtocolDistribution() (map[string]interface{}, error)
+	GetSnapStatusDistribution() (map[string]interface{}, error)
+
+	// Flush blocks until the persists queued so far are written
+	Flush()
+	// Finish flushes the queued persists and closes the connections
+	Finish()
+}
+
+var (
+	_ Storage = (*PostgresDBService)(nil)
+	_ Storage = (*SQLiteDBService)(nil)
+)
+
+// SQLitePrefix is the scheme of the endpoints stored in a SQLite file
+// (e.g. sqlite://ragno.db or sqlite:///data/ragno.db)
+const SQLitePrefix = "sqlite://"
+
+// Connect opens the backend that the endpoint points to: a SQLite file for the
+// sqlite:// endpoints, Postgres for the rest
+func Connect(
+	ctx context.Context, endpoint string, workerNum int, snapshotInterval time.Duration, networkID uint64,
+) (Storage, error) {
+	if strings.HasPrefix(endpoint, SQLitePrefix) {
+		sqliteDB, err := ConnectToSQLite(
+			ctx, strings.TrimPrefix(endpoint, SQLitePrefix), workerNum, snapshotInterval, networkID)
+		if err != nil {
+			return nil, err
+		}
+		return sqliteDB, nil
+	}
+	psqlDB, err := ConnectToDB(ctx, endpoint, workerNum, snapshotInterval, networkID)
+	if err != nil {
+		return nil, err
+	}
+	return psqlDB, nil
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cortze/ragno/models"
+)
+
+// insertTxSighting keeps the earliest sighting of the transaction, completing its type
+// and size if the previous sighting didn't have them
+func insertTxSighting(sighting *models.TxSighting) (query string, args []interface{}) {
+	query = `
+	INSERT INTO tx_sightings (
+		hash,
+		first_seen,
+		node_id,
+		tx_type,
+		size,
+		full_tx
+	) VALUES ($1,$2,$3,$4,$5,$6)
+	ON CONFLICT (hash) DO UPDATE SET
+		node_id = CASE WHEN EXCLUDED.first_seen < tx_sightings.first_seen
+			THEN EXCLUDED.node_id ELSE tx_sightings.node_id END,
+		full_tx = CASE WHEN EXCLUDED.first_seen < tx_sightings.first_seen
+			THEN EXCLUDED.full_tx ELSE tx_sightings.full_tx END,
+		first_seen = CASE WHEN EXCLUDED.first_seen < tx_sightings.first_seen
+			THEN EXCLUDED.first_seen ELSE tx_sightings.first_seen END,
+		tx_type = COALESCE(tx_sightings.tx_type, EXCLUDED.tx_type),
+		size = COALESCE(tx_sightings.size, EXCLUDED.size);
+	`
+	var txType, size interface{}
+	if sighting.HasMeta {
+		txType = int16(sighting.Type)
+		size = int64(sighting.Size)
+	}
+	args = append(args, sighting.Hash.String())
+	args = append(args, sighting.FirstSeen)
+	args = append(args, sighting.ID.String())
+	args = append(args, txType)
+	args = append(args, size)
+	args = append(args, sighting.Full)
+	return query, args
+}
+
+func insertPeerTxStats(stats *models.PeerTxStats) (query string, args []interface{}) {
+	query = `
+	INSERT INTO tx_announcements (
+		node_id,
+		window_start,
+		window_end,
+		messages,
+		hashes,
+		transactions,
+		first_seen
+	) VALUES ($1,$2,$3,$4,$5,$6,$7);
+	`
+	args = append(args, stats.ID.String())
+	args = append(args, stats.WindowStart)
+	args = append(args, stats.WindowEnd)
+	args = append(args, stats.Messages)
+	args = append(args, stats.Hashes)
+	args = append(args, stats.Transactions)
+	args = append(args, stats.FirstSeen)
+	return query, args
+}
+
+// PersistTxSamples queues the tx sightings and the per-node stats of a sampling window
+func (d *PostgresDBService) PersistTxSamples(samples *models.TxSamples) {
+	log.Tracef("Persisting %d tx sightings", len(samples.Sightings))
+	for _, sighting := range samples.Sightings {
+		p := NewPersistable()
+		p.query, p.values = insertTxSighting(sighting)
+		d.writeChan <- p
+	}
+	for _, stats := range samples.Stats {
+		p := NewPersistable()
+		p.query, p.values = insertPeerTxStats(stats)
+		d.writeChan <- p
+	}
+}
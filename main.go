@@ -35,6 +35,8 @@ func main() {
 			cmd.RunCommand,
 			cmd.Discv4Cmd,
 			cmd.ConnectCmd,
+			cmd.TopologyCmd,
+			cmd.DBCmd,
 		},
 	}
 	
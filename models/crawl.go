@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+)
+
+// Crawl summarizes a one-shot pass over the network: how many nodes were discovered,
+// how many of them answered, and the clients they run
+type Crawl struct {
+	NetworkID  uint64
+	Start      time.Time
+	End        time.Time
+	Discovered int // unique nodes received from the discovery
+	Dialed     int // known nodes dialed in the pass
+	Reachable  int // dialed nodes that answered from the crawled network
+	Clients    map[string]int
+	Complete   bool // the pass wasn't interrupted
+}
+
+func NewCrawl(networkID uint64) *Crawl {
+	return &Crawl{
+		NetworkID: networkID,
+		Start:     time.Now(),
+		Clients:   make(map[string]int),
+	}
+}
+
+func (c *Crawl) Duration() time.Duration {
+	return c.End.Sub(c.Start)
+}
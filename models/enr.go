@@ -1,6 +1,7 @@
 package models
 
 import (
+	"net"
 	"strconv"
 	"time"
 
@@ -21,6 +22,10 @@ func (t DiscoveryType) String() string {
 		s = "discv5"
 	case CsvFile:
 		s = "csv"
+	case DNSTree:
+		s = "dns"
+	case Discovery4Crawl:
+		s = "discv4-crawl"
 	default:
 	}
 	return s
@@ -31,6 +36,8 @@ const (
 	Discovery4
 	Discovery5
 	CsvFile
+	DNSTree
+	Discovery4Crawl
 )
 
 // Basic structure that can be readed from the discovery services
@@ -41,6 +48,7 @@ type ENR struct {
 	DiscType  DiscoveryType
 	ID        enode.ID
 	IP        string
+	IP6       string // IPv6 announced by dual-stack nodes next to the IPv4
 	UDP       int
 	TCP       int
 	Pubkey    string
@@ -81,6 +89,61 @@ func FromDiscv4(en *enode.Node) ENRoption {
 		enr.DiscType = Discovery4
 		enr.ID = en.ID()
 		enr.IP = en.IP().String()
+		enr.IP6 = recordIPv6(en)
+		enr.UDP = en.UDP()
+		enr.TCP = en.TCP()
+		enr.Seq = en.Seq()
+		enr.Pubkey = PubkeyToString(en.Pubkey())
+		return nil
+	}
+}
+
+// FromDiscv5 fills the ENR from a node discovered over discv5
+func FromDiscv5(en *enode.Node) ENRoption {
+	return func(enr *ENR) error {
+		err := en.ValidateComplete()
+		if err != nil {
+			return err
+		}
+		enr.Node = en
+		enr.Record = en.Record()
+		enr.DiscType = Discovery5
+		enr.ID = en.ID()
+		enr.IP = en.IP().String()
+		enr.IP6 = recordIPv6(en)
+		enr.UDP = en.UDP()
+		enr.TCP = en.TCP()
+		enr.Seq = en.Seq()
+		enr.Pubkey = PubkeyToString(en.Pubkey())
+		return nil
+	}
+}
+
+// FromDiscv4Crawl fills the ENR from a node found while walking the discv4 buckets
+func FromDiscv4Crawl(en *enode.Node) ENRoption {
+	return func(enr *ENR) error {
+		err := FromDiscv4(en)(enr)
+		if err != nil {
+			return err
+		}
+		enr.DiscType = Discovery4Crawl
+		return nil
+	}
+}
+
+// FromDNSTree fills the ENR from a node listed in an EIP-1459 DNS tree
+func FromDNSTree(en *enode.Node) ENRoption {
+	return func(enr *ENR) error {
+		err := en.ValidateComplete()
+		if err != nil {
+			return err
+		}
+		enr.Node = en
+		enr.Record = en.Record()
+		enr.DiscType = DNSTree
+		enr.ID = en.ID()
+		enr.IP = en.IP().String()
+		enr.IP6 = recordIPv6(en)
 		enr.UDP = en.UDP()
 		enr.TCP = en.TCP()
 		enr.Seq = en.Seq()
@@ -109,6 +172,7 @@ func FromCSVline(line []string) ENRoption {
 		enr.ID = node.ID()
 		enr.Pubkey = PubkeyToString(node.Pubkey())
 		enr.IP = node.IP().String()
+		enr.IP6 = recordIPv6(node)
 		enr.UDP = node.UDP()
 		enr.TCP = node.TCP()
 		enr.Seq = node.Seq()
@@ -116,6 +180,16 @@ func FromCSVline(line []string) ENRoption {
 	}
 }
 
+// recordIPv6 returns the IPv6 entry of the node record (if any), as the IP of the nodes
+// prefers the IPv4 one when both are announced
+func recordIPv6(en *enode.Node) string {
+	var ip6 ogEnr.IPv6
+	if err := en.Load(&ip6); err != nil {
+		return ""
+	}
+	return net.IP(ip6).String()
+}
+
 func (n *ENR) IsValid() bool {
 	return (len(n.ID) > 0) && (len(n.IP) > 0) && (n.UDP > 0)
 }
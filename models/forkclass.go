@@ -0,0 +1,91 @@
+package models
+
+import (
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/forkid"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/pkg/errors"
+)
+
+type ForkClass int8
+
+func (c ForkClass) String() (str string) {
+	switch c {
+	case ForkCompatible:
+		str = "compatible"
+	case ForkStale:
+		str = "stale"
+	case ForkFuture:
+		str = "future"
+	case ForkIncompatible:
+		str = "incompatible"
+	default:
+		str = "unknown"
+	}
+	return str
+}
+
+const (
+	ForkUnknown ForkClass = iota
+	ForkCompatible
+	ForkStale        // the node missed one of the forks
+	ForkFuture       // the node already applied a fork that is still ahead of us
+	ForkIncompatible // the node follows a different chain
+)
+
+// forkChain exposes the network as the chain that forkid.NewFilter needs. The network is
+// considered past all its block-based forks, so only the time-based ones are pending
+type forkChain struct {
+	network *Network
+	genesis *types.Block
+	now     uint64
+}
+
+func (c *forkChain) Config() *params.ChainConfig {
+	return c.network.ChainConfig
+}
+
+func (c *forkChain) Genesis() *types.Block {
+	return c.genesis
+}
+
+func (c *forkChain) CurrentHeader() *types.Header {
+	return &types.Header{
+		Number: big.NewInt(math.MaxInt64),
+		Time:   c.now,
+	}
+}
+
+// ClassifyForkID validates the fork id of a remote node against the forks of the network
+func (n *Network) ClassifyForkID(remote forkid.ID) ForkClass {
+	return n.classifyForkID(remote, uint64(time.Now().Unix()))
+}
+
+func (n *Network) classifyForkID(remote forkid.ID, now uint64) ForkClass {
+	genesis := n.GenesisBlock()
+	if genesis == nil {
+		return ForkUnknown
+	}
+	filter := forkid.NewFilter(&forkChain{network: n, genesis: genesis, now: now})
+	err := filter(remote)
+	switch {
+	case errors.Is(err, forkid.ErrRemoteStale):
+		return ForkStale
+	case err != nil:
+		return ForkIncompatible
+	}
+	// the filter accepts the nodes that are ahead of us (as if we were the ones syncing)
+	current := forkid.NewID(n.ChainConfig, n.GenesisHash, math.MaxInt64, now)
+	for next := current.Next; next != 0; {
+		future := forkid.NewID(n.ChainConfig, n.GenesisHash, math.MaxInt64, next)
+		if future.Hash == remote.Hash {
+			return ForkFuture
+		}
+		next = future.Next
+	}
+	return ForkCompatible
+}
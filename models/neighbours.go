@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// Neighbours gathers the nodes that a discv4 node returned when we asked it for the
+// content of its buckets
+type Neighbours struct {
+	Source     *enode.Node
+	Neighbours []*enode.Node
+	Timestamp  time.Time
+}
+
+// NeighbourEdge is a single relationship of the discovery topology, the neighbour was
+// returned by the source node
+type NeighbourEdge struct {
+	Source    enode.ID
+	Neighbour enode.ID
+	SeenAt    time.Time
+}
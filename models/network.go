@@ -0,0 +1,187 @@
+package models
+
+import (
+	"encoding/json"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/forkid"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/pkg/errors"
+)
+
+const (
+	MainnetNetwork = "mainnet"
+	SepoliaNetwork = "sepolia"
+	HoleskyNetwork = "holesky"
+	CustomNetwork  = "custom"
+)
+
+// Network gathers the chain details that the crawler needs to identify itself and
+// the nodes of the network it crawls
+type Network struct {
+	Name        string
+	NetworkID   uint64
+	GenesisHash common.Hash
+	GenesisTime uint64
+	ChainConfig *params.ChainConfig
+	Bootnodes   []string
+	DNSTrees    []string
+
+	// the genesis block is only built if needed, as it requires hashing the whole alloc
+	genesis      func() *types.Block
+	genesisOnce  sync.Once
+	genesisBlock *types.Block
+}
+
+// GenesisBlock returns the genesis block of the network (nil if we don't know it)
+func (n *Network) GenesisBlock() *types.Block {
+	n.genesisOnce.Do(func() {
+		if n.genesis != nil {
+			n.genesisBlock = n.genesis()
+		}
+	})
+	return n.genesisBlock
+}
+
+// NewNetwork returns the details of the given preset network, or the ones of the custom
+// genesis file. The list of bootnodes, if any, overrides the default ones of the network
+func NewNetwork(name string, genesisFile string, bootnodes []string) (*Network, error) {
+	var network *Network
+	var err error
+	switch strings.ToLower(name) {
+	case MainnetNetwork, "":
+		network = MainnetNetworkDetails()
+	case SepoliaNetwork:
+		network = SepoliaNetworkDetails()
+	case HoleskyNetwork:
+		network = HoleskyNetworkDetails()
+	case CustomNetwork:
+		network, err = NetworkFromGenesisFile(genesisFile)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("unknown network %s", name)
+	}
+	if len(bootnodes) > 0 {
+		network.Bootnodes = bootnodes
+	}
+	return network, nil
+}
+
+// ForkID returns the fork id that the network had at genesis
+func (n *Network) ForkID() forkid.ID {
+	return forkid.NewID(n.ChainConfig, n.GenesisHash, 0, n.GenesisTime)
+}
+
+// The chain configs of the pinned geth version miss the latest time-based forks, so we
+// add the ones that the ChainConfig can represent to keep the fork ids up to date
+func withTimeForks(config *params.ChainConfig, cancun, prague uint64) *params.ChainConfig {
+	cfg := *config
+	cfg.CancunTime = &cancun
+	cfg.PragueTime = &prague
+	return &cfg
+}
+
+func MainnetNetworkDetails() *Network {
+	return &Network{
+		Name:        MainnetNetwork,
+		NetworkID:   params.MainnetChainConfig.ChainID.Uint64(),
+		GenesisHash: params.MainnetGenesisHash,
+		GenesisTime: 0,
+		ChainConfig: withTimeForks(params.MainnetChainConfig, 1710338135, 1746612311),
+		Bootnodes:   params.MainnetBootnodes,
+		DNSTrees:    []string{params.KnownDNSNetwork(params.MainnetGenesisHash, "all")},
+		genesis:     func() *types.Block { return core.DefaultGenesisBlock().ToBlock() },
+	}
+}
+
+func SepoliaNetworkDetails() *Network {
+	return &Network{
+		Name:        SepoliaNetwork,
+		NetworkID:   params.SepoliaChainConfig.ChainID.Uint64(),
+		GenesisHash: params.SepoliaGenesisHash,
+		GenesisTime: 1633267481,
+		ChainConfig: withTimeForks(params.SepoliaChainConfig, 1706655072, 1741159776),
+		Bootnodes:   params.SepoliaBootnodes,
+		DNSTrees:    []string{params.KnownDNSNetwork(params.SepoliaGenesisHash, "all")},
+		genesis:     func() *types.Block { return core.DefaultSepoliaGenesisBlock().ToBlock() },
+	}
+}
+
+// Holesky isn't part of the pinned geth version, so its details are hardcoded (without
+// the genesis block, so the fork ids of the nodes can't be classified)
+var (
+	HoleskyGenesisHash = common.HexToHash("0xb5f7f912443c940f21fd611f12828d75b534364ed9e95ca4e307729a4661bde4")
+	HoleskyBootnodes   = []string{
+		"enode://ac906289e4b7f12df423d654c5a962b6ebe5b3a74cc9e06292a85221f9a64a6f1cfdd6b714ed6dacef51578f92b34c60ee91e9ede9c7f8fadc4d347326d95e2b@146.190.13.128:30303",
+		"enode://a3435a0155a3e837c02f5e7f5662a2f1fbc25b48e4dc232016e1c51b544cb5b4510ef633ea3278c0e970fa8ad8141e2d4d0f9f95456c537ff05fdf9b31c15072@178.128.136.233:30303",
+	}
+)
+
+func HoleskyNetworkDetails() *Network {
+	shanghai := uint64(1696000704)
+	config := &params.ChainConfig{
+		ChainID:                       big.NewInt(17000),
+		HomesteadBlock:                big.NewInt(0),
+		DAOForkSupport:                true,
+		EIP150Block:                   big.NewInt(0),
+		EIP155Block:                   big.NewInt(0),
+		EIP158Block:                   big.NewInt(0),
+		ByzantiumBlock:                big.NewInt(0),
+		ConstantinopleBlock:           big.NewInt(0),
+		PetersburgBlock:               big.NewInt(0),
+		IstanbulBlock:                 big.NewInt(0),
+		BerlinBlock:                   big.NewInt(0),
+		LondonBlock:                   big.NewInt(0),
+		TerminalTotalDifficulty:       big.NewInt(0),
+		TerminalTotalDifficultyPassed: true,
+		ShanghaiTime:                  &shanghai,
+	}
+	return &Network{
+		Name:        HoleskyNetwork,
+		NetworkID:   17000,
+		GenesisHash: HoleskyGenesisHash,
+		GenesisTime: 1695902400,
+		ChainConfig: withTimeForks(config, 1707305664, 1740434112),
+		Bootnodes:   HoleskyBootnodes,
+		DNSTrees:    []string{},
+	}
+}
+
+// NetworkFromGenesisFile reads the network details from a genesis JSON file (as the ones
+// given to geth init). The chain id of the genesis is taken as the network id
+func NetworkFromGenesisFile(file string) (*Network, error) {
+	if file == "" {
+		return nil, errors.New("a genesis file is needed for custom networks")
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read genesis file")
+	}
+	genesis := new(core.Genesis)
+	err = json.Unmarshal(content, genesis)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse genesis file")
+	}
+	if genesis.Config == nil || genesis.Config.ChainID == nil {
+		return nil, errors.New("genesis file has no chain config or chain id")
+	}
+	genesisBlock := genesis.ToBlock()
+	return &Network{
+		Name:        CustomNetwork,
+		NetworkID:   genesis.Config.ChainID.Uint64(),
+		GenesisHash: genesisBlock.Hash(),
+		GenesisTime: genesis.Timestamp,
+		ChainConfig: genesis.Config,
+		Bootnodes:   []string{},
+		DNSTrees:    []string{},
+		genesis:     func() *types.Block { return genesisBlock },
+	}, nil
+}
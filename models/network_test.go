@@ -0,0 +1,103 @@
+package models
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/forkid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkGenesisForkIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		network  string
+		expected forkid.ID
+	}{
+		{
+			name:     "Test Mainnet Genesis ForkID",
+			network:  MainnetNetwork,
+			expected: forkid.ID{Hash: [4]byte{0xfc, 0x64, 0xec, 0x04}, Next: 1150000},
+		},
+		{
+			name:     "Test Sepolia Genesis ForkID",
+			network:  SepoliaNetwork,
+			expected: forkid.ID{Hash: [4]byte{0xfe, 0x33, 0x66, 0xe7}, Next: 1735371},
+		},
+		{
+			name:     "Test Holesky Genesis ForkID",
+			network:  HoleskyNetwork,
+			expected: forkid.ID{Hash: [4]byte{0xc6, 0x1a, 0x60, 0x98}, Next: 1696000704},
+		},
+	}
+
+	for _, testItem := range tests {
+		t.Run(testItem.name, func(t *testing.T) {
+			network, err := NewNetwork(testItem.network, "", nil)
+			require.NoError(t, err)
+			require.Equal(t, testItem.expected, network.ForkID())
+		})
+	}
+}
+
+func TestNetworkBootnodesOverride(t *testing.T) {
+	bootnodes := []string{"enode://ac906289e4b7f12df423d654c5a962b6ebe5b3a74cc9e06292a85221f9a64a6f1cfdd6b714ed6dacef51578f92b34c60ee91e9ede9c7f8fadc4d347326d95e2b@127.0.0.1:30303"}
+	network, err := NewNetwork(SepoliaNetwork, "", bootnodes)
+	require.NoError(t, err)
+	require.Equal(t, bootnodes, network.Bootnodes)
+
+	_, err = NewNetwork("unknown-net", "", nil)
+	require.Error(t, err)
+	_, err = NewNetwork(CustomNetwork, "", nil)
+	require.Error(t, err)
+}
+
+func TestNetworkClassifyForkID(t *testing.T) {
+	mainnet := MainnetNetworkDetails()
+	// between Cancun and Prague
+	now := uint64(1720000000)
+	cancun := forkid.NewID(mainnet.ChainConfig, mainnet.GenesisHash, math.MaxInt64, now)
+	prague := forkid.NewID(mainnet.ChainConfig, mainnet.GenesisHash, math.MaxInt64, 1750000000)
+	shanghai := forkid.NewID(mainnet.ChainConfig, mainnet.GenesisHash, math.MaxInt64, 1700000000)
+
+	tests := []struct {
+		name     string
+		remote   forkid.ID
+		expected ForkClass
+	}{
+		{
+			name:     "Test Same Fork",
+			remote:   cancun,
+			expected: ForkCompatible,
+		},
+		{
+			name:     "Test Syncing Node Aware Of Next Fork",
+			remote:   shanghai,
+			expected: ForkCompatible,
+		},
+		{
+			name:     "Test Node That Missed A Fork",
+			remote:   forkid.ID{Hash: shanghai.Hash, Next: 0},
+			expected: ForkStale,
+		},
+		{
+			name:     "Test Node Ahead Of Us",
+			remote:   prague,
+			expected: ForkFuture,
+		},
+		{
+			name:     "Test Node From Another Chain",
+			remote:   forkid.ID{Hash: [4]byte{0xde, 0xad, 0xbe, 0xef}, Next: 0},
+			expected: ForkIncompatible,
+		},
+	}
+	for _, testItem := range tests {
+		t.Run(testItem.name, func(t *testing.T) {
+			require.Equal(t, testItem.expected, mainnet.classifyForkID(testItem.remote, now))
+		})
+	}
+
+	// without genesis block there is no way to validate the fork ids
+	holesky := HoleskyNetworkDetails()
+	require.Equal(t, ForkUnknown, holesky.ClassifyForkID(holesky.ForkID()))
+}
@@ -43,6 +43,8 @@ type NodeInfo struct {
 	HostInfo
 	ethtest.HandshakeDetails
 	ChainDetails
+	// subprotocols negotiated in the handshake
+	Protocols []Protocol
 }
 
 func NewNodeInfo(id enode.ID, opts ...NodeInfoOption) (*NodeInfo, error) {
@@ -78,6 +80,14 @@ func WithHandShakeDetails(d ethtest.HandshakeDetails) NodeInfoOption {
 	}
 }
 
+// WithProtocols adds the negotiated subprotocols to the NodeInfo struct
+func WithProtocols(protocols []Protocol) NodeInfoOption {
+	return func(n *NodeInfo) error {
+		n.Protocols = protocols
+		return nil
+	}
+}
+
 // WithHostInfo adds the give host info to the NodeInfo struct
 func WithChainDetails(cd ChainDetails) NodeInfoOption {
 	return func(n *NodeInfo) error {
@@ -105,6 +115,17 @@ type ConnectionAttempt struct {
 	Error      string
 	Latency    time.Duration
 	Deprecable bool
+	Inbound    bool // the remote node dialed us
+	Timings    ConnectionTimings
+}
+
+// ConnectionTimings are the durations of each of the phases of a connection (zero if
+// the phase wasn't reached)
+type ConnectionTimings struct {
+	TCP    time.Duration
+	RLPx   time.Duration // ECIES handshake
+	Hello  time.Duration // devp2p Hello exchange
+	Status time.Duration // eth Status exchange
 }
 
 func NewConnectionAttempt(id enode.ID) ConnectionAttempt {
@@ -120,6 +141,12 @@ type ChainDetails struct {
 	HeadHash        common.Hash
 	NetworkID       uint64
 	TotalDifficulty *big.Int
+	ForkClass       ForkClass
+	// only filled when the head is probed
+	HeadBlock  HeadBlock
+	SyncStatus SyncStatus
+	// only filled when snap is probed
+	SnapStatus SnapStatus
 }
 
 func (d *ChainDetails) IsEmpty() bool {
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"crypto/ecdsa"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+)
+
+// LoadOrCreateNodeKey reads the secp256k1 key (hex encoded, as geth's nodekey) from the
+// given file, generating and storing a new one if the file doesn't exist yet. An empty
+// path gives an ephemeral key
+func LoadOrCreateNodeKey(file string) (*ecdsa.PrivateKey, error) {
+	if file == "" {
+		return crypto.GenerateKey()
+	}
+	privk, err := crypto.LoadECDSA(file)
+	if err == nil {
+		return privk, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, errors.Wrap(err, "unable to load node key from "+file)
+	}
+	privk, err = crypto.GenerateKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to generate node key")
+	}
+	if dir := filepath.Dir(file); dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, errors.Wrap(err, "unable to create node key directory")
+		}
+	}
+	if err := crypto.SaveECDSA(file, privk); err != nil {
+		return nil, errors.Wrap(err, "unable to save node key to "+file)
+	}
+	return privk, nil
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadOrCreateNodeKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys", "nodekey")
+	created, err := LoadOrCreateNodeKey(file)
+	require.NoError(t, err)
+	loaded, err := LoadOrCreateNodeKey(file)
+	require.NoError(t, err)
+	require.Equal(t, crypto.FromECDSA(created), crypto.FromECDSA(loaded))
+
+	ephemeral, err := LoadOrCreateNodeKey("")
+	require.NoError(t, err)
+	require.NotEqual(t, crypto.FromECDSA(created), crypto.FromECDSA(ephemeral))
+}
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+// number of message codes reserved by the base devp2p protocol
+const baseProtocolLength = uint64(16)
+
+// number of messages of each version of the subprotocols we support, which define the
+// message offsets of the subprotocols that follow them
+var protocolLengths = map[string]map[uint]uint64{
+	"eth":  {66: 17, 67: 17, 68: 17},
+	"les":  {2: 22, 3: 24, 4: 24},
+	"snap": {1: 8},
+}
+
+// Protocol is a subprotocol negotiated with a remote node
+type Protocol struct {
+	Name    string
+	Version uint
+	Offset  uint64 // first message code of the subprotocol
+}
+
+func (p Protocol) String() string {
+	return fmt.Sprintf("%s/%d", p.Name, p.Version)
+}
+
+// MatchProtocols returns the subprotocols that both sides share, with the same rules
+// that devp2p follows: the highest shared version of each of them, with the message
+// offsets assigned in alphabetical order
+func MatchProtocols(ours, theirs []p2p.Cap) []Protocol {
+	caps := make([]p2p.Cap, len(theirs))
+	copy(caps, theirs)
+	sort.Slice(caps, func(i, j int) bool {
+		return caps[i].Name < caps[j].Name ||
+			(caps[i].Name == caps[j].Name && caps[i].Version < caps[j].Version)
+	})
+	offset := baseProtocolLength
+	matched := make(map[string]Protocol)
+	lengths := make(map[string]uint64)
+	for _, cap := range caps {
+		length, known := protocolLengths[cap.Name][cap.Version]
+		if !known || !hasCap(ours, cap) {
+			continue
+		}
+		// a higher version replaces the previous match
+		if _, ok := matched[cap.Name]; ok {
+			offset -= lengths[cap.Name]
+		}
+		matched[cap.Name] = Protocol{Name: cap.Name, Version: cap.Version, Offset: offset}
+		lengths[cap.Name] = length
+		offset += length
+	}
+	protocols := make([]Protocol, 0, len(matched))
+	for _, proto := range matched {
+		protocols = append(protocols, proto)
+	}
+	sort.Slice(protocols, func(i, j int) bool {
+		return protocols[i].Offset < protocols[j].Offset
+	})
+	return protocols
+}
+
+// GetProtocol returns the negotiated version of the given subprotocol (if any)
+func GetProtocol(protocols []Protocol, name string) (Protocol, bool) {
+	for _, proto := range protocols {
+		if proto.Name == name {
+			return proto, true
+		}
+	}
+	return Protocol{}, false
+}
+
+func hasCap(caps []p2p.Cap, cap p2p.Cap) bool {
+	for _, c := range caps {
+		if c.Name == cap.Name && c.Version == cap.Version {
+			return true
+		}
+	}
+	return false
+}
+
+type SnapStatus int8
+
+func (s SnapStatus) String() (str string) {
+	switch s {
+	case SnapServing:
+		str = "serving"
+	case SnapNotServing:
+		str = "not-serving"
+	case SnapUnresponsive:
+		str = "unresponsive"
+	default:
+		str = "unknown"
+	}
+	return str
+}
+
+const (
+	SnapUnknown      SnapStatus = iota
+	SnapServing                 // the node returned accounts of its head state
+	SnapNotServing              // the node answered, but without any account
+	SnapUnresponsive            // the node didn't answer the request
+)
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchProtocols(t *testing.T) {
+	ours := []p2p.Cap{
+		{Name: "eth", Version: 66},
+		{Name: "eth", Version: 67},
+		{Name: "eth", Version: 68},
+		{Name: "les", Version: 4},
+		{Name: "snap", Version: 1},
+	}
+
+	tests := []struct {
+		name     string
+		theirs   []p2p.Cap
+		expected []Protocol
+	}{
+		{
+			name:   "Test Eth And Snap",
+			theirs: []p2p.Cap{{Name: "snap", Version: 1}, {Name: "eth", Version: 67}, {Name: "eth", Version: 68}},
+			expected: []Protocol{
+				{Name: "eth", Version: 68, Offset: 16},
+				{Name: "snap", Version: 1, Offset: 33},
+			},
+		},
+		{
+			name: "Test Les Shifts Snap",
+			theirs: []p2p.Cap{
+				{Name: "eth", Version: 68}, {Name: "les", Version: 4}, {Name: "snap", Version: 1},
+			},
+			expected: []Protocol{
+				{Name: "eth", Version: 68, Offset: 16},
+				{Name: "les", Version: 4, Offset: 33},
+				{Name: "snap", Version: 1, Offset: 57},
+			},
+		},
+		{
+			name:   "Test Unshared Protocols",
+			theirs: []p2p.Cap{{Name: "bzz", Version: 1}, {Name: "eth", Version: 65}, {Name: "eth", Version: 66}},
+			expected: []Protocol{
+				{Name: "eth", Version: 66, Offset: 16},
+			},
+		},
+		{
+			name:     "Test No Shared Protocol",
+			theirs:   []p2p.Cap{{Name: "eth", Version: 63}},
+			expected: []Protocol{},
+		},
+	}
+	for _, testItem := range tests {
+		t.Run(testItem.name, func(t *testing.T) {
+			require.Equal(t, testItem.expected, MatchProtocols(ours, testItem.theirs))
+		})
+	}
+}
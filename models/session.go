@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// Session is a connection with a remote node that was kept open until one of the sides
+// dropped it
+type Session struct {
+	ID               enode.ID
+	Start            time.Time
+	End              time.Time
+	DisconnectReason string
+	LocalDisconnect  bool // we were the ones closing the session
+}
+
+func NewSession(id enode.ID) *Session {
+	return &Session{
+		ID:    id,
+		Start: time.Now(),
+	}
+}
+
+func (s *Session) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
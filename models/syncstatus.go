@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+)
+
+var (
+	// nodes whose head is at most this far behind the best head are considered at the tip
+	SyncedTolerance = 1 * time.Minute
+	// nodes whose head is further behind than this are considered stuck
+	StalledThreshold = 1 * time.Hour
+)
+
+type SyncStatus int8
+
+func (s SyncStatus) String() (str string) {
+	switch s {
+	case SyncSynced:
+		str = "synced"
+	case SyncLagging:
+		str = "lagging"
+	case SyncStalled:
+		str = "stalled"
+	default:
+		str = "unknown"
+	}
+	return str
+}
+
+const (
+	SyncUnknown SyncStatus = iota
+	SyncSynced             // the node is at the tip of the chain
+	SyncLagging            // the node is behind, but still following the chain
+	SyncStalled            // the node hasn't moved its head for a long time
+)
+
+// HeadBlock is the head of the chain that a remote node reported
+type HeadBlock struct {
+	Number    uint64
+	Timestamp time.Time
+}
+
+func (h HeadBlock) IsEmpty() bool {
+	return h.Timestamp.IsZero()
+}
+
+// ClassifySync compares the head of a node with the best head seen in the crawl. The
+// comparison is done by timestamp, as the block time differs from network to network
+func ClassifySync(head, best HeadBlock) SyncStatus {
+	if head.IsEmpty() || best.IsEmpty() {
+		return SyncUnknown
+	}
+	behind := best.Timestamp.Sub(head.Timestamp)
+	switch {
+	case head.Number >= best.Number || behind <= SyncedTolerance:
+		return SyncSynced
+	case behind <= StalledThreshold:
+		return SyncLagging
+	default:
+		return SyncStalled
+	}
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifySync(t *testing.T) {
+	now := time.Unix(1720000000, 0)
+	best := HeadBlock{Number: 20000000, Timestamp: now}
+
+	tests := []struct {
+		name     string
+		head     HeadBlock
+		best     HeadBlock
+		expected SyncStatus
+	}{
+		{
+			name:     "Test Node At The Best Head",
+			head:     best,
+			best:     best,
+			expected: SyncSynced,
+		},
+		{
+			name:     "Test Node A Few Blocks Behind",
+			head:     HeadBlock{Number: best.Number - 2, Timestamp: now.Add(-24 * time.Second)},
+			best:     best,
+			expected: SyncSynced,
+		},
+		{
+			name:     "Test Node Catching Up",
+			head:     HeadBlock{Number: best.Number - 100, Timestamp: now.Add(-20 * time.Minute)},
+			best:     best,
+			expected: SyncLagging,
+		},
+		{
+			name:     "Test Node Stuck Days Ago",
+			head:     HeadBlock{Number: best.Number - 20000, Timestamp: now.Add(-72 * time.Hour)},
+			best:     best,
+			expected: SyncStalled,
+		},
+		{
+			name:     "Test Head Not Probed",
+			head:     HeadBlock{},
+			best:     best,
+			expected: SyncUnknown,
+		},
+		{
+			name:     "Test No Best Head Yet",
+			head:     best,
+			best:     HeadBlock{},
+			expected: SyncUnknown,
+		},
+	}
+	for _, testItem := range tests {
+		t.Run(testItem.name, func(t *testing.T) {
+			require.Equal(t, testItem.expected, ClassifySync(testItem.head, testItem.best))
+		})
+	}
+}
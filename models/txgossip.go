@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// TxSighting is the first time that one of the nodes announced a transaction to us
+type TxSighting struct {
+	Hash      common.Hash
+	FirstSeen time.Time
+	ID        enode.ID // node that announced it first
+	// type and size are only known from eth/68 announcements or full transactions
+	HasMeta bool
+	Type    byte
+	Size    uint32
+	Full    bool // the node sent the whole transaction instead of its hash
+}
+
+// PeerTxStats counts the transaction announcements that a node sent us in a window
+type PeerTxStats struct {
+	ID           enode.ID
+	WindowStart  time.Time
+	WindowEnd    time.Time
+	Messages     int // NewPooledTransactionHashes and Transactions messages
+	Hashes       int // announced hashes
+	Transactions int // full transactions
+	FirstSeen    int // transactions that we saw first from the node
+}
+
+// TxSamples gathers the transaction gossip sampled in a window
+type TxSamples struct {
+	Sightings []*TxSighting
+	Stats     []*PeerTxStats
+}
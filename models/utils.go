@@ -22,6 +22,45 @@ var PrivateIPNetworks = []net.IPNet{
 		IP:   net.ParseIP("192.168.0.0"),
 		Mask: net.CIDRMask(16, 32),
 	},
+	// IPv6 unique local addresses (ULA)
+	net.IPNet{
+		IP:   net.ParseIP("fc00::"),
+		Mask: net.CIDRMask(7, 128),
+	},
+}
+
+type IPFamily int8
+
+func (f IPFamily) String() (str string) {
+	switch f {
+	case IPv4:
+		str = "ipv4"
+	case IPv6:
+		str = "ipv6"
+	default:
+		str = "unknown"
+	}
+	return str
+}
+
+const (
+	UnknownIPFamily IPFamily = iota
+	IPv4
+	IPv6
+)
+
+// GetIPFamily returns whether the given ip is an IPv4 or an IPv6 one (IPv4-mapped IPv6
+// addresses are considered IPv4)
+func GetIPFamily(ip string) IPFamily {
+	parsed := net.ParseIP(ip)
+	switch {
+	case parsed == nil:
+		return UnknownIPFamily
+	case parsed.To4() != nil:
+		return IPv4
+	default:
+		return IPv6
+	}
 }
 
 func ParseStringToEnode(enr string) *enode.Node {
@@ -62,9 +101,15 @@ func StringToPubkey(str string) (*ecdsa.PublicKey, error) {
 	return pubkey, nil
 }
 
+// IsIPPublic returns whether the ip is globally routable, discarding the private ranges
+// (including the IPv6 ULAs), and the loopback, link-local and unspecified addresses
 func IsIPPublic(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return false
+	}
 	for _, ipNet := range PrivateIPNetworks {
-		if ipNet.Contains(ip) || ip.IsLoopback() || ip.IsUnspecified() {
+		if ipNet.Contains(ip) {
 			return false
 		}
 	}
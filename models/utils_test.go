@@ -0,0 +1,49 @@
+package models
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsIPPublic(t *testing.T) {
+	tests := []struct {
+		name     string
+		ip       string
+		expected bool
+	}{
+		{name: "Test Public IPv4", ip: "85.10.20.30", expected: true},
+		{name: "Test Private IPv4", ip: "192.168.1.10", expected: false},
+		{name: "Test Link-Local IPv4", ip: "169.254.3.4", expected: false},
+		{name: "Test Mapped Private IPv4", ip: "::ffff:10.1.2.3", expected: false},
+		{name: "Test Public IPv6", ip: "2a01:4f8:10a:1::2", expected: true},
+		{name: "Test ULA IPv6", ip: "fd12:3456:789a::1", expected: false},
+		{name: "Test Link-Local IPv6", ip: "fe80::1", expected: false},
+		{name: "Test Loopback IPv6", ip: "::1", expected: false},
+		{name: "Test Unspecified IPv6", ip: "::", expected: false},
+	}
+	for _, testItem := range tests {
+		t.Run(testItem.name, func(t *testing.T) {
+			require.Equal(t, testItem.expected, IsIPPublic(net.ParseIP(testItem.ip)))
+		})
+	}
+}
+
+func TestGetIPFamily(t *testing.T) {
+	tests := []struct {
+		name     string
+		ip       string
+		expected IPFamily
+	}{
+		{name: "Test IPv4", ip: "85.10.20.30", expected: IPv4},
+		{name: "Test Mapped IPv4", ip: "::ffff:85.10.20.30", expected: IPv4},
+		{name: "Test IPv6", ip: "2a01:4f8:10a:1::2", expected: IPv6},
+		{name: "Test Invalid IP", ip: "", expected: UnknownIPFamily},
+	}
+	for _, testItem := range tests {
+		t.Run(testItem.name, func(t *testing.T) {
+			require.Equal(t, testItem.expected, GetIPFamily(testItem.ip))
+		})
+	}
+}
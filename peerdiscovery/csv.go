@@ -1,12 +1,9 @@
 package peerdiscovery
 
 import (
-	csvs "github.com/cortze/ragno/csv"
-	"os"
-	"os/signal"
 	"sync"
-	"syscall"
 
+	csvs "github.com/cortze/ragno/csv"
 	"github.com/cortze/ragno/models"
 	"github.com/sirupsen/logrus"
 )
@@ -43,9 +40,6 @@ func (c *CSV) Run() (chan *models.ENR, error) {
 	}
 	logrus.Debug("Amount of peers read from csv file: ", enrSet.Len())
 
-	closeC := make(chan os.Signal, 1)
-	signal.Notify(closeC, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
-
 	c.wg.Add(1)
 	go func() {
 		defer c.wg.Done()
@@ -54,27 +48,20 @@ func (c *CSV) Run() (chan *models.ENR, error) {
 		enrs := enrSet.GetENRs()
 		for _, enr := range enrs {
 			select {
-			case <-closeC:
+			case <-c.closeC:
 				logrus.Info("csvDiscoverer: Shutdown detected")
 				return
-			default:
+			case c.enrC <- enr:
 			}
-			c.notifyNewENR(enr)
 		}
 		logrus.Trace("csvDiscoverer: Finished sending peers to sending channel")
-		return
 	}()
 	return c.enrC, nil
 }
 
-func (c *CSV) notifyNewENR(enr *models.ENR) {
-	c.enrC <- enr
-}
-
-func (c *CSV) Close() error {
-	c.closeC <- struct{}{}
+func (c *CSV) Close() {
+	close(c.closeC)
 	c.wg.Wait()
-	return nil
 }
 
 func (c *CSV) Type() models.DiscoveryType {
@@ -2,8 +2,7 @@ package peerdiscovery
 
 import (
 	"context"
-	"net"
-	"strconv"
+	"crypto/ecdsa"
 	"sync"
 	"time"
 
@@ -19,18 +18,41 @@ import (
 type Discv4 struct {
 	ctx       context.Context
 	port      int
+	bootnodes []string
+	privk     *ecdsa.PrivateKey
+	dbPath    string
 	discvType models.DiscoveryType
 	enrC      chan *models.ENR
-	doneC     chan struct{}
-	wg        sync.WaitGroup
+	// iterator of the random nodes, closed to unblock the discovery loop
+	rNodes enode.Iterator
+	doneC  chan struct{}
+	wg     sync.WaitGroup
 }
 
-func NewDiscv4(ctx context.Context, port int) (*Discv4, error) {
+// NewDiscv4 creates a discv4 discoverer that will bootstrap from the given list of
+// bootnodes (the mainnet ones are used if none is given). The node database is kept
+// at dbPath, or in memory if it is empty
+func NewDiscv4(
+	ctx context.Context, port int, bootnodes []string, privk *ecdsa.PrivateKey, dbPath string,
+) (*Discv4, error) {
 	logrus.Info("Using Discv4 peer discoverer")
 
+	if privk == nil {
+		var err error
+		privk, err = crypto.GenerateKey()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to generate discv4 key")
+		}
+	}
+	if len(bootnodes) == 0 {
+		bootnodes = params.MainnetBootnodes
+	}
 	disc := &Discv4{
 		ctx:       ctx,
 		port:      port,
+		bootnodes: bootnodes,
+		privk:     privk,
+		dbPath:    dbPath,
 		enrC:      make(chan *models.ENR),
 		discvType: models.Discovery4,
 		doneC:     make(chan struct{}),
@@ -46,54 +68,52 @@ func (d *Discv4) Run() (chan *models.ENR, error) {
 func (d *Discv4) runDiscv4Service() (chan *models.ENR, error) {
 	var err error
 
-	// create the private Key
-	privKey, err := crypto.GenerateKey()
-	if err != nil {
-		return d.enrC, nil
-	}
-
-	bnodes := params.MainnetBootnodes
-	bootnodes, err := models.ParseBootnodes(bnodes)
+	bootnodes, err := models.ParseBootnodes(d.bootnodes)
 	if err != nil {
-		return d.enrC, nil
+		d.wg.Done()
+		return d.enrC, errors.Wrap(err, "unable to parse discv4 bootnodes")
 	}
 
-	ethDB, err := enode.OpenDB("")
+	ethDB, err := enode.OpenDB(d.dbPath)
 	if err != nil {
-		return d.enrC, nil
+		d.wg.Done()
+		return d.enrC, errors.Wrap(err, "unable to open discv4 enode db")
 	}
 
-	localNode := enode.NewLocalNode(ethDB, privKey)
-	udpAddr := &net.UDPAddr{
-		IP:   net.IPv4zero,
-		Port: d.port,
-	}
-	udpListener, err := net.ListenUDP("udp", udpAddr)
+	localNode := enode.NewLocalNode(ethDB, d.privk)
+	udpListener, err := listenUDP(d.port)
 	if err != nil {
-		return d.enrC, nil
+		ethDB.Close()
+		d.wg.Done()
+		return d.enrC, errors.Wrap(err, "unable to listen discv4 udp port")
 	}
 
 	discv4Options := discover.Config{
-		PrivateKey: privKey,
+		PrivateKey: d.privk,
 		Bootnodes:  bootnodes,
 	}
 	discoverer4, err := discover.ListenV4(udpListener, localNode, discv4Options)
 	if err != nil {
-		return d.enrC, nil
+		ethDB.Close()
+		d.wg.Done()
+		return d.enrC, errors.Wrap(err, "unable to launch discv4")
 	}
 
 	logrus.WithFields(logrus.Fields{
-		"ip":   udpAddr.IP.String(),
-		"port": strconv.Itoa(udpAddr.Port),
+		"addr":      udpListener.LocalAddr().String(),
+		"bootnodes": len(bootnodes),
 	}).Info("launching rango discv4")
 
+	// generate an iterator
+	rNodes := discoverer4.RandomNodes()
+	d.rNodes = rNodes
+
 	// actuall loop for crawling
 	go func() {
-		// generate an iterator
-		rNodes := discoverer4.RandomNodes()
 		defer func() {
 			rNodes.Close()
 			discoverer4.Close()
+			ethDB.Close()
 			d.wg.Done()
 		}()
 	newENRloop:
@@ -121,25 +141,31 @@ func (d *Discv4) runDiscv4Service() (chan *models.ENR, error) {
 						models.WithTimestamp(time.Now()))
 					if err != nil {
 						logrus.Error(errors.Wrap(err, "unable to add new node"))
+						continue
 					}
 					d.notifyNewNode(enr)
 				}
 			}
 		}
-		return
 	}()
 	return d.enrC, nil
 }
 
 func (d *Discv4) notifyNewNode(enr *models.ENR) {
-	d.enrC <- enr
+	select {
+	case d.enrC <- enr:
+	case <-d.ctx.Done():
+	case <-d.doneC:
+	}
 }
 
 func (d *Discv4) Close() {
-	// notify of closure
-	d.doneC <- struct{}{}
-	d.wg.Wait()
+	// notify of closure, the loop could be blocked waiting for the next node
 	close(d.doneC)
+	if d.rNodes != nil {
+		d.rNodes.Close()
+	}
+	d.wg.Wait()
 }
 
 func (c *Discv4) Type() models.DiscoveryType {
@@ -0,0 +1,384 @@
+package peerdiscovery
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cortze/ragno/models"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/discover/v4wire"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// time to wait between the end of a crawl round and the start of the next one
+	Discv4CrawlInterval = 30 * time.Minute
+	// number of nodes that are crawled concurrently
+	Discv4CrawlWorkers = 64
+)
+
+const (
+	// geth keeps 17 buckets, the first one holding every node at distance <= 240
+	v4NBuckets          = 17
+	v4BucketMinDistance = 256 - v4NBuckets
+	// number of leading bits of a node id that define in which bucket it falls
+	v4PrefixBits = v4NBuckets
+)
+
+// CrawlRound is the summary of a full crawl round. The discovered nodes are the estimate
+// of the size of the network
+type CrawlRound struct {
+	Start      time.Time
+	End        time.Time
+	Discovered int
+	Reachable  int
+}
+
+// Discv4Crawl walks the whole discv4 DHT in rounds, asking every node it finds for the
+// content of all its buckets through FINDNODE requests with targets at each log distance
+type Discv4Crawl struct {
+	ctx       context.Context
+	port      int
+	bootnodes []string
+	privk     *ecdsa.PrivateKey
+	workers   int
+	interval  time.Duration
+	enrC      chan *models.ENR
+	doneC     chan struct{}
+	wg        sync.WaitGroup
+
+	// the neighbours are only notified once someone asked for them
+	neighboursC      chan *models.Neighbours
+	notifyNeighbours atomic.Bool
+
+	m         sync.RWMutex
+	lastRound *CrawlRound
+	// nodes that replied in the last round, used as seeds for the next one
+	reachable []*enode.Node
+}
+
+// NewDiscv4Crawl creates a discv4 crawler that will bootstrap from the given list of
+// bootnodes (the mainnet ones are used if none is given)
+func NewDiscv4Crawl(ctx context.Context, port int, bootnodes []string, privk *ecdsa.PrivateKey) (*Discv4Crawl, error) {
+	logrus.Info("Using Discv4 crawl peer discoverer")
+
+	if privk == nil {
+		var err error
+		privk, err = crypto.GenerateKey()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to generate discv4 key")
+		}
+	}
+	if len(bootnodes) == 0 {
+		bootnodes = params.MainnetBootnodes
+	}
+	disc := &Discv4Crawl{
+		ctx:         ctx,
+		port:        port,
+		bootnodes:   bootnodes,
+		workers:     Discv4CrawlWorkers,
+		interval:    Discv4CrawlInterval,
+		enrC:        make(chan *models.ENR),
+		neighboursC: make(chan *models.Neighbours),
+		doneC:       make(chan struct{}),
+	}
+	return disc, nil
+}
+
+func (d *Discv4Crawl) Run() (chan *models.ENR, error) {
+	bootnodes, err := models.ParseBootnodes(d.bootnodes)
+	if err != nil {
+		return d.enrC, errors.Wrap(err, "unable to parse discv4 bootnodes")
+	}
+	udpListener, err := listenUDP(d.port)
+	if err != nil {
+		return d.enrC, errors.Wrap(err, "unable to listen discv4 udp port")
+	}
+	client := newV4Client(udpListener, d.privk)
+
+	logrus.WithFields(logrus.Fields{
+		"addr":      udpListener.LocalAddr().String(),
+		"bootnodes": len(bootnodes),
+	}).Info("launching rango discv4 crawler")
+
+	d.wg.Add(1)
+	go func() {
+		defer func() {
+			client.close()
+			d.wg.Done()
+		}()
+		for {
+			round := d.crawlRound(client, bootnodes)
+			if round == nil {
+				return
+			}
+			nextRound := time.NewTimer(d.interval)
+			select {
+			case <-d.ctx.Done():
+				nextRound.Stop()
+				return
+			case <-d.doneC:
+				nextRound.Stop()
+				return
+			case <-nextRound.C:
+			}
+		}
+	}()
+	return d.enrC, nil
+}
+
+type nodeCrawl struct {
+	node       *enode.Node
+	neighbours []*enode.Node
+	err        error
+}
+
+// crawlRound visits every node reachable from the seeds, returning nil if the crawler
+// was closed before the round finished
+func (d *Discv4Crawl) crawlRound(client *v4Client, bootnodes []*enode.Node) *CrawlRound {
+	round := &CrawlRound{
+		Start: time.Now(),
+	}
+	d.m.RLock()
+	seeds := append(append([]*enode.Node{}, bootnodes...), d.reachable...)
+	d.m.RUnlock()
+	logrus.WithFields(logrus.Fields{
+		"seeds": len(seeds),
+	}).Info("starting discv4 crawl round")
+
+	abortC := make(chan struct{})
+	jobC := make(chan *enode.Node)
+	resultC := make(chan *nodeCrawl)
+	var workersWG sync.WaitGroup
+	for i := 0; i < d.workers; i++ {
+		workersWG.Add(1)
+		go func() {
+			defer workersWG.Done()
+			for node := range jobC {
+				res := crawlNode(client, node, abortC)
+				select {
+				case resultC <- res:
+				case <-abortC:
+					return
+				}
+			}
+		}()
+	}
+	defer func() {
+		close(abortC)
+		close(jobC)
+		workersWG.Wait()
+	}()
+
+	visited := make(map[enode.ID]struct{})
+	queue := make([]*enode.Node, 0, len(seeds))
+	for _, seed := range seeds {
+		if _, ok := visited[seed.ID()]; !ok {
+			visited[seed.ID()] = struct{}{}
+			queue = append(queue, seed)
+		}
+	}
+	reachable := make([]*enode.Node, 0)
+	inflight := 0
+	for len(queue) > 0 || inflight > 0 {
+		// only offer a job when there is something queued
+		var next *enode.Node
+		var nextC chan *enode.Node
+		if len(queue) > 0 {
+			next = queue[0]
+			nextC = jobC
+		}
+		select {
+		case <-d.ctx.Done():
+			return nil
+		case <-d.doneC:
+			return nil
+		case nextC <- next:
+			queue = queue[1:]
+			inflight++
+		case res := <-resultC:
+			inflight--
+			if res.err != nil {
+				logrus.WithFields(logrus.Fields{
+					"node":  res.node.ID().String(),
+					"error": res.err,
+				}).Trace("unable to crawl discv4 node")
+				continue
+			}
+			reachable = append(reachable, res.node)
+			neighbours := &models.Neighbours{
+				Source:     res.node,
+				Neighbours: res.neighbours,
+				Timestamp:  time.Now(),
+			}
+			d.notifyNode(res.node)
+			d.notifyNeighbourList(neighbours)
+			for _, neighbour := range res.neighbours {
+				if _, ok := visited[neighbour.ID()]; ok {
+					continue
+				}
+				visited[neighbour.ID()] = struct{}{}
+				queue = append(queue, neighbour)
+			}
+		}
+	}
+	round.End = time.Now()
+	round.Discovered = len(visited)
+	round.Reachable = len(reachable)
+
+	d.m.Lock()
+	d.lastRound = round
+	d.reachable = reachable
+	d.m.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"duration":   round.End.Sub(round.Start).String(),
+		"discovered": round.Discovered,
+		"reachable":  round.Reachable,
+	}).Info("discv4 crawl round done")
+	return round
+}
+
+// crawlNode bonds with the node and asks it for the nodes it has at each bucket
+func crawlNode(client *v4Client, node *enode.Node, abortC chan struct{}) *nodeCrawl {
+	res := &nodeCrawl{node: node}
+	if err := client.bond(node); err != nil {
+		res.err = errors.Wrap(err, "unable to bond")
+		return res
+	}
+	seen := make(map[enode.ID]struct{})
+	replied := false
+	for dist := v4BucketMinDistance + 1; dist <= 256; dist++ {
+		select {
+		case <-abortC:
+			res.err = ErrV4Closed
+			return res
+		default:
+		}
+		neighbours, err := client.findnode(node, v4TargetAtDistance(node.ID(), dist))
+		if err != nil {
+			if err == ErrV4Closed {
+				res.err = err
+				return res
+			}
+			continue
+		}
+		replied = true
+		for _, neighbour := range neighbours {
+			if _, ok := seen[neighbour.ID()]; ok {
+				continue
+			}
+			seen[neighbour.ID()] = struct{}{}
+			res.neighbours = append(res.neighbours, neighbour)
+		}
+	}
+	if !replied {
+		res.err = errors.New("no findnode was answered")
+	}
+	return res
+}
+
+func (d *Discv4Crawl) notifyNode(node *enode.Node) {
+	enr, err := models.NewENR(
+		models.FromDiscv4Crawl(node),
+		models.WithTimestamp(time.Now()))
+	if err != nil {
+		logrus.Error(errors.Wrap(err, "unable to add new node"))
+		return
+	}
+	select {
+	case d.enrC <- enr:
+	case <-d.ctx.Done():
+	case <-d.doneC:
+	}
+}
+
+func (d *Discv4Crawl) notifyNeighbourList(neighbours *models.Neighbours) {
+	if !d.notifyNeighbours.Load() {
+		return
+	}
+	select {
+	case d.neighboursC <- neighbours:
+	case <-d.ctx.Done():
+	case <-d.doneC:
+	}
+}
+
+// Neighbours returns the channel where the neighbours that each node returned are notified
+func (d *Discv4Crawl) Neighbours() chan *models.Neighbours {
+	d.notifyNeighbours.Store(true)
+	return d.neighboursC
+}
+
+// LastRound returns the snapshot of the last finished crawl round (nil if none finished yet)
+func (d *Discv4Crawl) LastRound() *CrawlRound {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	return d.lastRound
+}
+
+func (d *Discv4Crawl) Close() {
+	// notify of closure
+	close(d.doneC)
+	d.wg.Wait()
+}
+
+func (d *Discv4Crawl) Type() models.DiscoveryType {
+	return models.Discovery4Crawl
+}
+
+// The remote node looks for the nodes closest to keccak256(target), so we keep a table
+// with a preimage for each of the possible prefixes of the hashed target
+var (
+	v4PrefixTableOnce sync.Once
+	v4PrefixTable     []uint32
+)
+
+func v4PrefixPreimage(counter uint32) v4wire.Pubkey {
+	var target v4wire.Pubkey
+	binary.BigEndian.PutUint32(target[len(target)-4:], counter)
+	return target
+}
+
+func v4Prefix(id []byte) uint32 {
+	return binary.BigEndian.Uint32(id[:4]) >> (32 - v4PrefixBits)
+}
+
+func buildV4PrefixTable() {
+	v4PrefixTable = make([]uint32, 1<<v4PrefixBits)
+	filled := make([]bool, 1<<v4PrefixBits)
+	missing := len(v4PrefixTable)
+	for counter := uint32(0); missing > 0; counter++ {
+		target := v4PrefixPreimage(counter)
+		prefix := v4Prefix(crypto.Keccak256(target[:]))
+		if !filled[prefix] {
+			filled[prefix] = true
+			v4PrefixTable[prefix] = counter
+			missing--
+		}
+	}
+}
+
+// v4TargetAtDistance returns a FINDNODE target whose hash is at the given log distance
+// (above v4BucketMinDistance) from the node id
+func v4TargetAtDistance(id enode.ID, dist int) v4wire.Pubkey {
+	v4PrefixTableOnce.Do(buildV4PrefixTable)
+	// keep the bits shared with the id, flip the one at the distance and randomize the rest
+	var random [4]byte
+	rand.Read(random[:])
+	shared := 256 - dist
+	prefix := v4Prefix(id[:])
+	keepMask := ^uint32(0) << (v4PrefixBits - shared) & (1<<v4PrefixBits - 1)
+	flipBit := uint32(1) << (v4PrefixBits - shared - 1)
+	randomMask := flipBit - 1
+	prefix = (prefix & keepMask) | (^prefix & flipBit) | (binary.BigEndian.Uint32(random[:]) & randomMask)
+	return v4PrefixPreimage(v4PrefixTable[prefix])
+}
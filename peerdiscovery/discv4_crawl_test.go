@@ -0,0 +1,22 @@
+package peerdiscovery
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/stretchr/testify/require"
+)
+
+func TestV4TargetAtDistance(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		key, err := crypto.GenerateKey()
+		require.NoError(t, err)
+		id := enode.PubkeyToIDV4(&key.PublicKey)
+		for dist := v4BucketMinDistance + 1; dist <= 256; dist++ {
+			target := v4TargetAtDistance(id, dist)
+			hashed := enode.ID(crypto.Keccak256Hash(target[:]))
+			require.Equal(t, dist, enode.LogDist(id, hashed))
+		}
+	}
+}
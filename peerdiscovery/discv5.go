@@ -0,0 +1,184 @@
+package peerdiscovery
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"sync"
+	"time"
+
+	"github.com/cortze/ragno/models"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type Discv5 struct {
+	ctx       context.Context
+	port      int
+	bootnodes []string
+	privk     *ecdsa.PrivateKey
+	dbPath    string
+	discvType models.DiscoveryType
+	enrC      chan *models.ENR
+	// iterator of the random nodes, closed to unblock the discovery loop
+	rNodes enode.Iterator
+	doneC  chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewDiscv5 creates a discv5 discoverer that will bootstrap from the given list of
+// bootnodes (the default discv5 bootnodes are used if none is given). The node
+// database is kept at dbPath, or in memory if it is empty
+func NewDiscv5(
+	ctx context.Context, port int, bootnodes []string, privk *ecdsa.PrivateKey, dbPath string,
+) (*Discv5, error) {
+	logrus.Info("Using Discv5 peer discoverer")
+
+	if privk == nil {
+		var err error
+		privk, err = crypto.GenerateKey()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to generate discv5 key")
+		}
+	}
+	if len(bootnodes) == 0 {
+		bootnodes = params.V5Bootnodes
+	}
+	disc := &Discv5{
+		ctx:       ctx,
+		port:      port,
+		bootnodes: bootnodes,
+		privk:     privk,
+		dbPath:    dbPath,
+		enrC:      make(chan *models.ENR),
+		discvType: models.Discovery5,
+		doneC:     make(chan struct{}),
+	}
+	return disc, nil
+}
+
+func (d *Discv5) Run() (chan *models.ENR, error) {
+	d.wg.Add(1)
+	return d.runDiscv5Service()
+}
+
+func (d *Discv5) runDiscv5Service() (chan *models.ENR, error) {
+
+	bootnodes, err := models.ParseBootnodes(d.bootnodes)
+	if err != nil {
+		d.wg.Done()
+		return d.enrC, errors.Wrap(err, "unable to parse discv5 bootnodes")
+	}
+
+	ethDB, err := enode.OpenDB(d.dbPath)
+	if err != nil {
+		d.wg.Done()
+		return d.enrC, errors.Wrap(err, "unable to open discv5 enode db")
+	}
+
+	localNode := enode.NewLocalNode(ethDB, d.privk)
+	udpListener, err := listenUDP(d.port)
+	if err != nil {
+		ethDB.Close()
+		d.wg.Done()
+		return d.enrC, errors.Wrap(err, "unable to listen discv5 udp port")
+	}
+
+	discv5Options := discover.Config{
+		PrivateKey: d.privk,
+		Bootnodes:  bootnodes,
+	}
+	discoverer5, err := discover.ListenV5(udpListener, localNode, discv5Options)
+	if err != nil {
+		ethDB.Close()
+		d.wg.Done()
+		return d.enrC, errors.Wrap(err, "unable to launch discv5")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"addr":      udpListener.LocalAddr().String(),
+		"bootnodes": len(bootnodes),
+	}).Info("launching rango discv5")
+
+	// generate an iterator
+	rNodes := discoverer5.RandomNodes()
+	d.rNodes = rNodes
+
+	// actuall loop for crawling
+	go func() {
+		defer func() {
+			rNodes.Close()
+			discoverer5.Close()
+			ethDB.Close()
+			d.wg.Done()
+		}()
+	newENRloop:
+		for {
+			select {
+			case <-d.ctx.Done():
+				break newENRloop
+			case <-d.doneC:
+				break newENRloop
+			default:
+				if rNodes.Next() {
+					node := rNodes.Node()
+					// the discv5 network is shared with the CL, only keep the EL nodes
+					if !hasEthEntry(node) {
+						continue
+					}
+					logrus.WithFields(logrus.Fields{
+						"enr":    node.String(),
+						"ID":     node.ID(),
+						"IP":     node.IP(),
+						"UDP":    node.UDP(),
+						"TCP":    node.TCP(),
+						"seq":    node.Seq(),
+						"pubkey": models.PubkeyToString(node.Pubkey()),
+					}).Debug("new discv5 node")
+					enr, err := models.NewENR(
+						models.FromDiscv5(node),
+						models.WithTimestamp(time.Now()))
+					if err != nil {
+						logrus.Error(errors.Wrap(err, "unable to add new node"))
+						continue
+					}
+					d.notifyNewNode(enr)
+				}
+			}
+		}
+	}()
+	return d.enrC, nil
+}
+
+func (d *Discv5) notifyNewNode(enr *models.ENR) {
+	select {
+	case d.enrC <- enr:
+	case <-d.ctx.Done():
+	case <-d.doneC:
+	}
+}
+
+func (d *Discv5) Close() {
+	// notify of closure, the loop could be blocked waiting for the next node
+	close(d.doneC)
+	if d.rNodes != nil {
+		d.rNodes.Close()
+	}
+	d.wg.Wait()
+}
+
+func (d *Discv5) Type() models.DiscoveryType {
+	return models.Discovery5
+}
+
+// hasEthEntry checks whether the record of the node includes the "eth" entry
+// that the EL clients add to their ENRs (the CL nodes use "eth2" instead)
+func hasEthEntry(node *enode.Node) bool {
+	var ethEntry rlp.RawValue
+	return node.Load(enr.WithEntry("eth", &ethEntry)) == nil
+}
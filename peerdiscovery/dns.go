@@ -0,0 +1,202 @@
+package peerdiscovery
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cortze/ragno/models"
+	"github.com/ethereum/go-ethereum/p2p/dnsdisc"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// DNSTreeRefreshInterval is the time between each full walk of the DNS trees
+	DNSTreeRefreshInterval = 30 * time.Minute
+	// DNSTreeCacheLimit needs to fit the entries of the trees, or they'll be resolved several times
+	DNSTreeCacheLimit = 20000
+	// DNSTreeRateLimit is the max number of DNS requests per second
+	DNSTreeRateLimit = 20.0
+)
+
+// DefaultDNSTrees returns the list of EIP-1459 trees published for mainnet
+func DefaultDNSTrees() []string {
+	return models.MainnetNetworkDetails().DNSTrees
+}
+
+// DNS discovers nodes by walking the EIP-1459 DNS node lists
+type DNS struct {
+	ctx    context.Context
+	urls   []string
+	client *dnsdisc.Client
+	enrC   chan *models.ENR
+	doneC  chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewDNSDiscoverer creates a discoverer for the given list of enrtree:// urls. The resolver
+// is the one used to query the TXT records (the system DNS one is used if nil)
+func NewDNSDiscoverer(ctx context.Context, urls []string, resolver dnsdisc.Resolver) (*DNS, error) {
+	logrus.Info("Using DNS tree peer discoverer")
+
+	if len(urls) == 0 {
+		urls = DefaultDNSTrees()
+	}
+	if resolver == nil {
+		resolver = new(net.Resolver)
+	}
+	doneC := make(chan struct{})
+	client := dnsdisc.NewClient(dnsdisc.Config{
+		Resolver:   &closingResolver{resolver: resolver, doneC: doneC},
+		CacheLimit: DNSTreeCacheLimit,
+		RateLimit:  DNSTreeRateLimit,
+	})
+	disc := &DNS{
+		ctx:    ctx,
+		urls:   urls,
+		client: client,
+		enrC:   make(chan *models.ENR),
+		doneC:  doneC,
+	}
+	return disc, nil
+}
+
+func (d *DNS) Run() (chan *models.ENR, error) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(DNSTreeRefreshInterval)
+		defer ticker.Stop()
+		for {
+			for _, url := range d.urls {
+				if !d.walkTree(url) {
+					return
+				}
+			}
+			select {
+			case <-d.ctx.Done():
+				return
+			case <-d.doneC:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return d.enrC, nil
+}
+
+// walkTree syncs the whole tree behind the url, notifying each of its ENRs.
+// Returns false if the discoverer was closed in the meantime
+func (d *DNS) walkTree(url string) bool {
+	logEntry := logrus.WithField("tree", url)
+	t := time.Now()
+	// syncing a tree can't be interrupted, but its lookups fail once we are closing
+	type syncResult struct {
+		tree *dnsdisc.Tree
+		err  error
+	}
+	syncC := make(chan syncResult, 1)
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		tree, err := d.client.SyncTree(url)
+		select {
+		case syncC <- syncResult{tree, err}:
+		case <-d.doneC:
+		}
+	}()
+	var tree *dnsdisc.Tree
+	select {
+	case res := <-syncC:
+		if res.err != nil {
+			logEntry.Error(errors.Wrap(res.err, "unable to sync dns tree"))
+			return true
+		}
+		tree = res.tree
+	case <-d.ctx.Done():
+		return false
+	case <-d.doneC:
+		return false
+	}
+	nodes := tree.Nodes()
+	logEntry.WithFields(logrus.Fields{
+		"seq":      tree.Seq(),
+		"nodes":    len(nodes),
+		"duration": time.Since(t),
+	}).Info("dns tree synced")
+
+	for _, node := range nodes {
+		enr, err := models.NewENR(
+			models.FromDNSTree(node),
+			models.WithTimestamp(time.Now()))
+		if err != nil {
+			logEntry.Error(errors.Wrap(err, "unable to add new node"))
+			continue
+		}
+		select {
+		case d.enrC <- enr:
+		case <-d.ctx.Done():
+			return false
+		case <-d.doneC:
+			return false
+		}
+	}
+	return true
+}
+
+func (d *DNS) Close() {
+	// notify of closure
+	close(d.doneC)
+	d.wg.Wait()
+}
+
+func (d *DNS) Type() models.DiscoveryType {
+	return models.DNSTree
+}
+
+// --- Resolvers ---
+
+// closingResolver stops resolving the records once the discoverer is closed, so that
+// the tree syncs in flight finish without waiting for the rest of their lookups
+type closingResolver struct {
+	resolver dnsdisc.Resolver
+	doneC    chan struct{}
+}
+
+func (r *closingResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	select {
+	case <-r.doneC:
+		return nil, errors.New("dns discoverer closed")
+	default:
+	}
+	return r.resolver.LookupTXT(ctx, name)
+}
+
+// MapResolver serves the TXT records from memory (full domain name -> record)
+type MapResolver map[string]string
+
+func (m MapResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if record, ok := m[name]; ok {
+		return []string{record}, nil
+	}
+	return nil, errors.Errorf("no TXT record for %s", name)
+}
+
+// NewZoneFileResolver reads the TXT records from a JSON zone file, as the ones generated
+// by `devp2p dns to-txt` or published at github.com/ethereum/discv4-dns-lists
+func NewZoneFileResolver(file string) (MapResolver, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read dns zone file")
+	}
+	records := make(map[string]string)
+	err = json.Unmarshal(content, &records)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse dns zone file")
+	}
+	return MapResolver(records), nil
+}
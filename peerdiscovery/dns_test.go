@@ -0,0 +1,112 @@
+package peerdiscovery
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/dnsdisc"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortze/ragno/models"
+)
+
+func testNodes(t *testing.T, n int) []*enode.Node {
+	nodes := make([]*enode.Node, 0, n)
+	for i := 0; i < n; i++ {
+		key, err := crypto.GenerateKey()
+		require.NoError(t, err)
+		var r enr.Record
+		r.Set(enr.IP(net.IPv4(10, 0, 0, byte(i+1))))
+		r.Set(enr.UDP(30303))
+		r.Set(enr.TCP(30303))
+		require.NoError(t, enode.SignV4(&r, key))
+		node, err := enode.New(enode.ValidSchemes, &r)
+		require.NoError(t, err)
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+func TestDNSDiscovererWalksTree(t *testing.T) {
+	nodes := testNodes(t, 20)
+	tree, err := dnsdisc.MakeTree(1, nodes, nil)
+	require.NoError(t, err)
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	url, err := tree.Sign(key, "nodes.example.org")
+	require.NoError(t, err)
+
+	resolver := MapResolver(tree.ToTXT("nodes.example.org"))
+	disc, err := NewDNSDiscoverer(context.Background(), []string{url}, resolver)
+	require.NoError(t, err)
+	enrC, err := disc.Run()
+	require.NoError(t, err)
+	defer disc.Close()
+
+	seen := make(map[enode.ID]struct{})
+	timeout := time.After(10 * time.Second)
+	for len(seen) < len(nodes) {
+		select {
+		case enr := <-enrC:
+			require.Equal(t, models.DNSTree, enr.DiscType)
+			seen[enr.ID] = struct{}{}
+		case <-timeout:
+			t.Fatalf("only %d out of %d nodes were discovered", len(seen), len(nodes))
+		}
+	}
+	for _, node := range nodes {
+		_, ok := seen[node.ID()]
+		require.True(t, ok)
+	}
+}
+
+// slowResolver takes a while to answer each of the lookups
+type slowResolver struct {
+	MapResolver
+	delay time.Duration
+}
+
+func (r slowResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	time.Sleep(r.delay)
+	return r.MapResolver.LookupTXT(ctx, name)
+}
+
+func TestDNSDiscovererCloseDuringSync(t *testing.T) {
+	nodes := testNodes(t, 100)
+	tree, err := dnsdisc.MakeTree(1, nodes, nil)
+	require.NoError(t, err)
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	url, err := tree.Sign(key, "nodes.example.org")
+	require.NoError(t, err)
+
+	resolver := slowResolver{MapResolver(tree.ToTXT("nodes.example.org")), 50 * time.Millisecond}
+	disc, err := NewDNSDiscoverer(context.Background(), []string{url}, resolver)
+	require.NoError(t, err)
+	_, err = disc.Run()
+	require.NoError(t, err)
+	time.Sleep(200 * time.Millisecond)
+
+	// the sync of the whole tree would take several seconds
+	closedC := make(chan struct{})
+	go func() {
+		disc.Close()
+		close(closedC)
+	}()
+	select {
+	case <-closedC:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the dns discoverer didn't close while syncing the tree")
+	}
+}
+
+func TestMapResolverMissingRecord(t *testing.T) {
+	resolver := MapResolver{"nodes.example.org": "enrtree-root:v1"}
+	_, err := resolver.LookupTXT(context.Background(), "missing.example.org")
+	require.Error(t, err)
+}
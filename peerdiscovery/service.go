@@ -2,11 +2,15 @@ package peerdiscovery
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/cortze/ragno/db"
 	"github.com/cortze/ragno/models"
+	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -20,6 +24,14 @@ type Discoverer interface {
 	Close()
 }
 
+// TopologyDiscoverer is a Discoverer that also reports which nodes each node returned
+// as its neighbours
+type TopologyDiscoverer interface {
+	Discoverer
+	// Neighbours returns the channel where the neighbours of each crawled node are notified
+	Neighbours() chan *models.Neighbours
+}
+
 func StringToDiscoveryType(s string) models.DiscoveryType {
 	var discvType models.DiscoveryType = models.UnknownDiscovery
 	switch {
@@ -27,6 +39,12 @@ func StringToDiscoveryType(s string) models.DiscoveryType {
 		discvType = models.CsvFile
 	case s == "discv4":
 		discvType = models.Discovery4
+	case s == "discv5":
+		discvType = models.Discovery5
+	case s == "discv4-crawl":
+		discvType = models.Discovery4Crawl
+	case strings.HasPrefix(s, "enrtree://"), s == "dns":
+		discvType = models.DNSTree
 	default:
 		// do nothing
 	}
@@ -40,6 +58,12 @@ func DiscoveryTypeToString(t models.DiscoveryType) string {
 		discvType = "csv-file"
 	case models.Discovery4:
 		discvType = "discv4"
+	case models.Discovery5:
+		discvType = "discv5"
+	case models.DNSTree:
+		discvType = "dns"
+	case models.Discovery4Crawl:
+		discvType = "discv4-crawl"
 	default:
 		// do nothing
 	}
@@ -47,47 +71,85 @@ func DiscoveryTypeToString(t models.DiscoveryType) string {
 }
 
 // Main peer discovery service that identifies new peers in the network
+// merging the ENRs from all the given discoverers
 type PeerDiscovery struct {
-	ctx   context.Context
-	discv Discoverer
-	db    *db.PostgresDBService
-	doneC chan struct{}
-	wg    sync.WaitGroup
+	ctx    context.Context
+	discvs []Discoverer
+	db     db.Storage
+	doneC  chan struct{}
+	wg     sync.WaitGroup
+
+	// number of ENRs received from each of the discoverers (same index), and the
+	// name under which each of them is reported
+	counters []*atomic.Uint64
+	sources  []string
+	// unique nodes received from all the sources
+	nodesM sync.Mutex
+	nodes  map[enode.ID]struct{}
 }
 
-func NewPeerDiscovery(ctx context.Context, discv Discoverer, database *db.PostgresDBService) (*PeerDiscovery, error) {
+func NewPeerDiscovery(ctx context.Context, database db.Storage, discvs ...Discoverer) (*PeerDiscovery, error) {
+	if len(discvs) == 0 {
+		return nil, errors.New("no discoverer was given to the peer discovery")
+	}
+	counters := make([]*atomic.Uint64, len(discvs))
+	sources := make([]string, len(discvs))
+	// the discoverers of a repeated type (e.g. several csv files) get a numbered name
+	byType := make(map[models.DiscoveryType]int)
+	for idx, discv := range discvs {
+		counters[idx] = new(atomic.Uint64)
+		sources[idx] = DiscoveryTypeToString(discv.Type())
+		if n := byType[discv.Type()]; n > 0 {
+			sources[idx] = fmt.Sprintf("%s-%d", sources[idx], n)
+		}
+		byType[discv.Type()]++
+	}
 	service := &PeerDiscovery{
-		ctx:   ctx,
-		discv: discv,
-		db:    database,
-		doneC: make(chan struct{}),
-		wg:    sync.WaitGroup{},
+		ctx:      ctx,
+		discvs:   discvs,
+		db:       database,
+		doneC:    make(chan struct{}),
+		wg:       sync.WaitGroup{},
+		counters: counters,
+		sources:  sources,
+		nodes:    make(map[enode.ID]struct{}),
 	}
 	return service, nil
 }
 
 func (d *PeerDiscovery) Run() error {
-	newENRs, err := d.discv.Run()
-	if err != nil {
-		return errors.Wrap(err, "unable to init "+DiscoveryTypeToString(d.discv.Type()))
+	for idx, discv := range d.discvs {
+		newENRs, err := discv.Run()
+		if err != nil {
+			// the ones that we already started are stopped by Close, along with the rest
+			return errors.Wrap(err, "unable to init "+DiscoveryTypeToString(discv.Type()))
+		}
+		d.wg.Add(1)
+		go d.discoverPeers(idx, newENRs)
+		if topologyDiscv, ok := discv.(TopologyDiscoverer); ok {
+			d.wg.Add(1)
+			go d.persistNeighbours(topologyDiscv.Neighbours())
+		}
 	}
-	d.wg.Add(1)
-	go d.discoverPeers(newENRs)
-
 	return nil
 }
 
-func (d *PeerDiscovery) discoverPeers(newENRc chan *models.ENR) {
+func (d *PeerDiscovery) discoverPeers(idx int, newENRc chan *models.ENR) {
 	defer d.wg.Done()
 	log := logrus.WithFields(logrus.Fields{
-		"discovery-service": DiscoveryTypeToString(d.discv.Type()),
+		"discovery-service": d.sources[idx],
 	})
 	log.Info("starting peer discovery")
+	counter := d.counters[idx]
 
 	for {
 		select {
 		case enr := <-newENRc:
 			log.WithField("node-id", enr.ID.String()).Trace("new ENR")
+			counter.Add(1)
+			d.nodesM.Lock()
+			d.nodes[enr.ID] = struct{}{}
+			d.nodesM.Unlock()
 			d.db.PersistENR(enr)
 
 		case <-d.doneC:
@@ -99,9 +161,70 @@ func (d *PeerDiscovery) discoverPeers(newENRc chan *models.ENR) {
 	}
 }
 
+func (d *PeerDiscovery) persistNeighbours(neighboursC chan *models.Neighbours) {
+	defer d.wg.Done()
+	for {
+		select {
+		case neighbours := <-neighboursC:
+			d.db.PersistNeighbours(neighbours)
+
+		case <-d.doneC:
+			return
+
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+// ENRsBySource returns the number of ENRs that each of the discovery sources reported
+func (d *PeerDiscovery) ENRsBySource() map[string]uint64 {
+	summary := make(map[string]uint64, len(d.counters))
+	for idx, counter := range d.counters {
+		summary[d.sources[idx]] = counter.Load()
+	}
+	return summary
+}
+
+// DiscoveredNodes returns the number of unique nodes received from the discovery sources
+func (d *PeerDiscovery) DiscoveredNodes() int {
+	d.nodesM.Lock()
+	defer d.nodesM.Unlock()
+	return len(d.nodes)
+}
+
+// LastCrawlRound returns the last finished round of the discv4 crawler, nil if it isn't
+// one of the sources or it didn't finish any round yet
+func (d *PeerDiscovery) LastCrawlRound() *CrawlRound {
+	for _, discv := range d.discvs {
+		if crawler, ok := discv.(*Discv4Crawl); ok {
+			return crawler.LastRound()
+		}
+	}
+	return nil
+}
+
 func (d *PeerDiscovery) Close() {
-	d.discv.Close()
-	d.doneC <- struct{}{}
-	d.wg.Wait()
+	// stop the sources first, so that none of them gets stuck notifying a new ENR
+	for _, discv := range d.discvs {
+		logrus.Infof("closing %s peer discoverer", DiscoveryTypeToString(discv.Type()))
+		discv.Close()
+	}
 	close(d.doneC)
+	d.wg.Wait()
+	logrus.WithFields(logrus.Fields{
+		"enrs-by-source": d.ENRsBySource(),
+	}).Info("peer discovery closed")
+}
+
+// listenUDP opens a dual-stack udp socket at the given port, so that the discovery can
+// reach the nodes that only announce an IPv6. It falls back to IPv4 if the host doesn't
+// support IPv6
+func listenUDP(port int) (*net.UDPConn, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6unspecified, Port: port})
+	if err == nil {
+		return conn, nil
+	}
+	logrus.Warn(errors.Wrap(err, "unable to listen dual-stack udp port, falling back to IPv4"))
+	return net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: port})
 }
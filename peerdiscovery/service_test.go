@@ -0,0 +1,45 @@
+package peerdiscovery
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cortze/ragno/models"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/dnsdisc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerDiscoveryCloseAfterFailedRun(t *testing.T) {
+	tree, err := dnsdisc.MakeTree(1, nil, nil)
+	require.NoError(t, err)
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	url, err := tree.Sign(key, "nodes.example.org")
+	require.NoError(t, err)
+	dnsDiscv, err := NewDNSDiscoverer(context.Background(), []string{url}, MapResolver(tree.ToTXT("nodes.example.org")))
+	require.NoError(t, err)
+	// fails to start, after the dns discoverer was started
+	crawlDiscv, err := NewDiscv4Crawl(context.Background(), 0, []string{"not-a-bootnode"}, nil)
+	require.NoError(t, err)
+
+	discovery, err := NewPeerDiscovery(context.Background(), nil, dnsDiscv, crawlDiscv)
+	require.NoError(t, err)
+	require.Error(t, discovery.Run())
+	require.NotPanics(t, discovery.Close)
+}
+
+type typedDiscoverer models.DiscoveryType
+
+func (t typedDiscoverer) Run() (chan *models.ENR, error) { return make(chan *models.ENR), nil }
+func (t typedDiscoverer) Type() models.DiscoveryType     { return models.DiscoveryType(t) }
+func (t typedDiscoverer) Close()                         {}
+
+func TestPeerDiscoveryCountersByDiscoverer(t *testing.T) {
+	discovery, err := NewPeerDiscovery(context.Background(), nil,
+		typedDiscoverer(models.CsvFile), typedDiscoverer(models.DNSTree), typedDiscoverer(models.CsvFile))
+	require.NoError(t, err)
+	discovery.counters[0].Add(2)
+	discovery.counters[2].Add(3)
+	require.Equal(t, map[string]uint64{"csv-file": 2, "dns": 0, "csv-file-1": 3}, discovery.ENRsBySource())
+}
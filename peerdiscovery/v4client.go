@@ -0,0 +1,286 @@
+package peerdiscovery
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/discover/v4wire"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/netutil"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// discv4 wire constants (same ones as geth's discv4 implementation)
+	v4RespTimeout   = 500 * time.Millisecond
+	v4Expiration    = 20 * time.Second
+	v4MaxPacketSize = 1280
+	v4BucketSize    = 16
+)
+
+var (
+	ErrV4Timeout = errors.New("discv4 request timeout")
+	ErrV4Closed  = errors.New("discv4 client closed")
+)
+
+type v4MatcherKey struct {
+	id   enode.ID
+	kind byte
+}
+
+// v4Client is a minimal discv4 client that exposes the PING and FINDNODE requests, so
+// that we can ask any given node for the content of each of its buckets
+type v4Client struct {
+	conn        *net.UDPConn
+	privk       *ecdsa.PrivateKey
+	ourEndpoint v4wire.Endpoint
+
+	m        sync.Mutex
+	matchers map[v4MatcherKey][]chan v4wire.Packet
+
+	closeC chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newV4Client(conn *net.UDPConn, privk *ecdsa.PrivateKey) *v4Client {
+	c := &v4Client{
+		conn:        conn,
+		privk:       privk,
+		ourEndpoint: v4wire.NewEndpoint(conn.LocalAddr().(*net.UDPAddr), 0),
+		matchers:    make(map[v4MatcherKey][]chan v4wire.Packet),
+		closeC:      make(chan struct{}),
+	}
+	c.wg.Add(1)
+	go c.readLoop()
+	return c
+}
+
+func (c *v4Client) readLoop() {
+	defer c.wg.Done()
+	buf := make([]byte, v4MaxPacketSize)
+	for {
+		nbytes, from, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-c.closeC:
+			default:
+				logrus.Debug(errors.Wrap(err, "discv4 client read error"))
+			}
+			return
+		}
+		c.handlePacket(from, buf[:nbytes])
+	}
+}
+
+func (c *v4Client) handlePacket(from *net.UDPAddr, raw []byte) {
+	packet, fromKey, hash, err := v4wire.Decode(raw)
+	if err != nil {
+		logrus.Trace(errors.Wrap(err, "unable to decode discv4 packet"))
+		return
+	}
+	fromID := fromKey.ID()
+	switch p := packet.(type) {
+	case *v4wire.Ping:
+		if v4wire.Expired(p.Expiration) {
+			return
+		}
+		// answering the ping gives the remote node the endpoint proof it needs to
+		// reply to our FINDNODE requests
+		c.send(from, &v4wire.Pong{
+			To:         v4wire.NewEndpoint(from, p.From.TCP),
+			ReplyTok:   hash,
+			Expiration: uint64(time.Now().Add(v4Expiration).Unix()),
+		})
+	case *v4wire.Pong:
+		if v4wire.Expired(p.Expiration) {
+			return
+		}
+	case *v4wire.Neighbors:
+		if v4wire.Expired(p.Expiration) {
+			return
+		}
+	default:
+		// we don't serve any other request
+		return
+	}
+	c.dispatch(v4MatcherKey{id: fromID, kind: packet.Kind()}, packet)
+}
+
+func (c *v4Client) dispatch(key v4MatcherKey, packet v4wire.Packet) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for _, matchC := range c.matchers[key] {
+		select {
+		case matchC <- packet:
+		default:
+		}
+	}
+}
+
+func (c *v4Client) addMatcher(key v4MatcherKey) chan v4wire.Packet {
+	matchC := make(chan v4wire.Packet, v4BucketSize)
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.matchers[key] = append(c.matchers[key], matchC)
+	return matchC
+}
+
+func (c *v4Client) removeMatcher(key v4MatcherKey, matchC chan v4wire.Packet) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	matchers := c.matchers[key]
+	for idx, m := range matchers {
+		if m == matchC {
+			matchers = append(matchers[:idx], matchers[idx+1:]...)
+			break
+		}
+	}
+	if len(matchers) == 0 {
+		delete(c.matchers, key)
+	} else {
+		c.matchers[key] = matchers
+	}
+}
+
+func (c *v4Client) send(to *net.UDPAddr, packet v4wire.Packet) ([]byte, error) {
+	raw, hash, err := v4wire.Encode(c.privk, packet)
+	if err != nil {
+		return hash, err
+	}
+	_, err = c.conn.WriteToUDP(raw, to)
+	return hash, err
+}
+
+// bond pings the remote node and waits for its pong, giving it some time to ping us back,
+// so that both sides have a valid endpoint proof
+func (c *v4Client) bond(n *enode.Node) error {
+	addr := &net.UDPAddr{IP: n.IP(), Port: n.UDP()}
+	pongKey := v4MatcherKey{id: n.ID(), kind: v4wire.PongPacket}
+	pingKey := v4MatcherKey{id: n.ID(), kind: v4wire.PingPacket}
+	pongC := c.addMatcher(pongKey)
+	defer c.removeMatcher(pongKey, pongC)
+	pingC := c.addMatcher(pingKey)
+	defer c.removeMatcher(pingKey, pingC)
+
+	hash, err := c.send(addr, &v4wire.Ping{
+		Version:    4,
+		From:       c.ourEndpoint,
+		To:         v4wire.NewEndpoint(addr, uint16(n.TCP())),
+		Expiration: uint64(time.Now().Add(v4Expiration).Unix()),
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to send ping")
+	}
+	timeout := time.NewTimer(2 * v4RespTimeout)
+	defer timeout.Stop()
+waitPong:
+	for {
+		select {
+		case packet := <-pongC:
+			if bytes.Equal(packet.(*v4wire.Pong).ReplyTok, hash) {
+				break waitPong
+			}
+		case <-timeout.C:
+			return ErrV4Timeout
+		case <-c.closeC:
+			return ErrV4Closed
+		}
+	}
+	// wait for the remote ping (if it doesn't have a recent proof from us)
+	timeout.Reset(v4RespTimeout)
+	select {
+	case <-pingC:
+	case <-timeout.C:
+	case <-c.closeC:
+		return ErrV4Closed
+	}
+	return nil
+}
+
+// findnode asks the remote node for the nodes closest to the given target, returning
+// the content of the bucket at which the target falls
+func (c *v4Client) findnode(n *enode.Node, target v4wire.Pubkey) ([]*enode.Node, error) {
+	addr := &net.UDPAddr{IP: n.IP(), Port: n.UDP()}
+	// the replies are matched by node and kind, so a late reply to a previous request
+	// is told apart by the bucket of the nodes it carries
+	bucket := v4Bucket(enode.LogDist(n.ID(), enode.ID(crypto.Keccak256Hash(target[:]))))
+	key := v4MatcherKey{id: n.ID(), kind: v4wire.NeighborsPacket}
+	neighborsC := c.addMatcher(key)
+	defer c.removeMatcher(key, neighborsC)
+
+	_, err := c.send(addr, &v4wire.Findnode{
+		Target:     target,
+		Expiration: uint64(time.Now().Add(v4Expiration).Unix()),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to send findnode")
+	}
+
+	nodes := make([]*enode.Node, 0, v4BucketSize)
+	received := false
+	// the neighbors might arrive in several packets
+	timeout := time.NewTimer(2 * v4RespTimeout)
+	defer timeout.Stop()
+	for len(nodes) < v4BucketSize {
+		select {
+		case packet := <-neighborsC:
+			received = true
+			for _, rn := range packet.(*v4wire.Neighbors).Nodes {
+				node, err := nodeFromRPC(addr, rn)
+				if err != nil {
+					logrus.Trace(errors.Wrap(err, "invalid neighbor"))
+					continue
+				}
+				if v4Bucket(enode.LogDist(n.ID(), node.ID())) != bucket {
+					logrus.Trace("neighbor out of the requested bucket")
+					continue
+				}
+				nodes = append(nodes, node)
+			}
+			timeout.Reset(v4RespTimeout)
+		case <-timeout.C:
+			if !received {
+				return nodes, ErrV4Timeout
+			}
+			return nodes, nil
+		case <-c.closeC:
+			return nodes, ErrV4Closed
+		}
+	}
+	return nodes, nil
+}
+
+func (c *v4Client) close() {
+	close(c.closeC)
+	c.conn.Close()
+	c.wg.Wait()
+}
+
+// v4Bucket returns the index of the geth bucket that holds the nodes at the given log
+// distance from the node
+func v4Bucket(dist int) int {
+	if dist <= v4BucketMinDistance {
+		return 0
+	}
+	return dist - v4BucketMinDistance - 1
+}
+
+func nodeFromRPC(sender *net.UDPAddr, rn v4wire.Node) (*enode.Node, error) {
+	if rn.UDP <= 1024 {
+		return nil, errors.New("low port")
+	}
+	if err := netutil.CheckRelayIP(sender.IP, rn.IP); err != nil {
+		return nil, err
+	}
+	pubkey, err := v4wire.DecodePubkey(crypto.S256(), rn.ID)
+	if err != nil {
+		return nil, err
+	}
+	node := enode.NewV4(pubkey, rn.IP, int(rn.TCP), int(rn.UDP))
+	return node, node.ValidateComplete()
+}
@@ -0,0 +1,70 @@
+package peerdiscovery
+
+import (
+	"crypto/ecdsa"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/discover/v4wire"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/stretchr/testify/require"
+)
+
+func TestV4FindnodeDropsOtherBuckets(t *testing.T) {
+	clientConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	clientKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	client := newV4Client(clientConn, clientKey)
+	defer client.close()
+
+	remoteConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer remoteConn.Close()
+	remoteKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	remoteAddr := remoteConn.LocalAddr().(*net.UDPAddr)
+	remote := enode.NewV4(&remoteKey.PublicKey, remoteAddr.IP, 0, remoteAddr.Port)
+
+	// the late reply to a previous request at distance 255 carries both nodes
+	current := v4KeyAtDistance(t, remote.ID(), 256)
+	late := v4KeyAtDistance(t, remote.ID(), 255)
+	go func() {
+		buf := make([]byte, v4MaxPacketSize)
+		_, from, err := remoteConn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		neighbors := &v4wire.Neighbors{Expiration: uint64(time.Now().Add(v4Expiration).Unix())}
+		for _, key := range []*ecdsa.PrivateKey{late, current} {
+			neighbors.Nodes = append(neighbors.Nodes, v4wire.Node{
+				IP:  net.IPv4(127, 0, 0, 1),
+				UDP: 30303,
+				TCP: 30303,
+				ID:  v4wire.EncodePubkey(&key.PublicKey),
+			})
+		}
+		raw, _, err := v4wire.Encode(remoteKey, neighbors)
+		if err != nil {
+			return
+		}
+		remoteConn.WriteToUDP(raw, from)
+	}()
+
+	nodes, err := client.findnode(remote, v4TargetAtDistance(remote.ID(), 256))
+	require.NoError(t, err)
+	require.Len(t, nodes, 1)
+	require.Equal(t, enode.PubkeyToIDV4(&current.PublicKey), nodes[0].ID())
+}
+
+func v4KeyAtDistance(t *testing.T, id enode.ID, dist int) *ecdsa.PrivateKey {
+	for {
+		key, err := crypto.GenerateKey()
+		require.NoError(t, err)
+		if enode.LogDist(id, enode.PubkeyToIDV4(&key.PublicKey)) == dist {
+			return key
+		}
+	}
+}
@@ -27,8 +27,8 @@ const (
 
 var ErrTooManyRequests error = fmt.Errorf("error HTTP 429")
 
-// DB Interface for PostgresDBService
-type PostgresDBService interface {
+// IPStorage is the part of the db.Storage that the IP locator needs
+type IPStorage interface {
 	PersistIPInfo(models.IPInfo)
 	GetIPInfo(string) (models.IPInfo, error)
 	CheckIPRecords(string) (bool, bool, error)
@@ -42,7 +42,7 @@ type IPLocator struct {
 	locationRequest chan string
 
 	// dbClient
-	dbClient PostgresDBService
+	dbClient IPStorage
 
 	// API url
 	IPAPIUrl string
@@ -52,7 +52,7 @@ type IPLocator struct {
 	apiCalls *int32
 }
 
-func NewIPLocator(ctx context.Context, dbCli PostgresDBService, IPAPIUrl string) *IPLocator {
+func NewIPLocator(ctx context.Context, dbCli IPStorage, IPAPIUrl string) *IPLocator {
 	calls := int32(0)
 	return &IPLocator{
 		ctx:             ctx,
@@ -0,0 +1,80 @@
+package topology
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/pkg/errors"
+
+	"github.com/cortze/ragno/models"
+)
+
+const (
+	GraphMLFormat = "graphml"
+	DOTFormat     = "dot"
+)
+
+// WriteGraph exports the neighbour edges as a directed graph in the given format
+func WriteGraph(w io.Writer, format string, edges []models.NeighbourEdge) error {
+	switch strings.ToLower(format) {
+	case GraphMLFormat:
+		return WriteGraphML(w, edges)
+	case DOTFormat:
+		return WriteDOT(w, edges)
+	default:
+		return errors.Errorf("unknown graph format %s", format)
+	}
+}
+
+// WriteDOT exports the neighbour edges as a Graphviz digraph
+func WriteDOT(w io.Writer, edges []models.NeighbourEdge) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph neighbours {")
+	for _, edge := range edges {
+		fmt.Fprintf(bw, "\t\"%s\" -> \"%s\" [seen_at=\"%s\"];\n",
+			edge.Source.String(), edge.Neighbour.String(), edge.SeenAt.UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// WriteGraphML exports the neighbour edges as a GraphML directed graph
+func WriteGraphML(w io.Writer, edges []models.NeighbourEdge) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(bw, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+	fmt.Fprintln(bw, `  <key id="seen_at" for="edge" attr.name="seen_at" attr.type="string"/>`)
+	fmt.Fprintln(bw, `  <graph id="neighbours" edgedefault="directed">`)
+	for _, id := range nodeIDs(edges) {
+		fmt.Fprintf(bw, "    <node id=\"%s\"/>\n", id.String())
+	}
+	for idx, edge := range edges {
+		fmt.Fprintf(bw, "    <edge id=\"e%d\" source=\"%s\" target=\"%s\">\n",
+			idx, edge.Source.String(), edge.Neighbour.String())
+		fmt.Fprintf(bw, "      <data key=\"seen_at\">%s</data>\n", edge.SeenAt.UTC().Format(time.RFC3339))
+		fmt.Fprintln(bw, "    </edge>")
+	}
+	fmt.Fprintln(bw, "  </graph>")
+	fmt.Fprintln(bw, "</graphml>")
+	return bw.Flush()
+}
+
+// nodeIDs returns the distinct nodes of the edges in order of appearance
+func nodeIDs(edges []models.NeighbourEdge) []enode.ID {
+	seen := make(map[enode.ID]struct{})
+	ids := make([]enode.ID, 0)
+	for _, edge := range edges {
+		for _, id := range []enode.ID{edge.Source, edge.Neighbour} {
+			if _, ok := seen[id]; ok {
+				continue
+			}
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,45 @@
+package topology
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortze/ragno/models"
+)
+
+func TestWriteGraph(t *testing.T) {
+	a := enode.HexID("0x01" + strings.Repeat("00", 31))
+	b := enode.HexID("0x02" + strings.Repeat("00", 31))
+	c := enode.HexID("0x03" + strings.Repeat("00", 31))
+	seenAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	edges := []models.NeighbourEdge{
+		{Source: a, Neighbour: b, SeenAt: seenAt},
+		{Source: a, Neighbour: c, SeenAt: seenAt},
+		{Source: b, Neighbour: c, SeenAt: seenAt},
+	}
+
+	var dot bytes.Buffer
+	require.NoError(t, WriteGraph(&dot, DOTFormat, edges))
+	require.Equal(t, 3, strings.Count(dot.String(), "->"))
+	require.Contains(t, dot.String(), "\""+a.String()+"\" -> \""+b.String()+"\"")
+
+	var graphml bytes.Buffer
+	require.NoError(t, WriteGraph(&graphml, GraphMLFormat, edges))
+	var parsed struct {
+		Graph struct {
+			Nodes []struct{} `xml:"node"`
+			Edges []struct{} `xml:"edge"`
+		} `xml:"graph"`
+	}
+	require.NoError(t, xml.Unmarshal(graphml.Bytes(), &parsed))
+	require.Len(t, parsed.Graph.Nodes, 3)
+	require.Len(t, parsed.Graph.Edges, 3)
+
+	require.Error(t, WriteGraph(&dot, "svg", edges))
+}